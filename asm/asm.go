@@ -0,0 +1,778 @@
+// Package asm is a small 6502 assembler for a subset of ca65 syntax.  It is
+// meant for small patches and tests, not whole programs.
+//
+// Supported:
+//
+//   - All official addressing modes.  Unofficial opcodes after
+//     .setcpu "6502X".  a: and z: force absolute and zero page.
+//   - Labels, @cheap local labels, unnamed labels (: with :+ and :-)
+//   - Constants with = and :=
+//   - .byte (.db), .word (.dw, .addr), .res, .org, .charmap, .include, and
+//     .incbin.  .segment is ignored.
+//   - Expressions with + - | and * / & ^ << >> (binary ^ is XOR, and
+//     products bind tighter than sums), unary - ~, < > ^ for the low, high,
+//     and bank bytes, * for the current address, and parentheses.  % is
+//     only the binary number prefix.  .MOD isn't supported.
+//
+// Forward references are assumed to be absolute, same as ca65.
+package asm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zorchenhimer/go-nes/disasm"
+)
+
+// Chunk is a run of bytes starting at a CPU address.  A new chunk is started
+// for every .org.
+type Chunk struct {
+	Address uint16
+	Data    []byte
+}
+
+// Program is the output of the assembler.
+type Program struct {
+	Chunks  []Chunk
+	Symbols map[string]int // Labels and constants.  Local labels are "global@local".
+}
+
+// Error is an assembly error with its location.
+type Error struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// AssembleFile assembles a file.  See Assemble() for org.
+func AssembleFile(filename string, org int) (*Program, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Assemble(src, filename, org)
+}
+
+// Assemble assembles source.  The filename is used for errors and to find
+// included files.  If org is negative the source must start with .org.
+func Assemble(source []byte, filename string, org int) (*Program, error) {
+	a := &assembler{
+		labels: make(map[string]int),
+		consts: make(map[string]*constant),
+	}
+
+	lines, err := a.parseFile(source, filename, 0)
+	if err != nil {
+		return nil, err
+	}
+	a.lines = lines
+
+	// The first pass finds label addresses and instruction sizes.  The
+	// second pass writes the bytes.
+	for pass := 1; pass <= 2; pass++ {
+		if err := a.pass(org, pass == 2); err != nil {
+			return nil, err
+		}
+	}
+
+	prog := &Program{Chunks: a.chunks, Symbols: make(map[string]int)}
+	for name, val := range a.labels {
+		prog.Symbols[name] = val
+	}
+	for name := range a.consts {
+		if val, err := a.lookup(name, ""); err == nil {
+			prog.Symbols[name] = val
+		}
+	}
+	return prog, nil
+}
+
+// line is a single parsed source line.
+type line struct {
+	file string
+	num  int
+
+	labels []string // "" for an unnamed label
+	assign string   // constant name for "name = expr"
+	op     string   // lowercase mnemonic or directive
+	args   string   // everything after op, or the expression for assign
+}
+
+type constant struct {
+	expr string
+	ctx  evalCtx
+	busy bool // for catching loops
+}
+
+type assembler struct {
+	lines []*line
+
+	labels  map[string]int
+	consts  map[string]*constant
+	anon    []int // unnamed label addresses in order
+	charmap [256]byte
+	illegal bool
+
+	sizes  map[*line]int  // instruction sizes from the first pass
+	modes  map[*line]byte // opcodes from the first pass
+	final  bool
+	chunks []Chunk
+}
+
+const maxIncludeDepth = 16
+
+func (a *assembler) parseFile(source []byte, filename string, depth int) ([]*line, error) {
+	if depth > maxIncludeDepth {
+		return nil, fmt.Errorf("%s: includes nested too deep", filename)
+	}
+
+	lines := []*line{}
+	for i, text := range strings.Split(string(source), "\n") {
+		l, err := parseLine(text)
+		if err != nil {
+			return nil, &Error{File: filename, Line: i + 1, Err: err}
+		}
+		l.file = filename
+		l.num = i + 1
+
+		if l.op != ".include" {
+			lines = append(lines, l)
+			continue
+		}
+
+		// Labels on the include line stay on their own line.
+		name, err := unquote(l.args)
+		if err != nil {
+			return nil, &Error{File: filename, Line: i + 1, Err: err}
+		}
+		l.op, l.args = "", ""
+		lines = append(lines, l)
+
+		name = filepath.Join(filepath.Dir(filename), name)
+		inc, err := os.ReadFile(name)
+		if err != nil {
+			return nil, &Error{File: filename, Line: i + 1, Err: err}
+		}
+
+		incLines, err := a.parseFile(inc, name, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, incLines...)
+	}
+
+	return lines, nil
+}
+
+// parseLine splits a line into labels, the op, and its arguments.
+func parseLine(text string) (*line, error) {
+	l := &line{}
+	text = strings.TrimSpace(stripComment(text))
+
+	for text != "" {
+		// Unnamed label
+		if text[0] == ':' && (len(text) == 1 || (text[1] != '=' && text[1] != '+' && text[1] != '-')) {
+			l.labels = append(l.labels, "")
+			text = strings.TrimSpace(text[1:])
+			continue
+		}
+
+		end := 0
+		for end < len(text) && isIdentChar(text[end]) {
+			end++
+		}
+		if end == 0 || end >= len(text) || text[end] != ':' || (end+1 < len(text) && text[end+1] == '=') {
+			break
+		}
+
+		l.labels = append(l.labels, text[:end])
+		text = strings.TrimSpace(text[end+1:])
+	}
+
+	if text == "" {
+		return l, nil
+	}
+
+	// Constants: "name = expr" or "name := expr"
+	if idx := strings.Index(text, "="); idx > 0 {
+		name := strings.TrimSpace(strings.TrimSuffix(text[:idx], ":"))
+		if isIdent(name) {
+			l.assign = name
+			l.args = strings.TrimSpace(text[idx+1:])
+			return l, nil
+		}
+	}
+
+	fields := strings.SplitN(strings.ReplaceAll(text, "\t", " "), " ", 2)
+	l.op = strings.ToLower(fields[0])
+	if len(fields) > 1 {
+		l.args = strings.TrimSpace(fields[1])
+	}
+	return l, nil
+}
+
+func isIdent(s string) bool {
+	if s == "" || !isIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isIdentChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// stripComment removes a trailing comment, ignoring semicolons in strings and
+// character constants.
+func stripComment(text string) string {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"':
+			quote = c
+		case c == '\'' && i+2 < len(text) && text[i+2] == '\'':
+			i += 2
+		case c == ';':
+			return text[:i]
+		}
+	}
+	return text
+}
+
+// splitArgs splits on commas that aren't in strings or parentheses.
+func splitArgs(text string) []string {
+	args := []string{}
+	depth := 0
+	quote := byte(0)
+	start := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"':
+			quote = c
+		case c == '\'' && i+2 < len(text) && text[i+2] == '\'':
+			i += 2
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(text[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(text[start:]))
+}
+
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return "", fmt.Errorf("Expected a string: %s", s)
+	}
+	return s[1 : len(s)-1], nil
+}
+
+// lookup returns the value of a label or constant.
+func (a *assembler) lookup(name, scope string) (int, error) {
+	if strings.HasPrefix(name, "@") {
+		name = scope + name
+	}
+
+	if val, ok := a.labels[name]; ok {
+		return val, nil
+	}
+
+	c, ok := a.consts[name]
+	if !ok {
+		return 0, errUnknown{name}
+	}
+
+	if c.busy {
+		return 0, fmt.Errorf("Circular definition of %q", name)
+	}
+
+	c.busy = true
+	defer func() { c.busy = false }()
+	return c.ctx.eval(c.expr)
+}
+
+// unnamed returns the address of an unnamed label relative to the number
+// defined so far.
+func (a *assembler) unnamed(defined int, dir byte, count int) (int, error) {
+	idx := defined - count
+	if dir == '+' {
+		idx = defined + count - 1
+	}
+
+	if idx < 0 || idx >= len(a.anon) {
+		return 0, errUnknown{":" + strings.Repeat(string(dir), count)}
+	}
+	return a.anon[idx], nil
+}
+
+// pass runs through the source once.  In the final pass every symbol must be
+// known and the bytes are written.
+func (a *assembler) pass(org int, final bool) error {
+	a.final = final
+	a.charmap = [256]byte{}
+	for i := range a.charmap {
+		a.charmap[i] = byte(i)
+	}
+	a.illegal = false
+	a.chunks = nil
+	if !final {
+		a.sizes = make(map[*line]int)
+		a.modes = make(map[*line]byte)
+	}
+
+	pc := org
+	if org >= 0 {
+		a.chunks = []Chunk{{Address: uint16(org)}}
+	}
+
+	scope := ""
+	anon := 0
+	for _, l := range a.lines {
+		fail := func(err error) error {
+			return &Error{File: l.file, Line: l.num, Err: err}
+		}
+
+		for _, name := range l.labels {
+			if name == "" {
+				if !final {
+					a.anon = append(a.anon, pc)
+				}
+				anon++
+				continue
+			}
+
+			full := name
+			if strings.HasPrefix(name, "@") {
+				full = scope + name
+			} else {
+				scope = name
+			}
+
+			if _, exists := a.labels[full]; exists && !final {
+				return fail(fmt.Errorf("Duplicate label %q", name))
+			}
+			if _, exists := a.consts[full]; exists {
+				return fail(fmt.Errorf("Label %q is already a constant", name))
+			}
+			if pc < 0 {
+				return fail(fmt.Errorf("Label %q before .org", name))
+			}
+			a.labels[full] = pc
+		}
+
+		ctx := evalCtx{asm: a, pc: pc, scope: scope, anon: anon}
+
+		if l.assign != "" {
+			if _, exists := a.labels[l.assign]; exists {
+				return fail(fmt.Errorf("Constant %q is already a label", l.assign))
+			}
+			if c, exists := a.consts[l.assign]; exists && !final && c.expr != l.args {
+				return fail(fmt.Errorf("Constant %q defined twice", l.assign))
+			}
+			a.consts[l.assign] = &constant{expr: l.args, ctx: ctx}
+			continue
+		}
+
+		if l.op == "" {
+			continue
+		}
+
+		var data []byte
+		var err error
+		if strings.HasPrefix(l.op, ".") {
+			data, pc, err = a.directive(l, ctx)
+			if err != nil {
+				return fail(err)
+			}
+		} else {
+			if pc < 0 {
+				return fail(fmt.Errorf("Instruction before .org"))
+			}
+			data, err = a.instruction(l, ctx)
+			if err != nil {
+				return fail(err)
+			}
+		}
+
+		if len(data) == 0 {
+			continue
+		}
+
+		if pc < 0 {
+			return fail(fmt.Errorf("Data before .org"))
+		}
+
+		last := &a.chunks[len(a.chunks)-1]
+		last.Data = append(last.Data, data...)
+		pc += len(data)
+		if pc > 0x10000 {
+			return fail(fmt.Errorf("Address past $FFFF"))
+		}
+	}
+
+	// Drop empty chunks from .org lines that weren't followed by anything.
+	chunks := []Chunk{}
+	for _, c := range a.chunks {
+		if len(c.Data) > 0 {
+			chunks = append(chunks, c)
+		}
+	}
+	a.chunks = chunks
+
+	return nil
+}
+
+// value evaluates an expression.  Unknown symbols are only an error in the
+// final pass.  known is false if the value isn't known yet.
+func (a *assembler) value(ctx evalCtx, expr string) (val int, known bool, err error) {
+	val, err = ctx.eval(expr)
+	if err != nil {
+		if _, ok := err.(errUnknown); ok && !a.final {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return val, true, nil
+}
+
+// directive handles a dot directive.  The new PC is returned for .org.
+func (a *assembler) directive(l *line, ctx evalCtx) ([]byte, int, error) {
+	pc := ctx.pc
+	switch l.op {
+	case ".byte", ".db", ".byt":
+		data := []byte{}
+		for _, arg := range splitArgs(l.args) {
+			if strings.HasPrefix(arg, "\"") {
+				s, err := unquote(arg)
+				if err != nil {
+					return nil, pc, err
+				}
+				for i := 0; i < len(s); i++ {
+					data = append(data, a.charmap[s[i]])
+				}
+				continue
+			}
+
+			val, _, err := a.value(ctx, arg)
+			if err != nil {
+				return nil, pc, err
+			}
+			if val < -128 || val > 255 {
+				return nil, pc, fmt.Errorf("Byte value out of range: %s = %d", arg, val)
+			}
+			data = append(data, byte(val))
+		}
+		return data, pc, nil
+
+	case ".word", ".dw", ".addr":
+		data := []byte{}
+		for _, arg := range splitArgs(l.args) {
+			val, _, err := a.value(ctx, arg)
+			if err != nil {
+				return nil, pc, err
+			}
+			if val < -32768 || val > 0xFFFF {
+				return nil, pc, fmt.Errorf("Word value out of range: %s = %d", arg, val)
+			}
+			data = append(data, byte(val), byte(val>>8))
+		}
+		return data, pc, nil
+
+	case ".res":
+		args := splitArgs(l.args)
+		count, known, err := a.value(ctx, args[0])
+		if err != nil {
+			return nil, pc, err
+		}
+		if !known {
+			return nil, pc, fmt.Errorf(".res count must be known: %s", args[0])
+		}
+		if count < 0 {
+			return nil, pc, fmt.Errorf(".res count is negative: %d", count)
+		}
+
+		fill := 0
+		if len(args) > 1 {
+			fill, _, err = a.value(ctx, args[1])
+			if err != nil {
+				return nil, pc, err
+			}
+		}
+		return []byte(strings.Repeat(string([]byte{byte(fill)}), count)), pc, nil
+
+	case ".org":
+		val, known, err := a.value(ctx, l.args)
+		if err != nil {
+			return nil, pc, err
+		}
+		if !known {
+			return nil, pc, fmt.Errorf(".org address must be known: %s", l.args)
+		}
+		if val < 0 || val > 0xFFFF {
+			return nil, pc, fmt.Errorf(".org address out of range: $%X", val)
+		}
+		a.chunks = append(a.chunks, Chunk{Address: uint16(val)})
+		return nil, val, nil
+
+	case ".charmap":
+		args := splitArgs(l.args)
+		if len(args) != 2 {
+			return nil, pc, fmt.Errorf(".charmap needs two values")
+		}
+		vals := [2]int{}
+		for i, arg := range args {
+			val, known, err := a.value(ctx, arg)
+			if err != nil {
+				return nil, pc, err
+			}
+			if !known || val < 0 || val > 255 {
+				return nil, pc, fmt.Errorf("Bad .charmap value: %s", arg)
+			}
+			vals[i] = val
+		}
+		a.charmap[vals[0]] = byte(vals[1])
+		return nil, pc, nil
+
+	case ".incbin":
+		name, err := unquote(l.args)
+		if err != nil {
+			return nil, pc, err
+		}
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(l.file), name))
+		return data, pc, err
+
+	case ".setcpu":
+		cpu, err := unquote(l.args)
+		if err != nil {
+			return nil, pc, err
+		}
+		switch strings.ToLower(cpu) {
+		case "6502":
+			a.illegal = false
+		case "6502x":
+			a.illegal = true
+		default:
+			return nil, pc, fmt.Errorf("Unsupported CPU: %s", cpu)
+		}
+		return nil, pc, nil
+
+	case ".segment":
+		return nil, pc, nil
+	}
+
+	return nil, pc, fmt.Errorf("Unknown directive %s", l.op)
+}
+
+// Opcodes by mnemonic and addressing mode.  Official opcodes take precedence
+// over unofficial ones with the same name and mode.
+var opcodes = map[string]map[disasm.Mode]byte{}
+
+func init() {
+	for i, op := range disasm.Opcodes {
+		modes, ok := opcodes[op.Name]
+		if !ok {
+			modes = make(map[disasm.Mode]byte)
+			opcodes[op.Name] = modes
+		}
+
+		if prev, exists := modes[op.Mode]; exists && (op.Illegal || !disasm.Opcodes[prev].Illegal) {
+			continue
+		}
+		modes[op.Mode] = byte(i)
+	}
+}
+
+// instruction assembles a single instruction.
+func (a *assembler) instruction(l *line, ctx evalCtx) ([]byte, error) {
+	modes, ok := opcodes[l.op]
+	if !ok {
+		return nil, fmt.Errorf("Unknown instruction %q", l.op)
+	}
+
+	mode, expr, force, err := parseOperand(l.args)
+	if err != nil {
+		return nil, err
+	}
+
+	var val int
+	known := true
+	if expr != "" {
+		val, known, err = a.value(ctx, expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Pick the final mode
+	if _, isBranch := modes[disasm.AM_REL]; isBranch && mode == disasm.AM_ABS {
+		mode = disasm.AM_REL
+	}
+
+	if mode == disasm.AM_IMP {
+		if _, ok := modes[disasm.AM_IMP]; !ok {
+			mode = disasm.AM_ACC
+		}
+	} else if mode == disasm.AM_ACC {
+		if _, ok := modes[disasm.AM_ACC]; !ok {
+			return nil, fmt.Errorf("%s doesn't have an accumulator mode", l.op)
+		}
+	}
+
+	if zp, ok := zeroPage[mode]; ok {
+		_, hasZp := modes[zp]
+		_, hasAbs := modes[mode]
+
+		if a.final {
+			// Keep the size from the first pass
+			if prev, ok := a.modes[l]; ok && disasm.Opcodes[prev].Mode == zp {
+				mode = zp
+			}
+		} else if hasZp && (force == 'z' || (force == 0 && known && val >= 0 && val < 0x100) || !hasAbs) {
+			mode = zp
+		}
+	}
+
+	opcode, ok := modes[mode]
+	if !ok {
+		return nil, fmt.Errorf("%s doesn't support %s addressing", l.op, mode)
+	}
+
+	if disasm.Opcodes[opcode].Illegal && !a.illegal {
+		return nil, fmt.Errorf("%s is an unofficial opcode.  Use .setcpu \"6502X\"", l.op)
+	}
+
+	if !a.final {
+		a.modes[l] = opcode
+	}
+
+	data := []byte{opcode}
+	switch mode {
+	case disasm.AM_IMP, disasm.AM_ACC:
+	case disasm.AM_REL:
+		offset := val - (ctx.pc + 2)
+		if known && (offset < -128 || offset > 127) {
+			return nil, fmt.Errorf("Branch out of range: %d bytes", offset)
+		}
+		data = append(data, byte(offset))
+	case disasm.AM_IMM, disasm.AM_ZP, disasm.AM_ZPX, disasm.AM_ZPY, disasm.AM_IZX, disasm.AM_IZY:
+		if known && (val < -128 || val > 255) {
+			return nil, fmt.Errorf("Value out of range for %s: $%X", mode, val)
+		}
+		data = append(data, byte(val))
+	default:
+		if known && (val < -32768 || val > 0xFFFF) {
+			return nil, fmt.Errorf("Address out of range: $%X", val)
+		}
+		data = append(data, byte(val), byte(val>>8))
+	}
+
+	return data, nil
+}
+
+// Absolute modes and their zero page versions
+var zeroPage = map[disasm.Mode]disasm.Mode{
+	disasm.AM_ABS: disasm.AM_ZP,
+	disasm.AM_ABX: disasm.AM_ZPX,
+	disasm.AM_ABY: disasm.AM_ZPY,
+}
+
+// parseOperand finds the addressing mode of an operand.  Plain addresses are
+// returned as AM_ABS, AM_ABX, or AM_ABY, and are changed to zero page or
+// relative later.  force is 'a' or 'z' for the a: and z: prefixes.
+func parseOperand(text string) (mode disasm.Mode, expr string, force byte, err error) {
+	text = strings.TrimSpace(text)
+	lower := strings.ToLower(text)
+
+	switch {
+	case text == "":
+		return disasm.AM_IMP, "", 0, nil
+
+	case lower == "a":
+		return disasm.AM_ACC, "", 0, nil
+
+	case strings.HasPrefix(text, "#"):
+		return disasm.AM_IMM, strings.TrimSpace(text[1:]), 0, nil
+	}
+
+	if len(lower) > 2 && (lower[0] == 'a' || lower[0] == 'z') && lower[1] == ':' {
+		force = lower[0]
+		text = strings.TrimSpace(text[2:])
+		lower = strings.ToLower(text)
+	}
+
+	// Indirect modes.  "(expr)+1" is a plain expression, so the parenthesis
+	// has to close at the end.
+	if strings.HasPrefix(text, "(") {
+		close := matchParen(text)
+		if close < 0 {
+			return 0, "", 0, fmt.Errorf("Missing ) in %q", text)
+		}
+
+		inner := strings.TrimSpace(text[1:close])
+		rest := strings.ToLower(strings.ReplaceAll(text[close+1:], " ", ""))
+		innerLower := strings.ToLower(strings.ReplaceAll(inner, " ", ""))
+
+		switch {
+		case rest == "" && strings.HasSuffix(innerLower, ",x"):
+			return disasm.AM_IZX, strings.TrimSpace(inner[:strings.LastIndex(inner, ",")]), force, nil
+		case rest == ",y":
+			return disasm.AM_IZY, inner, force, nil
+		case rest == "":
+			return disasm.AM_IND, inner, force, nil
+		}
+	}
+
+	args := splitArgs(text)
+	switch {
+	case len(args) == 1:
+		return disasm.AM_ABS, args[0], force, nil
+	case len(args) == 2 && strings.ToLower(args[1]) == "x":
+		return disasm.AM_ABX, args[0], force, nil
+	case len(args) == 2 && strings.ToLower(args[1]) == "y":
+		return disasm.AM_ABY, args[0], force, nil
+	}
+
+	return 0, "", 0, fmt.Errorf("Bad operand %q", text)
+}
+
+// matchParen returns the index of the parenthesis that closes the one at the
+// start of text, or -1.
+func matchParen(text string) int {
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,191 @@
+package asm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/go-nes/disasm"
+)
+
+func assemble(t *testing.T, src string) []byte {
+	t.Helper()
+	prog, err := Assemble([]byte(src), "test.s", 0x8000)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	if len(prog.Chunks) != 1 {
+		t.Fatalf("Expected one chunk, got %d", len(prog.Chunks))
+	}
+	return prog.Chunks[0].Data
+}
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []byte
+	}{
+		{"nop", []byte{0xEA}},
+		{"asl", []byte{0x0A}},
+		{"asl a", []byte{0x0A}},
+		{"lda #$10", []byte{0xA9, 0x10}},
+		{"lda #<$1234", []byte{0xA9, 0x34}},
+		{"lda #>$1234", []byte{0xA9, 0x12}},
+		{"lda $10", []byte{0xA5, 0x10}},
+		{"lda $10,x", []byte{0xB5, 0x10}},
+		{"ldx $10,y", []byte{0xB6, 0x10}},
+		{"lda $1234", []byte{0xAD, 0x34, 0x12}},
+		{"lda a:$10", []byte{0xAD, 0x10, 0x00}},
+		{"lda $1234,x", []byte{0xBD, 0x34, 0x12}},
+		{"lda $1234, Y", []byte{0xB9, 0x34, 0x12}},
+		{"lda $10,y", []byte{0xB9, 0x10, 0x00}},
+		{"jmp ($FFFC)", []byte{0x6C, 0xFC, 0xFF}},
+		{"lda ($20,x)", []byte{0xA1, 0x20}},
+		{"lda ($20),y", []byte{0xB1, 0x20}},
+		{"lda ($10+$10)+1", []byte{0xA5, 0x21}},
+		{"bne *", []byte{0xD0, 0xFE}},
+		{"lda #%1010 | 1", []byte{0xA9, 0x0B}},
+		{"lda #'A'", []byte{0xA9, 0x41}},
+		{"lda #1+2^3", []byte{0xA9, 0x02}},
+		{"lda #(2+3)*4 ; comment", []byte{0xA9, 0x14}},
+		{".byte 1, $FF, -1, \"AB;\"", []byte{0x01, 0xFF, 0xFF, 0x41, 0x42, 0x3B}},
+		{".word $1234, *", []byte{0x34, 0x12, 0x00, 0x80}},
+		{".res 3, $EA", []byte{0xEA, 0xEA, 0xEA}},
+	}
+
+	for _, tt := range tests {
+		got := assemble(t, tt.src)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%q: expected % X, got % X", tt.src, tt.want, got)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	src := `
+PPUCTRL = $2000
+Value := Table + 1
+
+Start:
+	lda #0
+	sta PPUCTRL
+@loop:
+	ldx Value
+	beq @loop
+	bne :+
+	jmp Start
+:	lda Zp
+	bne :-
+Other:
+@loop:
+	jmp @loop
+
+Zp = $10
+Table:
+	.byte "AB"
+	.charmap 'A', $00
+	.byte "AB", 'A'
+`
+	want := []byte{
+		0xA9, 0x00, // lda #0
+		0x8D, 0x00, 0x20, // sta PPUCTRL
+		0xAE, 0x18, 0x80, // ldx Value
+		0xF0, 0xFB, // beq @loop
+		0xD0, 0x03, // bne :+
+		0x4C, 0x00, 0x80, // jmp Start
+		0xAD, 0x10, 0x00, // lda Zp, forward reference
+		0xD0, 0xFB, // bne :-
+		0x4C, 0x14, 0x80, // jmp Other@loop
+		0x41, 0x42, 0x00, 0x42, 0x00,
+	}
+
+	prog, err := Assemble([]byte(src), "test.s", 0x8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := prog.Chunks[0].Data
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected\n% X\ngot\n% X", want, got)
+	}
+
+	if prog.Symbols["Start@loop"] != 0x8005 || prog.Symbols["Value"] != 0x8018 {
+		t.Errorf("Bad symbols: %v", prog.Symbols)
+	}
+}
+
+func TestOrg(t *testing.T) {
+	prog, err := Assemble([]byte(".org $C000\nrts\n.org $FFFC\n.word $C000"), "test.s", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(prog.Chunks) != 2 || prog.Chunks[1].Address != 0xFFFC {
+		t.Errorf("Bad chunks: %v", prog.Chunks)
+	}
+
+	if _, err := Assemble([]byte("rts"), "test.s", -1); err == nil {
+		t.Errorf("Expected an error without .org")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []string{
+		"lda Missing",
+		"bne $9000",
+		"lda #$100",
+		"stx $1234,x",
+		"jmp ($10),y",
+		"lax $10",
+		"Label:\nLabel:",
+		".byte 256",
+		"X1 = X2\nX2 = X1\nlda X1",
+		"lda (foo",
+		"lda #7 % 2",
+	}
+
+	for _, src := range tests {
+		_, err := Assemble([]byte(src), "test.s", 0x8000)
+		if err == nil {
+			t.Errorf("Expected an error for %q", src)
+		} else if !strings.HasPrefix(err.Error(), "test.s:") {
+			t.Errorf("Error without a location: %v", err)
+		}
+	}
+}
+
+// Disassembler output should assemble back to the same bytes.
+func TestRoundTrip(t *testing.T) {
+	bank := make([]byte, 0x4000)
+	for i := range bank {
+		bank[i] = 0xFF
+	}
+
+	code := []byte{
+		0x78, 0xA2, 0x00, 0xBD, 0x20, 0xC0, 0xAD, 0x16, 0xC0, 0x85, 0x10,
+		0x20, 0x15, 0xC0, 0xD0, 0xF0, 0x4C, 0x02, 0xC0, 0x00, 0x00,
+		0xA7, 0x10, 0xB1, 0x20, 0x96, 0x30, 0x6C, 0xFC, 0xFF,
+	}
+	copy(bank, code)
+	copy(bank[0x20:], []byte{0x01, 0x02, 0x03})
+	copy(bank[0x3FFA:], []byte{0x17, 0xC0, 0x00, 0xC0, 0x17, 0xC0})
+
+	for _, illegal := range []bool{false, true} {
+		d := disasm.New(bank, 0xC000)
+		d.Illegal = illegal
+		d.SetLabel(0xC020, "Table", "")
+		d.SetLabel(0x2000, "PpuCtrl", "")
+		d.SetLabel(0xC016, "Inner", "") // Operand of lax $10
+		d.TraceVectors()
+		src := d.Source()
+
+		prog, err := Assemble([]byte(src), "bank.s", 0xC000)
+		if err != nil {
+			t.Fatalf("%v\n%s", err, src)
+		}
+
+		if len(prog.Chunks) != 1 || !bytes.Equal(prog.Chunks[0].Data, bank) {
+			t.Errorf("Round trip mismatch (illegal: %t)\n%s", illegal, src)
+		}
+	}
+}
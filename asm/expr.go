@@ -0,0 +1,254 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errUnknown is returned when an expression uses a symbol that isn't defined
+// yet.  This is only an error in the last pass.
+type errUnknown struct {
+	name string
+}
+
+func (e errUnknown) Error() string {
+	return fmt.Sprintf("Undefined symbol %q", e.name)
+}
+
+// exprParser is a recursive descent parser that evaluates as it goes.
+//
+// Precedence follows ca65.  Sums (+ - |) bind loosest, then products
+// (* / & ^ << >>), then the unary operators (- + ~ < > ^).  Binary ^ is
+// XOR.  % is only the binary number prefix; ca65 spells modulo .MOD, which
+// isn't supported.
+type exprParser struct {
+	text string
+	pos  int
+	ctx  *evalCtx
+}
+
+// evalCtx is everything needed to resolve names in an expression.
+type evalCtx struct {
+	asm   *assembler
+	pc    int
+	scope string // last global label, for @local labels
+	anon  int    // number of unnamed labels defined before this point
+}
+
+func (ctx *evalCtx) eval(text string) (int, error) {
+	p := &exprParser{text: text, ctx: ctx}
+	val, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.text) {
+		return 0, fmt.Errorf("Unexpected %q in expression %q", p.text[p.pos:], text)
+	}
+	return val, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.text) {
+		return 0
+	}
+	return p.text[p.pos]
+}
+
+func (p *exprParser) parseSum() (int, error) {
+	val, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' && op != '|' {
+			return val, nil
+		}
+		p.pos++
+
+		rhs, err := p.parseProduct()
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case '+':
+			val += rhs
+		case '-':
+			val -= rhs
+		case '|':
+			val |= rhs
+		}
+	}
+}
+
+func (p *exprParser) parseProduct() (int, error) {
+	val, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		op := string(p.peek())
+		if (op == "<" || op == ">") && strings.HasPrefix(p.text[p.pos:], op+op) {
+			op += op
+		} else if op != "*" && op != "/" && op != "&" && op != "^" {
+			return val, nil
+		}
+		p.pos += len(op)
+
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case "*":
+			val *= rhs
+		case "/":
+			if rhs == 0 {
+				return 0, fmt.Errorf("Division by zero")
+			}
+			val /= rhs
+		case "&":
+			val &= rhs
+		case "^":
+			val ^= rhs
+		case "<<":
+			val <<= uint(rhs)
+		case ">>":
+			val >>= uint(rhs)
+		}
+	}
+}
+
+func (p *exprParser) parseUnary() (int, error) {
+	switch p.peek() {
+	case '-', '+', '~', '<', '>', '^':
+		op := p.text[p.pos]
+		p.pos++
+		val, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+
+		switch op {
+		case '-':
+			return -val, nil
+		case '~':
+			return ^val, nil
+		case '<':
+			return val & 0xFF, nil
+		case '>':
+			return (val >> 8) & 0xFF, nil
+		case '^':
+			return (val >> 16) & 0xFF, nil
+		}
+		return val, nil
+	}
+
+	return p.parseValue()
+}
+
+func (p *exprParser) parseValue() (int, error) {
+	c := p.peek()
+	start := p.pos
+
+	switch {
+	case c == 0:
+		return 0, fmt.Errorf("Missing value in expression %q", p.text)
+
+	case c == '(':
+		p.pos++
+		val, err := p.parseSum()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("Missing ) in expression %q", p.text)
+		}
+		p.pos++
+		return val, nil
+
+	case c == '*':
+		p.pos++
+		return p.ctx.pc, nil
+
+	case c == '$':
+		p.pos++
+		return p.number(16, start)
+
+	case c == '%':
+		p.pos++
+		return p.number(2, start)
+
+	case c >= '0' && c <= '9':
+		return p.number(10, start)
+
+	case c == '\'':
+		if p.pos+2 >= len(p.text) || p.text[p.pos+2] != '\'' {
+			return 0, fmt.Errorf("Bad character constant in %q", p.text)
+		}
+		ch := p.text[p.pos+1]
+		p.pos += 3
+		return int(p.ctx.asm.charmap[ch]), nil
+
+	case c == ':':
+		// Unnamed label reference, :+ or :-
+		p.pos++
+		count := 0
+		dir := byte(0)
+		for p.pos < len(p.text) && (p.text[p.pos] == '+' || p.text[p.pos] == '-') {
+			if dir != 0 && p.text[p.pos] != dir {
+				break
+			}
+			dir = p.text[p.pos]
+			count++
+			p.pos++
+		}
+		if count == 0 {
+			return 0, fmt.Errorf("Bad unnamed label reference in %q", p.text)
+		}
+		return p.ctx.asm.unnamed(p.ctx.anon, dir, count)
+
+	case isIdentStart(c):
+		for p.pos < len(p.text) && isIdentChar(p.text[p.pos]) {
+			p.pos++
+		}
+		return p.ctx.asm.lookup(p.text[start:p.pos], p.ctx.scope)
+	}
+
+	return 0, fmt.Errorf("Unexpected %q in expression %q", p.text[p.pos:], p.text)
+}
+
+func (p *exprParser) number(base, start int) (int, error) {
+	digits := p.pos
+	for p.pos < len(p.text) && isIdentChar(p.text[p.pos]) {
+		p.pos++
+	}
+
+	val, err := strconv.ParseInt(p.text[digits:p.pos], base, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Bad number %q", p.text[start:p.pos])
+	}
+	return int(val), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '@' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
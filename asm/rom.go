@@ -0,0 +1,58 @@
+package asm
+
+import (
+	"fmt"
+	"hash/crc32"
+
+	"github.com/zorchenhimer/go-nes/mapper"
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// PrgOffset returns the PRG ROM offset for a CPU address.  Addresses in fixed
+// windows always use the fixed bank.  Addresses in switchable windows use the
+// given bank, in units of that window's size.  A negative bank only allows
+// fixed windows.
+func PrgOffset(layout *mapper.Layout, bank int, address uint16) (uint, error) {
+	if fixed, ok := layout.PrgFixedBank(address); ok {
+		return layout.PrgOffset(fixed, address)
+	}
+
+	if bank < 0 {
+		return 0, fmt.Errorf("$%04X is in a switchable bank and no bank was given", address)
+	}
+	return layout.PrgOffset(uint(bank), address)
+}
+
+// WriteRom writes the program into the PRG ROM.  See PrgOffset() for how
+// bank is used.  Nothing is written if any of the addresses can't be mapped.
+func (p *Program) WriteRom(r *rom.NesRom, bank int) error {
+	layout, err := mapper.FromRom(r)
+	if err != nil {
+		return err
+	}
+
+	offsets := make([][]uint, len(p.Chunks))
+	for i, chunk := range p.Chunks {
+		offsets[i] = make([]uint, len(chunk.Data))
+		for j := range chunk.Data {
+			addr := chunk.Address + uint16(j)
+			offset, err := PrgOffset(layout, bank, addr)
+			if err != nil {
+				return err
+			}
+			if offset >= uint(len(r.Prgrom)) {
+				return fmt.Errorf("$%04X is past the end of PRG ROM", addr)
+			}
+			offsets[i][j] = offset
+		}
+	}
+
+	for i, chunk := range p.Chunks {
+		for j, b := range chunk.Data {
+			r.Prgrom[offsets[i][j]] = b
+		}
+	}
+
+	r.PrgCrc = rom.Crc32(crc32.ChecksumIEEE(r.Prgrom))
+	return nil
+}
@@ -0,0 +1,226 @@
+// Package catalog builds a list of ROMs, with their headers and hashes, from
+// directories of ROM files and zip archives.
+package catalog
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// DefaultExtensions are the file extensions loaded when Options.Extensions is
+// empty.  Zip files are always opened.
+var DefaultExtensions = []string{".nes", ".unf", ".unif", ".fds", ".qd"}
+
+// Entry is a single ROM.  ROMs in a zip archive have the archive's Path,
+// Size, and ModTime, and their name inside the archive in Name.
+type Entry struct {
+	Path    string
+	Name    string `json:",omitempty"`
+	Size    int64
+	ModTime time.Time
+
+	Format string         `json:",omitempty"`
+	Board  string         `json:",omitempty"` // UNIF board name
+	Header *rom.Header    `json:",omitempty"` // nil if the format can't be represented with one
+	Hashes *rom.RomHashes `json:",omitempty"`
+	Error  string         `json:",omitempty"` // Set if the ROM couldn't be loaded
+}
+
+// Options for Scan().  The zero value uses the defaults.
+type Options struct {
+	Workers    int      // Files read at once.  Defaults to the number of CPUs.
+	Extensions []string // Lowercase, with the dot.  Defaults to DefaultExtensions.
+	Previous   []*Entry // Entries to reuse for files with the same size and ModTime
+}
+
+// Catalog is the result of Scan().
+type Catalog struct {
+	Entries []*Entry
+	Files   int // Files and archives found
+	Reused  int // Files that were unchanged from Options.Previous
+}
+
+// Scan walks the given directories and files and loads every ROM it finds.
+// Errors loading a ROM are stored in its entry.  Entries are sorted by Path
+// and Name.
+func Scan(paths []string, opts Options) (*Catalog, error) {
+	if opts.Workers < 1 {
+		opts.Workers = runtime.NumCPU()
+	}
+	if len(opts.Extensions) == 0 {
+		opts.Extensions = DefaultExtensions
+	}
+
+	files := []string{}
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			ext := strings.ToLower(filepath.Ext(p))
+			if !d.IsDir() && (ext == ".zip" || hasExtension(opts.Extensions, p)) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	previous := make(map[string][]*Entry)
+	for _, e := range opts.Previous {
+		previous[e.Path] = append(previous[e.Path], e)
+	}
+
+	c := &Catalog{Files: len(files)}
+	results := make([][]*Entry, len(files))
+	jobs := make(chan int)
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				entries, reused := scanFile(files[idx], previous[files[idx]], opts.Extensions)
+				results[idx] = entries
+				if reused {
+					mu.Lock()
+					c.Reused++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, entries := range results {
+		c.Entries = append(c.Entries, entries...)
+	}
+
+	sort.SliceStable(c.Entries, func(i, j int) bool {
+		a, b := c.Entries[i], c.Entries[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Name < b.Name
+	})
+
+	return c, nil
+}
+
+// scanFile loads a ROM file, or all the ROMs in a zip archive.  The previous
+// entries are returned as-is if the file hasn't changed.
+func scanFile(path string, previous []*Entry, extensions []string) ([]*Entry, bool) {
+	base := Entry{Path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		base.Error = err.Error()
+		return []*Entry{&base}, false
+	}
+	base.Size = info.Size()
+	base.ModTime = info.ModTime()
+
+	if len(previous) > 0 && previous[0].Size == base.Size && previous[0].ModTime.Equal(base.ModTime) {
+		return previous, true
+	}
+
+	if strings.ToLower(filepath.Ext(path)) != ".zip" {
+		raw, err := os.ReadFile(path)
+		entry := base
+		if err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.load(raw)
+		}
+		return []*Entry{&entry}, false
+	}
+
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		base.Error = err.Error()
+		return []*Entry{&base}, false
+	}
+	defer archive.Close()
+
+	entries := []*Entry{}
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() || !hasExtension(extensions, file.Name) {
+			continue
+		}
+
+		entry := base
+		entry.Name = file.Name
+		raw, err := readZipFile(file)
+		if err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.load(raw)
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, false
+}
+
+func readZipFile(file *zip.File) ([]byte, error) {
+	r, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// load fills in the entry from the ROM data.
+func (e *Entry) load(raw []byte) {
+	r, err := rom.Load(bytes.NewReader(raw))
+	if err != nil {
+		e.Error = err.Error()
+		return
+	}
+
+	e.Format = string(r.RomType())
+	if unif, ok := r.(*rom.UnifRom); ok {
+		e.Board = unif.Mapper
+	}
+
+	if header, err := r.NesHeader(); err == nil {
+		e.Header = header
+	}
+
+	e.Hashes, err = rom.HashRom(r)
+	if err != nil {
+		e.Error = fmt.Sprintf("Unable to hash ROM: %v", err)
+	}
+}
+
+func hasExtension(extensions []string, name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package catalog
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+func testRom(t *testing.T, mapper uint) []byte {
+	t.Helper()
+	r := &rom.NesRom{
+		Header: &rom.Header{Mapper: mapper, PrgSize: 0x4000, ChrSize: 0x2000},
+		Prgrom: bytes.Repeat([]byte{byte(mapper)}, 0x4000),
+		Chrrom: make([]byte, 0x2000),
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err := r.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeTestFiles(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{
+		"a.nes":       testRom(t, 0),
+		"sub/bad.nes": []byte("not a rom"),
+		"readme.txt":  []byte("ignored"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, name := range []string{"b.nes", "c.nes", "notes.txt"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(testRom(t, 2))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "sub", "more.zip"), buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestScan(t *testing.T) {
+	dir := writeTestFiles(t)
+	c, err := Scan([]string{dir}, Options{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Files != 3 || len(c.Entries) != 4 {
+		t.Fatalf("Expected 4 entries in 3 files, got %d in %d", len(c.Entries), c.Files)
+	}
+
+	names := []string{"a.nes", "sub/bad.nes", "sub/more.zip", "sub/more.zip"}
+	for i, e := range c.Entries {
+		rel, _ := filepath.Rel(dir, e.Path)
+		if filepath.ToSlash(rel) != names[i] {
+			t.Errorf("Entry %d: expected %s, got %s", i, names[i], rel)
+		}
+	}
+
+	a, bad, b := c.Entries[0], c.Entries[1], c.Entries[2]
+	if a.Format != string(rom.INES) || a.Header == nil || a.Hashes == nil || a.Hashes.Chr == nil {
+		t.Errorf("Bad entry for a.nes: %+v", a)
+	}
+	if bad.Error == "" || bad.Hashes != nil {
+		t.Errorf("Expected an error for bad.nes: %+v", bad)
+	}
+	if b.Name != "b.nes" || b.Header == nil || b.Header.Mapper != 2 {
+		t.Errorf("Bad entry for b.nes: %+v", b)
+	}
+
+	buf := &bytes.Buffer{}
+	if err = c.WriteJSONL(buf); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := ReadJSONL(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged files are taken from the previous run.  The changed file is
+	// loaded again.
+	previous[0].Format = "cached"
+	previous[2].Format = "cached"
+	previous[2].Size++
+	c, err = Scan([]string{dir}, Options{Previous: previous})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Reused != 2 || c.Entries[0].Format != "cached" || c.Entries[2].Format != string(rom.INES) {
+		t.Errorf("Expected a.nes to be reused and more.zip to be scanned: %d %s %s",
+			c.Reused, c.Entries[0].Format, c.Entries[2].Format)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	c, err := Scan([]string{writeTestFiles(t)}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err = c.WriteCSV(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 5 {
+		t.Fatalf("Expected 5 rows, got %d", len(rows))
+	}
+
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[name] = i
+	}
+
+	if rows[1][columns["Mapper"]] != "0" || rows[3][columns["Mapper"]] != "2" || rows[1][columns["Prg.Size"]] != "16384" {
+		t.Errorf("Bad rows: %v %v", rows[1], rows[3])
+	}
+
+	// bad.nes has no header or hashes
+	if rows[2][columns["Mapper"]] != "" || rows[2][columns["File.Crc32"]] != "" {
+		t.Errorf("Expected empty columns: %v", rows[2])
+	}
+}
@@ -0,0 +1,147 @@
+package catalog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// WriteJSONL writes one JSON object per entry, one per line.
+func (c *Catalog) WriteJSONL(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, e := range c.Entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadJSONL reads a catalog written by WriteJSONL().
+func LoadJSONL(filename string) ([]*Entry, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadJSONL(file)
+}
+
+// ReadJSONL reads entries written by WriteJSONL().  Blank lines are skipped.
+func ReadJSONL(r io.Reader) ([]*Entry, error) {
+	entries := []*Entry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	num := 0
+	for scanner.Scan() {
+		num++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		e := &Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, fmt.Errorf("Line %d: %w", num, err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// hashSections are the RomHashes fields, in column order.
+var hashSections = []string{"File", "Headerless", "Prg", "Chr", "Trainer"}
+
+// WriteCSV writes the catalog with a column for every header field and every
+// hash.  Columns are empty for entries without a header or hash.
+func (c *Catalog) WriteCSV(w io.Writer) error {
+	headerType := reflect.TypeOf(rom.Header{})
+
+	columns := []string{"Path", "Name", "Size", "ModTime", "Format", "Board", "Error"}
+	for i := 0; i < headerType.NumField(); i++ {
+		columns = append(columns, headerType.Field(i).Name)
+	}
+	for _, section := range hashSections {
+		for _, hash := range []string{"Size", "Crc32", "Md5", "Sha1", "Sha256"} {
+			columns = append(columns, section+"."+hash)
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+
+	for _, e := range c.Entries {
+		row := []string{
+			e.Path,
+			e.Name,
+			strconv.FormatInt(e.Size, 10),
+			e.ModTime.Format(time.RFC3339Nano),
+			e.Format,
+			e.Board,
+			e.Error,
+		}
+
+		if e.Header != nil {
+			h := reflect.ValueOf(*e.Header)
+			for i := 0; i < h.NumField(); i++ {
+				row = append(row, csvValue(h.Field(i).Interface()))
+			}
+		} else {
+			row = append(row, make([]string, headerType.NumField())...)
+		}
+
+		for _, section := range hashSections {
+			row = append(row, csvHashes(e.Hashes, section)...)
+		}
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvValue(val interface{}) string {
+	if b, ok := val.([]byte); ok {
+		return hex.EncodeToString(b)
+	}
+	return fmt.Sprint(val)
+}
+
+// csvHashes returns the size and hash columns for a section of the ROM.
+func csvHashes(hashes *rom.RomHashes, section string) []string {
+	if hashes == nil {
+		return make([]string, 5)
+	}
+
+	h, _ := reflect.ValueOf(*hashes).FieldByName(section).Interface().(*rom.Hashes)
+	if h == nil {
+		return make([]string, 5)
+	}
+
+	return []string{
+		strconv.FormatUint(uint64(h.Size), 10),
+		h.Crc32.HexString(),
+		h.Md5,
+		h.Sha1,
+		h.Sha256,
+	}
+}
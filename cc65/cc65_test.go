@@ -0,0 +1,154 @@
+package cc65
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// UxROM with two 16k banks
+var testHeader = &rom.Header{Mapper: 2, PrgSize: 0x8000}
+
+const testDbg = `version	major=2,minor=0
+info	csym=0,file=1,lib=0,line=3,mod=1,scope=2,seg=4,span=3,sym=5,type=1
+file	id=0,name="main.s",size=400,mtime=0x61A2B3C4,mod=0
+mod	id=0,name="main.o",file=0
+seg	id=0,name="HEADER",start=0x000000,size=0x0010,addrsize=absolute,type=ro,oname="game.nes",ooffs=0
+seg	id=1,name="BANK0",start=0x008000,size=0x4000,addrsize=absolute,type=ro,oname="game.nes",ooffs=16
+seg	id=2,name="FIXED",start=0x00C000,size=0x4000,addrsize=absolute,type=ro,oname="game.nes",ooffs=16400
+seg	id=3,name="BSS",start=0x000300,size=0x0010,addrsize=absolute,type=rw
+span	id=0,seg=1,start=0,size=3
+span	id=1,seg=2,start=0,size=6
+span	id=2,seg=2,start=4,size=2
+scope	id=0,name="",mod=0,size=9,span=0+1
+scope	id=1,name="Reset",mod=0,type=scope,size=6,parent=0,sym=1,span=1
+line	id=0,file=0,line=5,span=0
+line	id=1,file=0,line=10,span=1
+line	id=2,file=0,line=12,type=2,count=1,span=2
+sym	id=0,name="Decompress",addrsize=absolute,scope=0,def=0,ref=1,val=0x8000,seg=1,type=lab
+sym	id=1,name="Reset",addrsize=absolute,size=6,scope=0,def=1,val=0xC000,seg=2,type=lab
+sym	id=2,name="@loop",addrsize=absolute,scope=1,parent=1,def=2,val=0xC004,seg=2,type=lab
+sym	id=3,name="buffer",addrsize=absolute,scope=0,def=2,val=0x300,seg=3,type=lab
+sym	id=4,name="PPUCTRL",addrsize=absolute,scope=0,def=2,val=0x2000,type=equ
+`
+
+func TestReadDbg(t *testing.T) {
+	d, err := ReadDbg(strings.NewReader(testDbg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Version != "2.0" || len(d.Segments) != 4 || len(d.Symbols) != 5 || len(d.Lines) != 3 {
+		t.Fatalf("Bad counts: %s %d %d %d", d.Version, len(d.Segments), len(d.Symbols), len(d.Lines))
+	}
+
+	if d.Files[0].Mtime != 0x61A2B3C4 || d.Scopes[0].Type != "global" || len(d.Scopes[0].Spans) != 2 {
+		t.Errorf("Bad file or scope: %v %v", d.Files[0], d.Scopes[0])
+	}
+
+	if addr := d.Spans[d.Lines[2].Spans[0]].Address(d); addr != 0xC004 {
+		t.Errorf("Expected line 12 at $C004, got $%04X", addr)
+	}
+
+	labels := d.Labels(testHeader)
+	expect := map[string]uint{
+		"Decompress": 0x0000,
+		"Reset":      0x4000,
+		"Reset@loop": 0x4004,
+	}
+	if len(labels) != len(expect) {
+		t.Errorf("Expected %d labels, got %v", len(expect), labels)
+	}
+	for name, offset := range expect {
+		if labels[name] != offset {
+			t.Errorf("%s: expected $%04X, got $%04X", name, offset, labels[name])
+		}
+	}
+
+	for _, name := range []string{"buffer", "PPUCTRL"} {
+		if _, err := d.PrgOffset(d.Symbol(name), testHeader); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := ReadDbg(strings.NewReader("sym\tid=1,name=\"x\"\n")); err == nil {
+		t.Errorf("Expected an error for an out of order ID")
+	}
+}
+
+const testMap = `Modules list:
+-------------
+main.o:
+    BANK0             Offs=000000  Size=000003  Align=00001  Fill=0000
+    FIXED             Offs=000000  Size=000006  Align=00001  Fill=0000
+nes.lib(crt0.o):
+    FIXED             Offs=000006  Size=000010  Align=00001  Fill=0000
+
+
+Segment list:
+-------------
+Name                   Start     End    Size  Align
+----------------------------------------------------
+HEADER                000000  00000F  000010  00001
+BANK0                 008000  008002  000003  00001
+FIXED                 00C000  00C015  000016  00001
+
+
+Exports list by name:
+---------------------
+Decompress                008000 RLA    PPUCTRL                   002000  EA
+Reset                     00C000 RLA    Unused                    00C010  LA
+
+
+Exports list by value:
+----------------------
+PPUCTRL                   002000  EA    Decompress                008000 RLA
+Reset                     00C000 RLA
+
+
+Imports list:
+-------------
+Decompress (main.o):
+    crt0.o                    crt0.s(12)
+`
+
+func TestReadMap(t *testing.T) {
+	m, err := ReadMap(strings.NewReader(testMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Modules) != 2 || m.Modules[1].Library != "nes.lib" || m.Modules[1].Segments[0].Offset != 6 {
+		t.Errorf("Bad modules: %v", m.Modules)
+	}
+
+	if len(m.Segments) != 3 || m.Segments[2].Start != 0xC000 || m.Segments[2].Size != 0x16 {
+		t.Errorf("Bad segments: %v", m.Segments)
+	}
+
+	if len(m.Exports) != 4 {
+		t.Fatalf("Bad exports: %v", m.Exports)
+	}
+
+	if exp, _ := m.Export("PPUCTRL"); exp.Value != 0x2000 || exp.IsLabel() {
+		t.Errorf("Bad PPUCTRL export: %v", exp)
+	}
+
+	if exp, _ := m.Export("Unused"); exp.Value != 0xC010 || exp.Flags != " LA" || !exp.IsLabel() {
+		t.Errorf("Bad Unused export: %v", exp)
+	}
+
+	if len(m.Imports) != 1 || m.Imports[0].Module != "main.o" || m.Imports[0].References[0].Location != "crt0.s(12)" {
+		t.Errorf("Bad imports: %v", m.Imports)
+	}
+
+	offset, err := m.PrgOffset("Reset", testHeader)
+	if err != nil || offset != 0x4000 {
+		t.Errorf("Reset: expected $4000, got $%04X %v", offset, err)
+	}
+
+	if _, err := m.PrgOffset("Decompress", testHeader); err == nil {
+		t.Errorf("Expected an error for a switchable bank")
+	}
+}
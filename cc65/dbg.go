@@ -0,0 +1,431 @@
+// Package cc65 reads the debug info (--dbgfile) and map (-m) files written by
+// ld65.
+package cc65
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// DebugInfo is a parsed .dbg file.  Each slice is indexed by ID.  Fields that
+// point to other items hold their IDs, or -1 if they aren't set.
+type DebugInfo struct {
+	Version string
+
+	Files    []*File
+	Modules  []*Module
+	Segments []*Segment
+	Spans    []*Span
+	Scopes   []*Scope
+	Symbols  []*Symbol
+	Lines    []*Line
+}
+
+// File is a source file.
+type File struct {
+	Id      int
+	Name    string
+	Size    int
+	Mtime   int64
+	Modules []int
+}
+
+// Module is an object file.
+type Module struct {
+	Id      int
+	Name    string
+	File    int // Main source file
+	Library int
+}
+
+// Segment is a segment in the linked output.
+type Segment struct {
+	Id       int
+	Name     string
+	Start    uint // CPU address
+	Size     uint
+	AddrSize string // "absolute", "zeropage", etc
+	Type     string // "ro" or "rw"
+
+	// Where the segment is in the output file.  OutputName is empty for
+	// segments that aren't written, like BSS.
+	OutputName   string
+	OutputOffset uint
+}
+
+// Span is a range of bytes in a segment.  Start is relative to the segment.
+type Span struct {
+	Id      int
+	Segment int
+	Start   uint
+	Size    uint
+	Type    int
+}
+
+// Address returns the CPU address of the span.
+func (s *Span) Address(d *DebugInfo) uint {
+	return d.Segments[s.Segment].Start + s.Start
+}
+
+// Scope is a .proc, .scope, or module scope.
+type Scope struct {
+	Id     int
+	Name   string
+	Module int
+	Type   string // "global", "file", "scope", "struct", or "enum"
+	Size   uint
+	Parent int
+	Symbol int // Label for .proc scopes
+	Spans  []int
+}
+
+// Symbol is a label, constant, or import.
+type Symbol struct {
+	Id       int
+	Name     string
+	AddrSize string
+	Scope    int
+	Parent   int // Parent for cheap local symbols
+	Def      []int
+	Ref      []int
+	Value    int
+	Segment  int
+	Size     uint
+	Type     string // "lab", "equ", or "imp"
+	Export   int    // Symbol this import resolves to
+}
+
+// Line is a line of source and the spans it produced.
+type Line struct {
+	Id    int
+	File  int
+	Line  int
+	Type  int // 0 for assembler source, 1 for C, 2 for macros
+	Count int // Macro nesting
+	Spans []int
+}
+
+// ReadDbgFile reads a .dbg file from ld65.
+func ReadDbgFile(filename string) (*DebugInfo, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadDbg(file)
+}
+
+// ReadDbg reads debug info written by ld65's --dbgfile option.  Unknown line
+// types and attributes are ignored.
+func ReadDbg(r io.Reader) (*DebugInfo, error) {
+	d := &DebugInfo{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	num := 0
+	for scanner.Scan() {
+		num++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		fields := strings.SplitN(text, "\t", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Line %d: missing attributes", num)
+		}
+
+		attr, err := parseAttributes(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %w", num, err)
+		}
+
+		if err = d.add(fields[0], attr); err != nil {
+			return nil, fmt.Errorf("Line %d: %w", num, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *DebugInfo) add(kind string, a attributes) error {
+	if kind == "version" {
+		d.Version = fmt.Sprintf("%d.%d", a.int("major"), a.int("minor"))
+		return a.err
+	}
+
+	id := a.int("id")
+
+	switch kind {
+	case "file":
+		item := &File{
+			Id:      id,
+			Name:    a.str("name"),
+			Size:    a.int("size"),
+			Mtime:   int64(a.int("mtime")),
+			Modules: a.list("mod"),
+		}
+		if id != len(d.Files) {
+			return fmt.Errorf("%s id %d is out of order", kind, id)
+		}
+		d.Files = append(d.Files, item)
+
+	case "mod":
+		item := &Module{
+			Id:      id,
+			Name:    a.str("name"),
+			File:    a.id("file"),
+			Library: a.id("lib"),
+		}
+		if id != len(d.Modules) {
+			return fmt.Errorf("%s id %d is out of order", kind, id)
+		}
+		d.Modules = append(d.Modules, item)
+
+	case "seg":
+		item := &Segment{
+			Id:           id,
+			Name:         a.str("name"),
+			Start:        uint(a.int("start")),
+			Size:         uint(a.int("size")),
+			AddrSize:     a.str("addrsize"),
+			Type:         a.str("type"),
+			OutputName:   a.str("oname"),
+			OutputOffset: uint(a.int("ooffs")),
+		}
+		if id != len(d.Segments) {
+			return fmt.Errorf("%s id %d is out of order", kind, id)
+		}
+		d.Segments = append(d.Segments, item)
+
+	case "span":
+		item := &Span{
+			Id:      id,
+			Segment: a.id("seg"),
+			Start:   uint(a.int("start")),
+			Size:    uint(a.int("size")),
+			Type:    a.id("type"),
+		}
+		if id != len(d.Spans) {
+			return fmt.Errorf("%s id %d is out of order", kind, id)
+		}
+		d.Spans = append(d.Spans, item)
+
+	case "scope":
+		item := &Scope{
+			Id:     id,
+			Name:   a.str("name"),
+			Module: a.id("mod"),
+			Type:   a.str("type"),
+			Size:   uint(a.int("size")),
+			Parent: a.id("parent"),
+			Symbol: a.id("sym"),
+			Spans:  a.list("span"),
+		}
+		if item.Type == "" {
+			item.Type = "global"
+		}
+		if id != len(d.Scopes) {
+			return fmt.Errorf("%s id %d is out of order", kind, id)
+		}
+		d.Scopes = append(d.Scopes, item)
+
+	case "sym":
+		item := &Symbol{
+			Id:       id,
+			Name:     a.str("name"),
+			AddrSize: a.str("addrsize"),
+			Scope:    a.id("scope"),
+			Parent:   a.id("parent"),
+			Def:      a.list("def"),
+			Ref:      a.list("ref"),
+			Value:    a.int("val"),
+			Segment:  a.id("seg"),
+			Size:     uint(a.int("size")),
+			Type:     a.str("type"),
+			Export:   a.id("exp"),
+		}
+		if id != len(d.Symbols) {
+			return fmt.Errorf("%s id %d is out of order", kind, id)
+		}
+		d.Symbols = append(d.Symbols, item)
+
+	case "line":
+		item := &Line{
+			Id:    id,
+			File:  a.id("file"),
+			Line:  a.int("line"),
+			Type:  a.int("type"),
+			Count: a.int("count"),
+			Spans: a.list("span"),
+		}
+		if id != len(d.Lines) {
+			return fmt.Errorf("%s id %d is out of order", kind, id)
+		}
+		d.Lines = append(d.Lines, item)
+	}
+
+	return a.err
+}
+
+// Symbol returns the first symbol with the given name that isn't an import.
+// Nil is returned if it isn't found.
+func (d *DebugInfo) Symbol(name string) *Symbol {
+	for _, sym := range d.Symbols {
+		if sym.Name == name && sym.Type != "imp" {
+			return sym
+		}
+	}
+	return nil
+}
+
+// PrgOffset returns the offset into PRG ROM of a symbol.  An error is returned
+// if the symbol isn't in a segment that is written to the ROM, or if it is
+// outside of PRG ROM.
+func (d *DebugInfo) PrgOffset(sym *Symbol, header *rom.Header) (uint, error) {
+	if sym.Type == "imp" && sym.Export >= 0 && sym.Export < len(d.Symbols) {
+		sym = d.Symbols[sym.Export]
+	}
+
+	if sym.Segment < 0 || sym.Segment >= len(d.Segments) {
+		return 0, fmt.Errorf("%s is not in a segment", sym.Name)
+	}
+
+	seg := d.Segments[sym.Segment]
+	if seg.OutputName == "" {
+		return 0, fmt.Errorf("%s is in %s, which isn't written to the ROM", sym.Name, seg.Name)
+	}
+
+	if sym.Value < int(seg.Start) || sym.Value >= int(seg.Start+seg.Size) {
+		return 0, fmt.Errorf("%s ($%04X) is outside of %s", sym.Name, sym.Value, seg.Name)
+	}
+
+	offset := seg.OutputOffset + uint(sym.Value) - seg.Start
+	start := header.PrgStart()
+	if offset < start || offset >= start+header.PrgSize {
+		return 0, fmt.Errorf("%s at file offset $%X is not in PRG ROM", sym.Name, offset)
+	}
+
+	return offset - start, nil
+}
+
+// Labels returns the PRG offsets of all the labels in the ROM, keyed by
+// name.  Cheap locals are named "parent@local".  Symbols that aren't in PRG ROM
+// are skipped.
+func (d *DebugInfo) Labels(header *rom.Header) map[string]uint {
+	labels := make(map[string]uint)
+	for _, sym := range d.Symbols {
+		if sym.Type != "lab" {
+			continue
+		}
+
+		offset, err := d.PrgOffset(sym, header)
+		if err != nil {
+			continue
+		}
+
+		name := sym.Name
+		if sym.Parent >= 0 && sym.Parent < len(d.Symbols) {
+			name = d.Symbols[sym.Parent].Name + name
+		}
+		labels[name] = offset
+	}
+	return labels
+}
+
+// attributes are the key=value pairs on a line.  The first error is kept and
+// returned after the line is done.
+type attributes struct {
+	values map[string]string
+	err    error
+}
+
+func parseAttributes(text string) (attributes, error) {
+	a := attributes{values: make(map[string]string)}
+
+	for text != "" {
+		eq := strings.IndexByte(text, '=')
+		if eq < 0 {
+			return a, fmt.Errorf("Missing = in %q", text)
+		}
+		key := text[:eq]
+		text = text[eq+1:]
+
+		var value string
+		if strings.HasPrefix(text, "\"") {
+			end := strings.IndexByte(text[1:], '"')
+			if end < 0 {
+				return a, fmt.Errorf("Unterminated string for %s", key)
+			}
+			value = text[1 : end+1]
+			text = text[end+2:]
+		} else if comma := strings.IndexByte(text, ','); comma >= 0 {
+			value = text[:comma]
+			text = text[comma:]
+		} else {
+			value = text
+			text = ""
+		}
+
+		a.values[key] = value
+		text = strings.TrimPrefix(text, ",")
+	}
+
+	return a, nil
+}
+
+func (a *attributes) str(key string) string {
+	return a.values[key]
+}
+
+// number parses a decimal or 0x prefixed hex value.
+func (a *attributes) number(key string, missing int) int {
+	value, ok := a.values[key]
+	if !ok {
+		return missing
+	}
+
+	val, err := strconv.ParseInt(value, 0, 64)
+	if err != nil && a.err == nil {
+		a.err = fmt.Errorf("Invalid value for %s: %q", key, value)
+	}
+	return int(val)
+}
+
+func (a *attributes) int(key string) int {
+	return a.number(key, 0)
+}
+
+// id is an ID that defaults to -1.
+func (a *attributes) id(key string) int {
+	return a.number(key, -1)
+}
+
+// list parses IDs joined with "+".
+func (a *attributes) list(key string) []int {
+	value, ok := a.values[key]
+	if !ok || value == "" {
+		return nil
+	}
+
+	ids := []int{}
+	for _, s := range strings.Split(value, "+") {
+		val, err := strconv.Atoi(s)
+		if err != nil && a.err == nil {
+			a.err = fmt.Errorf("Invalid value for %s: %q", key, value)
+		}
+		ids = append(ids, val)
+	}
+	return ids
+}
@@ -0,0 +1,312 @@
+package cc65
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zorchenhimer/go-nes/mapper"
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// Map is a parsed ld65 map file.
+type Map struct {
+	Modules  []MapModule
+	Segments []MapSegment
+	Exports  []MapExport
+	Imports  []MapImport
+}
+
+// MapModule is an object file and the segments it contributes to.
+type MapModule struct {
+	Name     string
+	Library  string // Empty if the module isn't from a library
+	Segments []MapModuleSegment
+}
+
+type MapModuleSegment struct {
+	Name   string
+	Offset uint // Offset into the linked segment
+	Size   uint
+}
+
+// MapSegment is a linked segment.  Start and End are CPU addresses.
+type MapSegment struct {
+	Name  string
+	Start uint
+	End   uint
+	Size  uint
+}
+
+// MapExport is an exported symbol.  Flags are ld65's three characters: R if
+// the symbol is referenced or a space if not, L for labels or E for equates,
+// and the address size (Z for zero page, A for absolute, etc).
+type MapExport struct {
+	Name  string
+	Value uint
+	Flags string
+}
+
+// IsLabel returns true if the export is a label rather than a constant.
+func (e MapExport) IsLabel() bool {
+	return len(e.Flags) > 1 && e.Flags[1] == 'L'
+}
+
+// MapImport is an imported symbol, the module that exports it, and where it
+// is imported.
+type MapImport struct {
+	Name       string
+	Module     string
+	References []MapReference
+}
+
+type MapReference struct {
+	Module   string
+	Location string // "file.s(12)"
+}
+
+// ReadMapFile reads a map file from ld65.
+func ReadMapFile(filename string) (*Map, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadMap(file)
+}
+
+// ReadMap reads a map file written by ld65's -m option.  Unknown sections
+// are ignored.
+func ReadMap(r io.Reader) (*Map, error) {
+	m := &Map{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	section := ""
+	num := 0
+	for scanner.Scan() {
+		num++
+		text := strings.TrimRight(scanner.Text(), "\r\t ")
+		trimmed := strings.TrimSpace(text)
+
+		// Section titles are followed by a line of dashes.  The segment list
+		// has a column header with another line of dashes.
+		if trimmed == "" || strings.HasPrefix(trimmed, "---") {
+			continue
+		}
+		if strings.HasSuffix(text, "list:") || strings.HasSuffix(text, "by name:") || strings.HasSuffix(text, "by value:") {
+			section = text
+			continue
+		}
+
+		var err error
+		switch section {
+		case "Modules list:":
+			err = m.addModule(text)
+		case "Segment list:":
+			err = m.addSegment(trimmed)
+		case "Exports list by name:":
+			err = m.addExports(text)
+		case "Imports list:":
+			err = m.addImport(text)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %w", num, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Map) addModule(text string) error {
+	if !strings.HasPrefix(text, " ") {
+		// "main.o:" or "nes.lib(crt0.o):"
+		name := strings.TrimSuffix(text, ":")
+		mod := MapModule{Name: name}
+		if open := strings.IndexByte(name, '('); open > 0 && strings.HasSuffix(name, ")") {
+			mod.Library = name[:open]
+			mod.Name = name[open+1 : len(name)-1]
+		}
+		m.Modules = append(m.Modules, mod)
+		return nil
+	}
+
+	if len(m.Modules) == 0 {
+		return fmt.Errorf("Segment without a module")
+	}
+
+	fields := strings.Fields(text)
+	seg := MapModuleSegment{Name: fields[0]}
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		val, err := strconv.ParseUint(kv[1], 16, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid %s value %q", kv[0], kv[1])
+		}
+
+		switch kv[0] {
+		case "Offs":
+			seg.Offset = uint(val)
+		case "Size":
+			seg.Size = uint(val)
+		}
+	}
+
+	mod := &m.Modules[len(m.Modules)-1]
+	mod.Segments = append(mod.Segments, seg)
+	return nil
+}
+
+func (m *Map) addSegment(text string) error {
+	fields := strings.Fields(text)
+	if len(fields) > 0 && fields[0] == "Name" {
+		return nil
+	}
+
+	if len(fields) < 4 {
+		return fmt.Errorf("Invalid segment line %q", text)
+	}
+
+	vals := [3]uint{}
+	for i := range vals {
+		val, err := strconv.ParseUint(fields[i+1], 16, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid segment line %q", text)
+		}
+		vals[i] = uint(val)
+	}
+
+	m.Segments = append(m.Segments, MapSegment{
+		Name:  fields[0],
+		Start: vals[0],
+		End:   vals[1],
+		Size:  vals[2],
+	})
+	return nil
+}
+
+// addExports parses a line of the exports list.  There are two exports per
+// line.  ld65 writes the flags as three characters right after the value, and
+// the first one is a space if the symbol isn't referenced.
+func (m *Map) addExports(text string) error {
+	rest := text
+	for {
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "" {
+			return nil
+		}
+
+		name, after := nextField(rest)
+		value, after := nextField(strings.TrimLeft(after, " "))
+		if value == "" {
+			return fmt.Errorf("Invalid export line %q", text)
+		}
+
+		val, err := strconv.ParseUint(value, 16, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid export value for %s: %q", name, value)
+		}
+
+		exp := MapExport{Name: name, Value: uint(val)}
+		if isFlags(after) {
+			exp.Flags = after[1:4]
+			after = after[4:]
+		}
+		rest = after
+
+		m.Exports = append(m.Exports, exp)
+	}
+}
+
+// nextField splits off everything up to the next space.
+func nextField(s string) (string, string) {
+	if idx := strings.IndexByte(s, ' '); idx >= 0 {
+		return s[:idx], s[idx:]
+	}
+	return s, ""
+}
+
+// isFlags returns true if s starts with a space followed by the export
+// flags: R or a space, L or E, and the address size.
+func isFlags(s string) bool {
+	if len(s) < 4 || s[0] != ' ' || (s[1] != 'R' && s[1] != ' ') || (s[2] != 'L' && s[2] != 'E') || s[3] == ' ' {
+		return false
+	}
+	return len(s) == 4 || s[4] == ' '
+}
+
+func (m *Map) addImport(text string) error {
+	if !strings.HasPrefix(text, " ") {
+		// "nmi (main.o):"
+		text = strings.TrimSuffix(text, ":")
+		imp := MapImport{Name: text}
+		if open := strings.Index(text, " ("); open > 0 {
+			imp.Name = text[:open]
+			imp.Module = strings.TrimSuffix(text[open+2:], ")")
+		}
+		m.Imports = append(m.Imports, imp)
+		return nil
+	}
+
+	if len(m.Imports) == 0 {
+		return fmt.Errorf("Reference without an import")
+	}
+
+	fields := strings.Fields(text)
+	ref := MapReference{Module: fields[0]}
+	if len(fields) > 1 {
+		ref.Location = fields[1]
+	}
+
+	imp := &m.Imports[len(m.Imports)-1]
+	imp.References = append(imp.References, ref)
+	return nil
+}
+
+// Export returns the export with the given name.
+func (m *Map) Export(name string) (MapExport, bool) {
+	for _, exp := range m.Exports {
+		if exp.Name == name {
+			return exp, true
+		}
+	}
+	return MapExport{}, false
+}
+
+// PrgOffset returns the offset into PRG ROM of an exported label.  Map files
+// don't say which bank a symbol is in, so only addresses in fixed banks can be
+// resolved.  Use the .dbg file for everything else.
+func (m *Map) PrgOffset(name string, header *rom.Header) (uint, error) {
+	exp, ok := m.Export(name)
+	if !ok {
+		return 0, fmt.Errorf("%s is not exported", name)
+	}
+
+	if exp.Value < 0x8000 || exp.Value > 0xFFFF {
+		return 0, fmt.Errorf("%s ($%04X) is not in PRG ROM", name, exp.Value)
+	}
+
+	layout, err := mapper.New(header.Mapper, header.PrgSize, header.ChrSize)
+	if err != nil {
+		return 0, err
+	}
+
+	bank, ok := layout.PrgFixedBank(uint16(exp.Value))
+	if !ok {
+		return 0, fmt.Errorf("%s ($%04X) is in a switchable bank", name, exp.Value)
+	}
+	return layout.PrgOffset(bank, uint16(exp.Value))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/alexflint/go-arg"
+	"github.com/zorchenhimer/go-nes/nsf"
+)
+
+type MainArgs struct {
+	Pack   *CmdPack   `arg:"subcommand:pack" help:"Assemble an NSF from pieces"`
+	Unpack *CmdUnpack `arg:"subcommand:unpack" help:"Split an NSF into banks"`
+	Info   *CmdInfo   `arg:"subcommand:info" help:"Print NSF info"`
+}
+
+type CmdPack struct {
+	Input  string `arg:"positional,required" help:"Directory containing meta.json and data to assemble"`
+	Output string `arg:"-o,--output" default:"" placeholder:"FILENAME" help:"Output NSF filename"`
+}
+
+type CmdUnpack struct {
+	Input     string `arg:"positional,required" help:"NSF file to split into pieces"`
+	Output    string `arg:"-o,--output" default:"" help:"Directory to put the pieces.  Defaults to the name of the input file without the extension."`
+	SplitSize int    `arg:"-s,--split" default:"0" help:"Bank file sizes in kb.  Defaults to 4 for bankswitched files.  Otherwise the data isn't split."`
+}
+
+type CmdInfo struct {
+	Input string `arg:"positional,required" help:"Input NSF file"`
+}
+
+type Metadata struct {
+	FileName string
+	*nsf.Nsf
+
+	// Number of padding bytes at the start of the first bank
+	Padding int
+	Banks   []string
+}
+
+func info(args *CmdInfo) error {
+	file, err := nsf.ReadFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Format:      ", file.Format)
+	if file.Format == nsf.NSF {
+		fmt.Println("Version:     ", file.Version)
+	}
+	fmt.Println("Songs:       ", file.TotalSongs)
+	fmt.Println("StartSong:   ", file.StartingSong)
+	fmt.Printf("LoadAddress:  $%04X\n", file.LoadAddress)
+	fmt.Printf("InitAddress:  $%04X\n", file.InitAddress)
+	fmt.Printf("PlayAddress:  $%04X\n", file.PlayAddress)
+	fmt.Println("SongName:    ", file.SongName)
+	fmt.Println("Artist:      ", file.Artist)
+	fmt.Println("Copyright:   ", file.Copyright)
+	if file.Ripper != "" {
+		fmt.Println("Ripper:      ", file.Ripper)
+	}
+	fmt.Println("Region:      ", file.Region)
+	fmt.Println("Expansion:   ", file.ExpansionAudio)
+	fmt.Println("NtscSpeed:   ", file.NtscSpeed)
+	fmt.Println("PalSpeed:    ", file.PalSpeed)
+	if file.DendySpeed != 0 {
+		fmt.Println("DendySpeed:  ", file.DendySpeed)
+	}
+
+	if file.IsBankswitched() {
+		fmt.Printf("Bankswitch:   % X\n", file.Bankswitch[:])
+	} else {
+		fmt.Println("Bankswitch:   None")
+	}
+	fmt.Println("DataSize:    ", len(file.Data))
+
+	for i, label := range file.TrackLabels {
+		fmt.Printf("Track %3d:    %s", i+1, label)
+		if i < len(file.TrackTimes) && file.TrackTimes[i] >= 0 {
+			fmt.Printf(" (%d:%02d)", file.TrackTimes[i]/60000, (file.TrackTimes[i]/1000)%60)
+		}
+		fmt.Println("")
+	}
+
+	if len(file.UnknownChunks) > 0 {
+		ids := []string{}
+		for id := range file.UnknownChunks {
+			ids = append(ids, id)
+		}
+		fmt.Println("OtherChunks: ", ids)
+	}
+
+	return nil
+}
+
+func unpack(args *CmdUnpack) error {
+	if args.Output == "" {
+		ext := filepath.Ext(args.Input)
+		args.Output = filepath.Base(args.Input[:len(args.Input)-len(ext)])
+	}
+	fmt.Println("Unpacking", args.Input, "to", args.Output)
+
+	file, err := nsf.ReadFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(args.Output, 0777)
+	if err != nil {
+		return err
+	}
+
+	meta := Metadata{
+		FileName: filepath.Base(args.Input),
+		Nsf:      file,
+		Padding:  file.BankPadding(),
+		Banks:    []string{},
+	}
+
+	size := args.SplitSize * 1024
+	if size == 0 {
+		if file.IsBankswitched() {
+			size = 4 * 1024
+		} else {
+			size = len(file.Data)
+		}
+	}
+
+	if size <= 0 {
+		return fmt.Errorf("Invalid split size: %d", args.SplitSize)
+	}
+
+	for i, bank := range file.Banks(size) {
+		outname := fmt.Sprintf("bank_%02X.bin", i)
+		err = os.WriteFile(filepath.Join(args.Output, outname), bank, 0666)
+		if err != nil {
+			return fmt.Errorf("Error writing bank data: %w", err)
+		}
+		meta.Banks = append(meta.Banks, outname)
+	}
+
+	rawjson, err := json.MarshalIndent(meta, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(args.Output, "meta.json"), rawjson, 0666)
+}
+
+func pack(args *CmdPack) error {
+	metaraw, err := os.ReadFile(filepath.Join(args.Input, "meta.json"))
+	if err != nil {
+		return fmt.Errorf("Unable to open meta.json: %w", err)
+	}
+
+	meta := &Metadata{}
+	err = json.Unmarshal(metaraw, meta)
+	if err != nil {
+		return fmt.Errorf("Error reading meta.json: %w", err)
+	}
+
+	if meta.Nsf == nil {
+		return fmt.Errorf("Missing NSF values in meta.json")
+	}
+
+	if args.Output == "" {
+		args.Output = meta.FileName
+	}
+
+	data := []byte{}
+	for _, bank := range meta.Banks {
+		infile := filepath.Join(args.Input, bank)
+		raw, err := os.ReadFile(infile)
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %w", infile, err)
+		}
+		data = append(data, raw...)
+	}
+
+	if meta.Padding > len(data) {
+		return fmt.Errorf("Padding larger than the bank data: %d", meta.Padding)
+	}
+	meta.Nsf.Data = data[meta.Padding:]
+
+	return meta.Nsf.WriteFile(args.Output)
+}
+
+func run(args *MainArgs) error {
+	switch {
+	case args.Pack != nil:
+		return pack(args.Pack)
+	case args.Unpack != nil:
+		return unpack(args.Unpack)
+	case args.Info != nil:
+		return info(args.Info)
+	default:
+		return fmt.Errorf("huh?")
+	}
+}
+
+func main() {
+	args := &MainArgs{}
+	p := arg.MustParse(args)
+	if p.Subcommand() == nil {
+		fmt.Fprintln(os.Stderr, "Missing command")
+		p.WriteUsage(os.Stderr)
+		os.Exit(1)
+	}
+
+	err := run(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -1,23 +1,51 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
 	"os"
 	"path/filepath"
-	//"strings"
-	"hash/crc32"
+	"strconv"
+	"strings"
 
 	"github.com/alexflint/go-arg"
+	"github.com/zorchenhimer/go-nes/asm"
+	"github.com/zorchenhimer/go-nes/catalog"
+	"github.com/zorchenhimer/go-nes/disasm"
+	"github.com/zorchenhimer/go-nes/freespace"
+	nesimg "github.com/zorchenhimer/go-nes/image"
+	"github.com/zorchenhimer/go-nes/mapper"
+	"github.com/zorchenhimer/go-nes/mesen"
+	"github.com/zorchenhimer/go-nes/patch"
 	ines "github.com/zorchenhimer/go-nes/rom"
 	//"github.com/zorchenhimer/go-nes/rom/ines"
 	//"github.com/zorchenhimer/go-nes/rom/unif"
 )
 
 type MainArgs struct {
-	Pack   *CmdPack   `arg:"subcommand:pack" help:"Assemble a complete ROM from pieces"`
-	Unpack *CmdUnpack `arg:"subcommand:unpack" help:"Split a rom into pieces"`
-	Info   *CmdInfo   `arg:"subcommand:info" help:"Print ROM info"`
+	Pack    *CmdPack    `arg:"subcommand:pack" help:"Assemble a complete ROM from pieces"`
+	Unpack  *CmdUnpack  `arg:"subcommand:unpack" help:"Split a rom into pieces"`
+	Info    *CmdInfo    `arg:"subcommand:info" help:"Print ROM info"`
+	Convert *CmdConvert `arg:"subcommand:convert" help:"Convert between UNIF and NES 2.0"`
+	Patch   *CmdPatch   `arg:"subcommand:patch" help:"Apply or create patches"`
+	Header  *CmdHeader  `arg:"subcommand:header" help:"Print or change iNES header fields"`
+
+	FixHeader *CmdFixHeader `arg:"subcommand:fix-header" help:"Replace headers with values from the NES 2.0 database"`
+	Lint      *CmdLint      `arg:"subcommand:lint" help:"Check iNES headers for problems"`
+	Identify  *CmdIdentify  `arg:"subcommand:identify" help:"Look up ROMs in a DAT file"`
+	Rename    *CmdRename    `arg:"subcommand:rename" help:"Rename ROMs to their names in a DAT file"`
+	Disasm    *CmdDisasm    `arg:"subcommand:disasm" help:"Disassemble a ROM into a ca65 project"`
+	Hack      *CmdHack      `arg:"subcommand:hack" help:"Assemble a patch into a ROM"`
+	Diff      *CmdDiff      `arg:"subcommand:diff" help:"Compare two ROMs by bank"`
+	Freespace *CmdFreespace `arg:"subcommand:freespace" help:"Find unused space in PRG and CHR banks"`
+	Catalog   *CmdCatalog   `arg:"subcommand:catalog" help:"List the headers and hashes of every ROM in directories and zip files"`
 }
 
 type CmdPack struct {
@@ -30,75 +58,253 @@ type CmdUnpack struct {
 	Output       string `arg:"-o,--output" default:"" help:"Directory to put the pieces.  Defaults to the name of the input file without the extension."`
 	PrgSplitSize int    `arg:"-p,--prg-split" default:"0" help:"PRG split file sizes.  A size of zero does not split the data across multiple files."`
 	ChrSplitSize int    `arg:"-c,--chr-split" default:"0" help:"CHR split file sizes.  A size of zero does not split the data across multiple files."`
+	Project      bool   `arg:"--project" help:"Also write a ca65 project that rebuilds the ROM.  PRG and CHR are split by the mapper's bank size."`
 }
 
 type CmdInfo struct {
 	Input string `arg:"positional,required" help:"Input ROM file"`
 }
 
+type CmdConvert struct {
+	Input  string `arg:"positional,required" help:"Input ROM file"`
+	Output string `arg:"positional,required" help:"Output ROM file"`
+	Format string `arg:"-f,--format" default:"" help:"Output format: nes or unif.  Defaults to the opposite of the input format."`
+}
+
+type CmdPatch struct {
+	Apply  *CmdPatchApply  `arg:"subcommand:apply" help:"Apply a patch to a file"`
+	Create *CmdPatchCreate `arg:"subcommand:create" help:"Create a patch from an original and a modified file"`
+	Info   *CmdPatchInfo   `arg:"subcommand:info" help:"Print patch info"`
+}
+
+type CmdPatchApply struct {
+	Input  string `arg:"positional,required" help:"File to patch"`
+	Patch  string `arg:"positional,required" help:"Patch file (.ips, .bps, .rup, .ups, or .xdelta)"`
+	Output string `arg:"-o,--output" default:"" help:"Output filename.  Defaults to the patch filename with the input file's extension."`
+	Crc    string `arg:"--crc" default:"" help:"Expected CRC32 of the input file, in hex.  The patch is not applied if it doesn't match."`
+}
+
+type CmdPatchCreate struct {
+	Original string `arg:"positional,required" help:"Unmodified file"`
+	Modified string `arg:"positional,required" help:"Modified file"`
+	Output   string `arg:"-o,--output" default:"" help:"Output patch filename.  The extension picks the format (.ips, .bps, .rup, or .ups).  Defaults to the modified filename with an .ips extension."`
+}
+
+type CmdPatchInfo struct {
+	Input string `arg:"positional,required" help:"Patch file"`
+}
+
+type CmdHeader struct {
+	Get *CmdHeaderGet `arg:"subcommand:get" help:"Print the header as JSON"`
+	Set *CmdHeaderSet `arg:"subcommand:set" help:"Change header fields"`
+}
+
+type CmdHeaderGet struct {
+	Input string `arg:"positional,required" help:"iNES ROM file"`
+}
+
+type CmdHeaderSet struct {
+	Input  string   `arg:"positional,required" help:"iNES ROM file"`
+	Fields []string `arg:"positional,required" placeholder:"FIELD=VALUE" help:"Fields to change: mapper, submapper, mirroring, battery, nes2, timing, console, prg-ram, prg-nvram, chr-ram, or chr-nvram"`
+	Output string   `arg:"-o,--output" help:"Output filename [default: overwrite the input]"`
+	Force  bool     `arg:"-f,--force" help:"Write the header even if it has new problems"`
+	DryRun bool     `arg:"-n,--dry-run" help:"Print the header changes without writing anything"`
+}
+
+type CmdFixHeader struct {
+	Input    []string `arg:"positional,required" help:"ROM files to fix"`
+	Database string   `arg:"--db,required" help:"Local copy of the NES 2.0 XML database (nes20db.xml)"`
+	DryRun   bool     `arg:"-n,--dry-run" help:"Print the header changes without writing anything"`
+}
+
+type CmdLint struct {
+	Input  []string `arg:"positional,required" help:"ROM files to check"`
+	Strict bool     `arg:"--strict" help:"Treat warnings as errors"`
+}
+
+type CmdIdentify struct {
+	Input []string `arg:"positional,required" help:"ROM files to look up"`
+	Dat   string   `arg:"--dat,required" help:"Logiqx XML DAT file (No-Intro, etc)"`
+}
+
+type CmdRename struct {
+	Input  []string `arg:"positional,required" help:"ROM files to rename"`
+	Dat    string   `arg:"--dat,required" help:"Logiqx XML DAT file (No-Intro, etc)"`
+	DryRun bool     `arg:"-n,--dry-run" help:"Print the new names without renaming anything"`
+}
+
+type CmdDisasm struct {
+	Input     string `arg:"positional,required" help:"iNES ROM file"`
+	Output    string `arg:"-o,--output" help:"Output directory [default: ROM name]"`
+	Workspace string `arg:"--workspace" help:"Mesen2 workspace file with labels"`
+	Cdl       string `arg:"--cdl" help:"FCEUX or Mesen code/data log"`
+	Illegal   bool   `arg:"--illegal" help:"Trace through unofficial opcodes"`
+}
+
+type CmdHack struct {
+	Source string `arg:"positional,required" help:"Assembly source.  Each block of code needs an .org"`
+	Input  string `arg:"positional,required" help:"iNES ROM file"`
+	Output string `arg:"-o,--output" help:"Patched ROM filename [default: input name with _hack added]"`
+	Patch  string `arg:"--patch" help:"Also write a patch against the input (.ips, .bps, .rup, or .ups)"`
+	Bank   int    `arg:"-b,--bank" default:"-1" help:"PRG bank for switchable windows, in units of the window size.  Fixed windows always use their bank."`
+}
+
+type CmdDiff struct {
+	Original string `arg:"positional,required" help:"Original iNES ROM"`
+	Modified string `arg:"positional,required" help:"Modified iNES ROM"`
+	Png      string `arg:"--png" help:"Write the changed CHR tiles to a PNG.  Original tiles are on the left, modified on the right."`
+	Json     bool   `arg:"--json" help:"Print the differences as JSON"`
+}
+
+type CmdFreespace struct {
+	Input string   `arg:"positional,required" help:"iNES ROM file"`
+	Fill  []string `arg:"--fill,separate" placeholder:"HEX" help:"PRG fill byte.  Can be given more than once [default: FF and 00]"`
+	Min   uint     `arg:"--min" default:"16" help:"Shortest run of fill bytes to report"`
+	Cdl   string   `arg:"--cdl" help:"Code/data log.  Bytes it marks as used are never free."`
+}
+
+type CmdCatalog struct {
+	Input  []string `arg:"positional,required" help:"Directories, ROM files, or zip files"`
+	Output string   `arg:"-o,--output" help:"Output filename [default: stdout]"`
+	Csv    bool     `arg:"--csv" help:"Write CSV instead of JSON Lines.  Implied by a .csv output filename."`
+	Update string   `arg:"--update" placeholder:"CATALOG" help:"Previous JSON Lines catalog.  Files with the same size and modification time are not read again."`
+	Jobs   int      `arg:"-j,--jobs" help:"Files to read at once [default: number of CPUs]"`
+}
+
 type Metadata struct {
 	RomName string
-	Header  *ines.Header
-	Prg     []string
-	Chr     []string `json:",omitempty"`
-	Misc    string   `json:",omitempty"`
+	Header  *ines.Header `json:",omitempty"`
+	Trainer string       `json:",omitempty"`
+	Prg     []string     `json:",omitempty"`
+	Chr     []string     `json:",omitempty"`
+	Misc    string       `json:",omitempty"`
+
+	Fds *FdsMetadata `json:",omitempty"`
+}
+
+type FdsMetadata struct {
+	Format ines.FdsFormat
+	Sides  []FdsSideMeta
+}
+
+type FdsSideMeta struct {
+	Info       *ines.FdsDiskInfo
+	FileAmount int
+	Files      []FdsFileMeta
+	Trailing   string `json:",omitempty"`
+}
+
+type FdsFileMeta struct {
+	*ines.FdsFile
+	File string
 }
 
 func info(args *CmdInfo) error {
-	rom, err := ines.ReadRom(args.Input)
+	rom, err := ines.LoadRom(args.Input)
 	if err != nil {
 		return fmt.Errorf("Error reading rom: %v", err)
 	}
 
-	prghsh := crc32.New(crc32.IEEETable)
-	chrhsh := crc32.New(crc32.IEEETable)
+	fmt.Println("Format:      ", rom.RomType())
+	if unif, ok := rom.(*ines.UnifRom); ok {
+		fmt.Println("Board:       ", unif.Mapper)
+	}
 
-	_, err = prghsh.Write(rom.PrgRom())
-	if err != nil {
-		return err
+	if fds, ok := rom.(*ines.FdsRom); ok {
+		return fdsInfo(fds)
 	}
 
-	_, err = chrhsh.Write(rom.ChrRom())
+	header, err := rom.NesHeader()
 	if err != nil {
-		return err
+		return fmt.Errorf("Unable to read header values: %w", err)
 	}
 
-	fmt.Println("PrgSize:     ", rom.Header.PrgSize)
-	fmt.Println("ChrSize:     ", rom.Header.ChrSize)
-	fmt.Println("MiscSize:    ", rom.Header.ChrSize)
-	fmt.Println("Trainer:     ", rom.Header.TrainerPresent)
-	fmt.Println("PersistMem:  ", rom.Header.PersistentMemory)
-	fmt.Println("Mirroring:   ", rom.Header.Mirroring)
-	fmt.Println("NES 2.0:     ", rom.Header.Nes2)
-	fmt.Println("Mapper:      ", rom.Header.Mapper)
-	fmt.Println("SubMapper:   ", rom.Header.SubMapper)
+	fmt.Println("PrgSize:     ", header.PrgSize)
+	fmt.Println("ChrSize:     ", header.ChrSize)
+	fmt.Println("MiscSize:    ", header.MiscSize)
+	fmt.Println("Trainer:     ", header.TrainerPresent)
+	fmt.Println("PersistMem:  ", header.PersistentMemory)
+	fmt.Println("Mirroring:   ", header.Mirroring)
+	fmt.Println("NES 2.0:     ", header.Nes2)
+	fmt.Println("Mapper:      ", header.Mapper)
+	fmt.Println("SubMapper:   ", header.SubMapper)
+	fmt.Println("Console:     ", header.Console)
+
+	if header.Nes2 {
+		fmt.Println("Timing:      ", header.Timing)
+
+		switch header.Console {
+		case ines.CT_VSSYSTEM:
+			fmt.Println("VsPpu:       ", header.VsPpu)
+			fmt.Println("VsHardware:  ", header.VsHardware)
+		case ines.CT_EXTENDED:
+			fmt.Println("ExtConsole:  ", header.ExtendedConsole)
+		}
+
+		fmt.Println("MiscRoms:    ", header.MiscRomCount)
+		fmt.Println("Expansion:   ", header.ExpansionDevice)
+	}
 
-	if rom.Header.PrgRamSize > 0 {
-		fmt.Println("PrgRamSize:  ", 64<<rom.Header.PrgRamSize)
+	if header.PrgRamSize > 0 {
+		fmt.Println("PrgRamSize:  ", 64<<header.PrgRamSize)
 	} else {
 		fmt.Println("PrgRamSize:  0")
 	}
 
-	if rom.Header.PrgNvramSize > 0 {
-		fmt.Println("PrgNvramSize:", 64<<rom.Header.PrgNvramSize)
+	if header.PrgNvramSize > 0 {
+		fmt.Println("PrgNvramSize:", 64<<header.PrgNvramSize)
 	} else {
 		fmt.Println("PrgNvramSize: 0")
 	}
 
-	if rom.Header.ChrRamSize > 0 {
-		fmt.Println("ChrRamSize:  ", 64<<rom.Header.ChrRamSize)
+	if header.ChrRamSize > 0 {
+		fmt.Println("ChrRamSize:  ", 64<<header.ChrRamSize)
 	} else {
 		fmt.Println("ChrRamSize:   0")
 	}
 
-	if rom.Header.ChrNvramSize > 0 {
-		fmt.Println("ChrNvramSize:", 64<<rom.Header.ChrNvramSize)
+	if header.ChrNvramSize > 0 {
+		fmt.Println("ChrNvramSize:", 64<<header.ChrNvramSize)
 	} else {
 		fmt.Println("ChrNvramSize: 0")
 	}
 
-	fmt.Printf("PRG CRC:      %08X\n", prghsh.Sum32())
-	fmt.Printf("CHR CRC:      %08X\n", chrhsh.Sum32())
+	if nes, ok := rom.(*ines.NesRom); ok && len(nes.MiscRom) > 0 {
+		fmt.Println("Misc CRC:    ", nes.MiscCrcString())
+	}
+
+	return printHashes(rom)
+}
+
+func printHashes(rom ines.Rom) error {
+	hashes, err := ines.HashRom(rom)
+	if err != nil {
+		return fmt.Errorf("Unable to hash ROM: %w", err)
+	}
+
+	parts := []struct {
+		name   string
+		hashes *ines.Hashes
+	}{
+		{"File", hashes.File},
+		{"Headerless", hashes.Headerless},
+		{"PRG", hashes.Prg},
+		{"CHR", hashes.Chr},
+		{"Trainer", hashes.Trainer},
+	}
+
+	for _, p := range parts {
+		if p.hashes == nil {
+			continue
+		}
+
+		fmt.Println("")
+		fmt.Printf("%s (%d bytes)\n", p.name, p.hashes.Size)
+		fmt.Println("  CRC32: ", p.hashes.Crc32.HexString())
+		fmt.Println("  MD5:   ", p.hashes.Md5)
+		fmt.Println("  SHA1:  ", p.hashes.Sha1)
+		fmt.Println("  SHA256:", p.hashes.Sha256)
+	}
 
 	return nil
 }
@@ -119,7 +325,32 @@ func pack(args *CmdPack) error {
 		args.Output = meta.RomName
 	}
 
-	rom := meta.Header.Bytes()
+	if meta.Fds != nil {
+		return packFds(args, meta.Fds)
+	}
+
+	if meta.Header == nil {
+		return fmt.Errorf("Missing header in meta.json")
+	}
+
+	rom, err := meta.Header.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if meta.Trainer != "" {
+		infile := filepath.Join(args.Input, meta.Trainer)
+		raw, err := os.ReadFile(infile)
+		if err != nil {
+			return fmt.Errorf("Error reading %s: %w", infile, err)
+		}
+
+		if len(raw) != 512 {
+			return fmt.Errorf("Trainer must be 512 bytes, %s is %d bytes", infile, len(raw))
+		}
+		rom = append(rom, raw...)
+	}
+
 	for _, prg := range meta.Prg {
 		infile := filepath.Join(args.Input, prg)
 		raw, err := os.ReadFile(infile)
@@ -151,6 +382,14 @@ func pack(args *CmdPack) error {
 }
 
 func unpack(args *CmdUnpack) error {
+	if args.Project && args.PrgSplitSize != 0 {
+		return fmt.Errorf("--prg-split can't be used with --project.  PRG is split by bank.")
+	}
+
+	if args.Project && args.ChrSplitSize != 0 {
+		return fmt.Errorf("--chr-split can't be used with --project.  CHR is split by bank.")
+	}
+
 	if args.Output == "" {
 		ext := filepath.Ext(args.Input)
 		args.Output = filepath.Base(args.Input[:len(args.Input)-len(ext)])
@@ -162,11 +401,23 @@ func unpack(args *CmdUnpack) error {
 		return err
 	}
 
-	rom, err := ines.ReadRom(args.Input)
+	loaded, err := ines.LoadRom(args.Input)
 	if err != nil {
 		return fmt.Errorf("Error reading rom: %v", err)
 	}
 
+	if fds, ok := loaded.(*ines.FdsRom); ok {
+		if args.Project {
+			return fmt.Errorf("--project isn't supported for FDS images")
+		}
+		return unpackFds(args, fds)
+	}
+
+	rom, ok := loaded.(*ines.NesRom)
+	if !ok {
+		return fmt.Errorf("Unpacking %s files is not supported.  Convert to NES 2.0 first.", loaded.RomType())
+	}
+
 	meta := Metadata{
 		RomName: filepath.Base(args.Input),
 		Header:  rom.Header,
@@ -186,12 +437,23 @@ func unpack(args *CmdUnpack) error {
 		return fmt.Errorf("PRG can only be split in multiples of 8kb")
 	}
 
-	err, meta.Prg = writeBin(rom.PrgRom(), args.PrgSplitSize, args.Output, "prg")
-	if err != nil {
-		return fmt.Errorf("Error writing PRG data: %w", err)
+	if rom.Header.TrainerPresent {
+		err = os.WriteFile(filepath.Join(args.Output, "trainer.bin"), rom.Trainer, 0666)
+		if err != nil {
+			return fmt.Errorf("Error writing Trainer data: %w", err)
+		}
+		meta.Trainer = "trainer.bin"
 	}
 
-	if rom.Header.ChrSize > 0 {
+	// The project writes PRG and CHR one bank per file.
+	if !args.Project {
+		err, meta.Prg = writeBin(rom.PrgRom(), args.PrgSplitSize, args.Output, "prg")
+		if err != nil {
+			return fmt.Errorf("Error writing PRG data: %w", err)
+		}
+	}
+
+	if rom.Header.ChrSize > 0 && !args.Project {
 		err, meta.Chr = writeBin(rom.ChrRom(), args.ChrSplitSize, args.Output, "chr")
 		if err != nil {
 			return fmt.Errorf("Error writing CHR data: %w", err)
@@ -203,7 +465,13 @@ func unpack(args *CmdUnpack) error {
 		if err != nil {
 			return fmt.Errorf("Error writing Misc data: %w", err)
 		}
-		meta.Misc = filepath.Join(args.Output, "misc.dat")
+		meta.Misc = "misc.dat"
+	}
+
+	if args.Project {
+		if err = unpackProject(args, rom, &meta); err != nil {
+			return err
+		}
 	}
 
 	rawjson, err := json.MarshalIndent(meta, "", "    ")
@@ -219,43 +487,1547 @@ func unpack(args *CmdUnpack) error {
 	return nil
 }
 
-func writeBin(raw []byte, size int, outdir, prefix string) (error, []string) {
-	names := []string{}
-	size *= 1024
-	for i := 0; i < len(raw)/size; i++ {
-		start, end := i*size, (i*size)+size
-		//fmt.Printf("[%d] start:%d end:%d len:%d exit:%d\n", i, start, end, len(raw), len(raw)/size)
+// unpackProject writes PRG and CHR one bank per file, then the sources, linker
+// config, and Makefile to rebuild the ROM from the unpacked files.
+func unpackProject(args *CmdUnpack, rom *ines.NesRom, meta *Metadata) error {
+	header, err := rom.Header.Bytes()
+	if err != nil {
+		return err
+	}
 
-		var dat []byte
-		if i+size > len(raw) {
-			dat = raw[start:len(raw)]
+	if orig, err := readHeaderBytes(args.Input); err == nil && !bytes.Equal(orig, header) {
+		fmt.Fprintf(os.Stderr, "The header in %s isn't normalized.  The rebuilt ROM will have a different header.\n", args.Input)
+	}
+
+	area, err := writeHeaderSource(args.Output, header)
+	if err != nil {
+		return err
+	}
+	areas := []projectArea{area}
+
+	if meta.Trainer != "" {
+		area := projectArea{Name: "TRAINER", Start: 0x7000, Size: uint(len(rom.Trainer)), Source: "trainer.s", Binary: meta.Trainer}
+		if err = writeIncbinSource(args.Output, area); err != nil {
+			return err
+		}
+		areas = append(areas, area)
+	}
+
+	prg := rom.PrgRom()
+	bankSize, bases := prgBanks(rom)
+	for i, base := range bases {
+		start := uint(i) * bankSize
+		end := start + bankSize
+		if end > uint(len(prg)) {
+			end = uint(len(prg))
+		}
+
+		area := projectArea{
+			Name:   fmt.Sprintf("PRG_%02X", i),
+			Start:  uint(base),
+			Size:   end - start,
+			Source: fmt.Sprintf("prg_%02X.s", i),
+			Binary: fmt.Sprintf("prg_%02X.bin", i),
+		}
+		if err = writeIncbin(args.Output, area, prg[start:end]); err != nil {
+			return fmt.Errorf("Error writing PRG data: %w", err)
+		}
+		meta.Prg = append(meta.Prg, area.Binary)
+		areas = append(areas, area)
+	}
+
+	chr := rom.ChrRom()
+	chrSize := chrBankSize(rom)
+	for i, start := 0, uint(0); start < uint(len(chr)); i, start = i+1, start+chrSize {
+		end := start + chrSize
+		if end > uint(len(chr)) {
+			end = uint(len(chr))
+		}
+
+		area := projectArea{
+			Name:   fmt.Sprintf("CHR_%02X", i),
+			Size:   end - start,
+			Source: fmt.Sprintf("chr_%02X.s", i),
+			Binary: fmt.Sprintf("chr_%02X.bin", i),
+		}
+		if err = writeIncbin(args.Output, area, chr[start:end]); err != nil {
+			return fmt.Errorf("Error writing CHR data: %w", err)
+		}
+		meta.Chr = append(meta.Chr, area.Binary)
+		areas = append(areas, area)
+	}
+
+	if meta.Misc != "" {
+		area := projectArea{Name: "MISC", Size: uint(len(rom.MiscRom)), Source: "misc.s", Binary: meta.Misc}
+		if err = writeIncbinSource(args.Output, area); err != nil {
+			return err
+		}
+		areas = append(areas, area)
+	}
+
+	ext := filepath.Ext(args.Input)
+	err = writeProject(args.Output, filepath.Base(args.Input[:len(args.Input)-len(ext)]), areas)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote a project with %d PRG banks\n", len(bases))
+	return nil
+}
+
+// readHeaderBytes returns the first 16 bytes of a file.
+func readHeaderBytes(filename string) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 16)
+	_, err = io.ReadFull(file, header)
+	return header, err
+}
+
+func fdsInfo(fds *ines.FdsRom) error {
+	fmt.Println("FDS Format:  ", fds.Format)
+	fmt.Println("Sides:       ", len(fds.Sides))
+
+	for i, side := range fds.Sides {
+		fmt.Printf("\nSide %d: %q rev %d, disk %d side %d, boot file $%02X\n",
+			i,
+			side.Info.GameName,
+			side.Info.Revision,
+			side.Info.DiskNumber,
+			side.Info.SideNumber,
+			side.Info.BootFile,
+		)
+		fmt.Printf("  Files: %d (%d hidden)\n", len(side.Files), len(side.Files)-side.FileAmount)
+		fmt.Println("  ## ID Name     Load   Size Kind")
+		for _, file := range side.Files {
+			fmt.Println(" ", file)
+		}
+	}
+
+	return printHashes(fds)
+}
+
+func unpackFds(args *CmdUnpack, fds *ines.FdsRom) error {
+	meta := Metadata{
+		RomName: filepath.Base(args.Input),
+		Fds: &FdsMetadata{
+			Format: fds.Format,
+			Sides:  []FdsSideMeta{},
+		},
+	}
+
+	for i, side := range fds.Sides {
+		sm := FdsSideMeta{
+			Info:       side.Info,
+			FileAmount: side.FileAmount,
+			Files:      []FdsFileMeta{},
+		}
+
+		for j, file := range side.Files {
+			name := fmt.Sprintf("side%d_%02X_%s.bin", i, j, sanitizeName(file.Name))
+			err := os.WriteFile(filepath.Join(args.Output, name), file.Data, 0666)
+			if err != nil {
+				return fmt.Errorf("Error writing %s: %w", name, err)
+			}
+			sm.Files = append(sm.Files, FdsFileMeta{FdsFile: file, File: name})
+		}
+
+		if len(side.Trailing) > 0 {
+			name := fmt.Sprintf("side%d_trailing.bin", i)
+			err := os.WriteFile(filepath.Join(args.Output, name), side.Trailing, 0666)
+			if err != nil {
+				return fmt.Errorf("Error writing %s: %w", name, err)
+			}
+			sm.Trailing = name
+		}
+
+		meta.Fds.Sides = append(meta.Fds.Sides, sm)
+	}
+
+	rawjson, err := json.MarshalIndent(meta, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(args.Output, "meta.json"), rawjson, 0666)
+}
+
+// sanitizeName makes an FDS file name usable in a filename.
+func sanitizeName(name string) string {
+	clean := []rune{}
+	for _, r := range strings.TrimRight(name, "\x00 ") {
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			clean = append(clean, r)
 		} else {
-			dat = raw[start:end]
+			clean = append(clean, '_')
 		}
+	}
+	return string(clean)
+}
 
-		outname := filepath.Join(outdir, fmt.Sprintf("%s_%02X.bin", prefix, i))
-		names = append(names, filepath.Base(outname))
-		err := os.WriteFile(outname, dat, 0666)
-		if err != nil {
-			return err, nil
+func packFds(args *CmdPack, meta *FdsMetadata) error {
+	fds := &ines.FdsRom{Format: meta.Format}
+
+	for i, sm := range meta.Sides {
+		side := &ines.FdsSide{
+			Info:       sm.Info,
+			FileAmount: sm.FileAmount,
+		}
+
+		for _, fm := range sm.Files {
+			if fm.FdsFile == nil {
+				return fmt.Errorf("Missing file info for %s on side %d", fm.File, i)
+			}
+
+			infile := filepath.Join(args.Input, fm.File)
+			raw, err := os.ReadFile(infile)
+			if err != nil {
+				return fmt.Errorf("Error reading %s: %w", infile, err)
+			}
+
+			file := *fm.FdsFile
+			file.Data = raw
+			side.Files = append(side.Files, &file)
+		}
+
+		if sm.Trailing != "" {
+			infile := filepath.Join(args.Input, sm.Trailing)
+			raw, err := os.ReadFile(infile)
+			if err != nil {
+				return fmt.Errorf("Error reading %s: %w", infile, err)
+			}
+			side.Trailing = raw
 		}
+
+		fds.Sides = append(fds.Sides, side)
 	}
-	return nil, names
+
+	raw, err := fds.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(args.Output, raw, 0666)
 }
 
-func run(args *MainArgs) error {
-	switch {
-	case args.Pack != nil:
-		return pack(args.Pack)
-	case args.Unpack != nil:
-		return unpack(args.Unpack)
-	case args.Info != nil:
-		return info(args.Info)
+func convert(args *CmdConvert) error {
+	input, err := ines.LoadRom(args.Input)
+	if err != nil {
+		return fmt.Errorf("Error reading rom: %w", err)
+	}
+
+	format := strings.ToLower(args.Format)
+	if format == "" {
+		switch input.RomType() {
+		case ines.UNIF:
+			format = "nes"
+		default:
+			format = "unif"
+		}
+	}
+
+	buf := &bytes.Buffer{}
+
+	switch format {
+	case "nes":
+		var nes *ines.NesRom
+		switch r := input.(type) {
+		case *ines.UnifRom:
+			nes, err = r.NesRom()
+			if err != nil {
+				return err
+			}
+		case *ines.NesRom:
+			nes = r
+			nes.Header.Nes2 = true
+		default:
+			return fmt.Errorf("Unable to convert %s to NES 2.0", input.RomType())
+		}
+
+		_, err = nes.WriteTo(buf)
+		if err != nil {
+			return err
+		}
+
+	case "unif", "unf":
+		var unif *ines.UnifRom
+		switch r := input.(type) {
+		case *ines.NesRom:
+			unif, err = r.Unif()
+			if err != nil {
+				return err
+			}
+		case *ines.UnifRom:
+			unif = r
+		default:
+			return fmt.Errorf("Unable to convert %s to UNIF", input.RomType())
+		}
+
+		err = unif.Write(buf)
+		if err != nil {
+			return err
+		}
+
 	default:
-		return fmt.Errorf("huh?")
+		return fmt.Errorf("Unknown output format %q", args.Format)
 	}
 
-	return nil
+	return os.WriteFile(args.Output, buf.Bytes(), 0666)
+}
+
+func patchApply(args *CmdPatchApply) error {
+	source, err := os.ReadFile(args.Input)
+	if err != nil {
+		return fmt.Errorf("Error reading input: %w", err)
+	}
+
+	if args.Crc != "" {
+		expected, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(args.Crc), "0x"), 16, 32)
+		if err != nil {
+			return fmt.Errorf("Invalid CRC %q: %w", args.Crc, err)
+		}
+
+		crc := ines.Crc32(crc32.ChecksumIEEE(source))
+		if crc != ines.Crc32(expected) {
+			return fmt.Errorf("Input CRC mismatch: expected %s, found %s", ines.Crc32(expected).HexString(), crc.HexString())
+		}
+	}
+
+	p, err := patch.ReadFile(args.Patch)
+	if err != nil {
+		return err
+	}
+
+	target, err := p.Apply(source)
+	if err != nil {
+		return fmt.Errorf("Error applying patch: %w", err)
+	}
+
+	if args.Output == "" {
+		ext := filepath.Ext(args.Patch)
+		args.Output = args.Patch[:len(args.Patch)-len(ext)] + filepath.Ext(args.Input)
+	}
+
+	fmt.Printf("Writing %s (CRC32 %s)\n", args.Output, ines.Crc32(crc32.ChecksumIEEE(target)).HexString())
+	return os.WriteFile(args.Output, target, 0666)
+}
+
+func patchCreate(args *CmdPatchCreate) error {
+	source, err := os.ReadFile(args.Original)
+	if err != nil {
+		return fmt.Errorf("Error reading original: %w", err)
+	}
+
+	target, err := os.ReadFile(args.Modified)
+	if err != nil {
+		return fmt.Errorf("Error reading modified: %w", err)
+	}
+
+	if args.Output == "" {
+		ext := filepath.Ext(args.Modified)
+		args.Output = args.Modified[:len(args.Modified)-len(ext)] + ".ips"
+	}
+
+	p, err := patch.Create(args.Output, source, target)
+	if err != nil {
+		return err
+	}
+
+	raw, err := p.Bytes()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Original CRC32: %s\n", ines.Crc32(crc32.ChecksumIEEE(source)).HexString())
+	return os.WriteFile(args.Output, raw, 0666)
+}
+
+func patchInfo(args *CmdPatchInfo) error {
+	p, err := patch.ReadFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	switch p := p.(type) {
+	case *patch.Ips:
+		fmt.Println("Format:      IPS")
+		fmt.Println("Records:    ", len(p.Records))
+		if p.Truncate > 0 {
+			fmt.Println("Truncate:   ", p.Truncate)
+		}
+
+	case *patch.Bps:
+		fmt.Println("Format:      BPS")
+		fmt.Println("SourceSize: ", p.SourceSize)
+		fmt.Println("SourceCrc:  ", p.SourceCrc.HexString())
+		fmt.Println("TargetSize: ", p.TargetSize)
+		fmt.Println("TargetCrc:  ", p.TargetCrc.HexString())
+		fmt.Println("Actions:    ", len(p.Actions))
+		if len(p.Metadata) > 0 {
+			fmt.Println("Metadata:   ", string(p.Metadata))
+		}
+
+	case *patch.Ups:
+		fmt.Println("Format:      UPS")
+		fmt.Println("SourceSize: ", p.SourceSize)
+		fmt.Println("SourceCrc:  ", p.SourceCrc.HexString())
+		fmt.Println("TargetSize: ", p.TargetSize)
+		fmt.Println("TargetCrc:  ", p.TargetCrc.HexString())
+		fmt.Println("Hunks:      ", len(p.Hunks))
+
+	case *patch.Vcdiff:
+		fmt.Println("Format:      VCDIFF")
+		fmt.Println("TargetSize: ", p.TargetSize())
+		fmt.Println("Windows:    ", len(p.Windows))
+		if len(p.AppHeader) > 0 {
+			fmt.Printf("AppHeader:   %q\n", p.AppHeader)
+		}
+
+	case *patch.Ninja:
+		fmt.Println("Format:      NINJA 2.0")
+		fmt.Println("Encoding:   ", p.Encoding)
+		fmt.Println("Author:     ", p.Author)
+		fmt.Println("Version:    ", p.Version)
+		fmt.Println("Title:      ", p.Title)
+		fmt.Println("Genre:      ", p.Genre)
+		fmt.Println("Language:   ", p.Language)
+		fmt.Println("Date:       ", p.Date)
+		fmt.Println("Website:    ", p.Website)
+		fmt.Println("Description:")
+		for _, line := range strings.Split(p.Description, "\n") {
+			fmt.Println("    " + line)
+		}
+
+		for _, f := range p.Files {
+			fmt.Println("")
+			if f.Name != "" {
+				fmt.Println("File:       ", f.Name)
+			}
+			fmt.Println("Type:       ", f.Type)
+			fmt.Println("SourceSize: ", f.SourceSize)
+			fmt.Printf("SourceMd5:   %x\n", f.SourceMd5)
+			fmt.Println("ModSize:    ", f.ModifiedSize)
+			fmt.Printf("ModMd5:      %x\n", f.ModifiedMd5)
+			fmt.Println("Records:    ", len(f.Records))
+		}
+	}
+
+	return nil
+}
+
+func fixHeader(args *CmdFixHeader) error {
+	db, err := ines.LoadNes20Db(args.Database)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, filename := range args.Input {
+		err = fixHeaderFile(db, filename, args.DryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files could not be fixed", failed, len(args.Input))
+	}
+	return nil
+}
+
+func fixHeaderFile(db *ines.Nes20Db, filename string, dryRun bool) error {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	if len(raw) < 16 || !bytes.Equal(raw[:4], []byte{0x4E, 0x45, 0x53, 0x1A}) {
+		return fmt.Errorf("Not an iNES file")
+	}
+
+	header, game, err := db.FindHeader(raw)
+	if err != nil {
+		return err
+	}
+
+	size := 16 + header.PrgSize + header.ChrSize + header.MiscSize
+	if header.TrainerPresent {
+		size += 512
+	}
+
+	if size != uint(len(raw)) {
+		return fmt.Errorf("File size does not match the database: expected %d, found %d", size, len(raw))
+	}
+
+	fixed, err := header.Bytes()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: %s\n", filename, game.Name)
+
+	// A header that doesn't parse at all is replaced without a diff.
+	old, err := ines.ParseHeader(raw[:16])
+	if err != nil {
+		fmt.Printf("    Unreadable header: %v\n", err)
+	} else {
+		changes := old.Diff(header)
+		if len(changes) == 0 && bytes.Equal(raw[:16], fixed) {
+			fmt.Println("    Header is correct")
+			return nil
+		}
+
+		for _, c := range changes {
+			fmt.Printf("    %-16s %s -> %s\n", c.Field+":", c.Old, c.New)
+		}
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	copy(raw[:16], fixed)
+	return os.WriteFile(filename, raw, 0666)
+}
+
+// readInesFile reads a file and parses its iNES header.
+func readInesFile(filename string) ([]byte, *ines.Header, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(raw) < 16 || !bytes.Equal(raw[:4], []byte{0x4E, 0x45, 0x53, 0x1A}) {
+		return nil, nil, fmt.Errorf("%s is not an iNES file", filename)
+	}
+
+	header, err := ines.ParseHeader(raw[:16])
+	if err != nil {
+		return nil, nil, err
+	}
+	return raw, header, nil
+}
+
+func headerGet(args *CmdHeaderGet) error {
+	_, header, err := readInesFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.MarshalIndent(header, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(raw))
+	return nil
+}
+
+func headerSet(args *CmdHeaderSet) error {
+	raw, old, err := readInesFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	header := *old
+	for _, field := range args.Fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("Invalid field %q.  Use FIELD=VALUE.", field)
+		}
+
+		if err = header.Set(strings.ToLower(kv[0]), kv[1]); err != nil {
+			return err
+		}
+	}
+
+	if err = header.Encodable(); err != nil {
+		return err
+	}
+
+	encoded, err := header.Bytes()
+	if err != nil {
+		return err
+	}
+
+	changes := old.Diff(&header)
+	if len(changes) == 0 {
+		fmt.Println("Header is unchanged")
+		return nil
+	}
+
+	for _, c := range changes {
+		fmt.Printf("    %-16s %s -> %s\n", c.Field+":", c.Old, c.New)
+	}
+
+	modified := make([]byte, len(raw))
+	copy(modified, raw)
+	copy(modified, encoded)
+
+	// Only complain about problems that weren't already there.  The file
+	// size has to match no matter what.
+	existing := map[ines.Finding]bool{}
+	for _, f := range ines.ValidateInes(raw) {
+		existing[f] = true
+	}
+
+	problems := 0
+	for _, f := range ines.ValidateInes(modified) {
+		if existing[f] {
+			continue
+		}
+
+		fmt.Printf("    %s\n", f)
+		if f.Check == ines.HC_FILE_SIZE && f.Severity == ines.SV_ERROR {
+			return fmt.Errorf("The header doesn't match the size of %s", args.Input)
+		}
+		problems++
+	}
+
+	if problems > 0 && !args.Force {
+		return fmt.Errorf("The new header has problems.  Use --force to write it anyway.")
+	}
+
+	if args.DryRun {
+		return nil
+	}
+
+	if args.Output == "" {
+		args.Output = args.Input
+	}
+	return os.WriteFile(args.Output, modified, 0666)
+}
+
+func lint(args *CmdLint) error {
+	errors := 0
+	warnings := 0
+
+	for _, filename := range args.Input {
+		raw, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Printf("%s: error: %v\n", filename, err)
+			errors++
+			continue
+		}
+
+		for _, f := range ines.ValidateInes(raw) {
+			fmt.Printf("%s: %s\n", filename, f)
+			if f.Severity == ines.SV_ERROR {
+				errors++
+			} else {
+				warnings++
+			}
+		}
+	}
+
+	fmt.Printf("%d files checked: %d errors, %d warnings\n", len(args.Input), errors, warnings)
+
+	if errors > 0 || (args.Strict && warnings > 0) {
+		return fmt.Errorf("Lint failed")
+	}
+	return nil
+}
+
+// hashFile hashes a ROM file.  Files that fail to load are hashed as-is, with
+// the first 16 bytes skipped for the headerless hash if it looks like an iNES
+// file.
+func hashFile(filename string) (*ines.RomHashes, error) {
+	rom, err := ines.LoadRom(filename)
+	if err == nil {
+		return ines.HashRom(rom)
+	}
+
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := &ines.RomHashes{File: ines.HashData(raw)}
+	if len(raw) > 16 && bytes.HasPrefix(raw, []byte{0x4E, 0x45, 0x53, 0x1A}) {
+		hashes.Headerless = ines.HashData(raw[16:])
+	}
+	return hashes, nil
+}
+
+func identify(args *CmdIdentify) error {
+	dat, err := ines.LoadDat(args.Dat)
+	if err != nil {
+		return err
+	}
+
+	missing := 0
+	for _, filename := range args.Input {
+		hashes, err := hashFile(filename)
+		if err != nil {
+			fmt.Printf("%s: %v\n", filename, err)
+			missing++
+			continue
+		}
+
+		match := dat.Match(hashes)
+		if match == nil {
+			fmt.Printf("%s: not found\n", filename)
+			missing++
+			continue
+		}
+
+		region := match.Game.Region()
+		if region == "" {
+			region = "Unknown"
+		}
+
+		status := "unverified"
+		if match.Game.Verified() {
+			status = "verified"
+		}
+
+		note := ""
+		if match.Headerless {
+			note = " (headerless match)"
+		}
+
+		fmt.Printf("%s: %s [%s] %s%s\n", filename, match.Game.Name, region, status, note)
+	}
+
+	if missing > 0 {
+		return fmt.Errorf("%d of %d files not found", missing, len(args.Input))
+	}
+	return nil
+}
+
+func rename(args *CmdRename) error {
+	dat, err := ines.LoadDat(args.Dat)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, filename := range args.Input {
+		hashes, err := hashFile(filename)
+		if err != nil {
+			fmt.Printf("%s: %v\n", filename, err)
+			failed++
+			continue
+		}
+
+		match := dat.Match(hashes)
+		if match == nil {
+			fmt.Printf("%s: not found\n", filename)
+			failed++
+			continue
+		}
+
+		// Keep the original extension.  Headerless DATs can have a
+		// different one.
+		name := match.Rom.Name
+		name = safeFileName(name[:len(name)-len(filepath.Ext(name))]) + filepath.Ext(filename)
+		newname := filepath.Join(filepath.Dir(filename), name)
+
+		if newname == filename {
+			continue
+		}
+
+		if _, err := os.Stat(newname); err == nil {
+			fmt.Printf("%s: %s already exists\n", filename, newname)
+			failed++
+			continue
+		}
+
+		fmt.Printf("%s -> %s\n", filename, newname)
+		if args.DryRun {
+			continue
+		}
+
+		err = os.Rename(filename, newname)
+		if err != nil {
+			fmt.Printf("%s: %v\n", filename, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files not renamed", failed, len(args.Input))
+	}
+	return nil
+}
+
+// safeFileName replaces characters that aren't allowed in file names.  DAT
+// names are otherwise kept as-is.
+func safeFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// prgBanks returns the PRG bank size and the CPU address of each bank.  The
+// most likely address from the mapper is used.  Unknown mappers are split into
+// 16k banks at $8000 with the last bank at $C000.
+func prgBanks(rom ines.Rom) (uint, []uint16) {
+	size := rom.PrgSize()
+	layout, err := mapper.FromRom(rom)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v.  Using 16k banks.\n", err)
+
+		bases := []uint16{}
+		for start := uint(0); start < size; start += 0x4000 {
+			bases = append(bases, 0x8000)
+		}
+		if len(bases) > 0 {
+			bases[len(bases)-1] = 0xC000
+		}
+		return 0x4000, bases
+	}
+
+	bankSize := layout.PrgBankSize()
+	bases := []uint16{}
+	for start := uint(0); start < size; start += bankSize {
+		base := uint16(0x8000)
+		locs := layout.PrgLocations(start)
+		if len(locs) > 0 {
+			base = locs[0].Address
+		}
+
+		// Put the last bank where the vectors are if it can go there.
+		// NROM-128 is at $8000 and $C000.
+		if start+bankSize >= size {
+			for _, loc := range locs {
+				if uint(loc.Address)+bankSize == 0x10000 {
+					base = loc.Address
+					break
+				}
+			}
+		}
+		bases = append(bases, base)
+	}
+	return bankSize, bases
+}
+
+// chrBankSize returns the mapper's CHR bank size, or 8k if the mapper isn't
+// known.  prgBanks has already warned about unknown mappers.
+func chrBankSize(rom ines.Rom) uint {
+	layout, err := mapper.FromRom(rom)
+	if err != nil || layout.ChrBankSize() == 0 {
+		return 0x2000
+	}
+	return layout.ChrBankSize()
+}
+
+// cpuLabel is a label from a Mesen workspace.  PRG ROM labels are offsets
+// into PRG, everything else is a CPU address.
+type cpuLabel struct {
+	Address uint
+	Name    string
+	Comment string
+}
+
+func loadWorkspaceLabels(filename string) (prg []cpuLabel, cpu []cpuLabel, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	ws, err := mesen.LoadWorkspace(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to load workspace: %w", err)
+	}
+
+	for _, l := range ws.Labels {
+		label := cpuLabel{Address: l.Address, Name: l.Label, Comment: l.Comment}
+		switch l.MemoryType {
+		case mesen.NesPrgRom:
+			prg = append(prg, label)
+		case mesen.NesInternalRam, mesen.NesMemory:
+			cpu = append(cpu, label)
+		case mesen.NesSaveRam, mesen.NesWorkRam:
+			label.Address += 0x6000
+			cpu = append(cpu, label)
+		}
+	}
+
+	return prg, cpu, nil
+}
+
+func disassemble(args *CmdDisasm) error {
+	raw, err := os.ReadFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	rom, err := ines.ReadInes(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("Error reading rom: %w", err)
+	}
+
+	if args.Output == "" {
+		ext := filepath.Ext(args.Input)
+		args.Output = filepath.Base(args.Input[:len(args.Input)-len(ext)])
+	}
+
+	err = os.MkdirAll(args.Output, 0777)
+	if err != nil {
+		return err
+	}
+
+	prgLabels, cpuLabels := []cpuLabel{}, []cpuLabel{}
+	if args.Workspace != "" {
+		prgLabels, cpuLabels, err = loadWorkspaceLabels(args.Workspace)
+		if err != nil {
+			return err
+		}
+	}
+
+	var cdl *ines.Cdl
+	if args.Cdl != "" {
+		cdl, err = ines.LoadCdl(args.Cdl, rom.PrgSize(), rom.ChrSize())
+		if err != nil {
+			return err
+		}
+	}
+
+	area, err := writeHeaderSource(args.Output, raw[:16])
+	if err != nil {
+		return err
+	}
+	areas := []projectArea{area}
+
+	if len(rom.Trainer) > 0 {
+		area := projectArea{Name: "TRAINER", Start: 0x7000, Size: uint(len(rom.Trainer)), Source: "trainer.s", Binary: "trainer.bin"}
+		if err = writeIncbin(args.Output, area, rom.Trainer); err != nil {
+			return err
+		}
+		areas = append(areas, area)
+	}
+
+	prg := rom.PrgRom()
+	bankSize, bases := prgBanks(rom)
+	for i, base := range bases {
+		start := uint(i) * bankSize
+		end := start + bankSize
+		if end > uint(len(prg)) {
+			end = uint(len(prg))
+		}
+
+		area := projectArea{
+			Name:   fmt.Sprintf("PRG_%02X", i),
+			Start:  uint(base),
+			Size:   end - start,
+			Source: fmt.Sprintf("prg_%02X.s", i),
+		}
+
+		d := disasm.New(prg[start:end], base)
+		d.Illegal = args.Illegal
+		d.Segment = area.Name
+
+		for _, l := range cpuLabels {
+			if l.Address <= 0xFFFF && !d.Contains(uint16(l.Address)) {
+				d.SetLabel(uint16(l.Address), l.Name, l.Comment)
+			}
+		}
+
+		for _, l := range prgLabels {
+			if l.Address >= start && l.Address < end {
+				d.SetLabel(base+uint16(l.Address-start), l.Name, l.Comment)
+			}
+		}
+
+		if cdl != nil {
+			d.Flags = cdl.Prg[start:end]
+			d.TraceCdl()
+		}
+		d.TraceVectors()
+
+		src := &bytes.Buffer{}
+		fmt.Fprintf(src, "; PRG bank $%02X, $%06X-$%06X in PRG ROM, mapped at $%04X\n\n", i, start, end-1, base)
+		if _, err = d.WriteTo(src); err != nil {
+			return err
+		}
+
+		err = os.WriteFile(filepath.Join(args.Output, area.Source), src.Bytes(), 0666)
+		if err != nil {
+			return err
+		}
+		areas = append(areas, area)
+	}
+
+	if chr := rom.ChrRom(); len(chr) > 0 {
+		area := projectArea{Name: "CHR", Size: uint(len(chr)), Source: "chr.s", Binary: "chr.bin"}
+		if err = writeIncbin(args.Output, area, chr); err != nil {
+			return err
+		}
+		areas = append(areas, area)
+	}
+
+	if len(rom.MiscRom) > 0 {
+		area := projectArea{Name: "MISC", Size: uint(len(rom.MiscRom)), Source: "misc.s", Binary: "misc.bin"}
+		if err = writeIncbin(args.Output, area, rom.MiscRom); err != nil {
+			return err
+		}
+		areas = append(areas, area)
+	}
+
+	ext := filepath.Ext(args.Input)
+	err = writeProject(args.Output, filepath.Base(args.Input[:len(args.Input)-len(ext)]), areas)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %d PRG banks to %s\n", len(bases), args.Output)
+	return nil
+}
+
+// projectArea is a MEMORY area and the segment of the same name in an ld65
+// config.
+type projectArea struct {
+	Name   string
+	Start  uint
+	Size   uint
+	Source string // ca65 source file
+	Binary string // included by Source, if not empty
+}
+
+// writeIncbin writes data to the area's binary file and a source file that
+// includes it.
+func writeIncbin(dir string, area projectArea, data []byte) error {
+	err := os.WriteFile(filepath.Join(dir, area.Binary), data, 0666)
+	if err != nil {
+		return err
+	}
+	return writeIncbinSource(dir, area)
+}
+
+// writeIncbinSource writes a source file that includes the area's existing
+// binary file.
+func writeIncbinSource(dir string, area projectArea) error {
+	src := fmt.Sprintf(".segment %q\n\n.incbin %q\n", area.Name, area.Binary)
+	return os.WriteFile(filepath.Join(dir, area.Source), []byte(src), 0666)
+}
+
+// writeHeaderSource writes header.s with the 16 byte iNES header.
+func writeHeaderSource(dir string, header []byte) (projectArea, error) {
+	area := projectArea{Name: "HEADER", Size: 16, Source: "header.s"}
+	src := fmt.Sprintf(".segment \"HEADER\"\n\n.byte %s\n", byteList(header))
+	err := os.WriteFile(filepath.Join(dir, area.Source), []byte(src), 0666)
+	return area, err
+}
+
+// writeProject writes an ld65 config and a Makefile that builds name.nes from
+// the areas, in order.
+func writeProject(dir, name string, areas []projectArea) error {
+	cfg := &strings.Builder{}
+	cfg.WriteString("MEMORY {\n")
+	for _, a := range areas {
+		fmt.Fprintf(cfg, "    %s: start = $%04X, size = $%04X, fill = yes, file = %%O;\n", a.Name, a.Start, a.Size)
+	}
+	cfg.WriteString("}\n\nSEGMENTS {\n")
+	for _, a := range areas {
+		fmt.Fprintf(cfg, "    %s: load = %s, type = ro;\n", a.Name, a.Name)
+	}
+	cfg.WriteString("}\n")
+
+	err := os.WriteFile(filepath.Join(dir, "nes.cfg"), []byte(cfg.String()), 0666)
+	if err != nil {
+		return err
+	}
+
+	sources := []string{}
+	deps := []string{}
+	for _, a := range areas {
+		sources = append(sources, a.Source)
+		if a.Binary != "" {
+			deps = append(deps, fmt.Sprintf("%s: %s\n", strings.TrimSuffix(a.Source, ".s")+".o", a.Binary))
+		}
+	}
+
+	mk := &strings.Builder{}
+	fmt.Fprintf(mk, "NAME := %s\n", sanitizeName(name))
+	fmt.Fprintf(mk, "SOURCES := %s\n", strings.Join(sources, " "))
+	mk.WriteString("OBJECTS := $(SOURCES:.s=.o)\n\n")
+	mk.WriteString(".PHONY: all clean\n\n")
+	mk.WriteString("all: $(NAME).nes\n\n")
+	mk.WriteString("clean:\n\t-rm -f $(OBJECTS) $(NAME).nes\n\n")
+	mk.WriteString("$(NAME).nes: nes.cfg $(OBJECTS)\n\tld65 -C nes.cfg -o $@ $(OBJECTS)\n\n")
+	mk.WriteString("%.o: %.s\n\tca65 -o $@ $<\n")
+	if len(deps) > 0 {
+		mk.WriteString("\n" + strings.Join(deps, ""))
+	}
+
+	return os.WriteFile(filepath.Join(dir, "Makefile"), []byte(mk.String()), 0666)
+}
+
+func byteList(data []byte) string {
+	vals := []string{}
+	for _, b := range data {
+		vals = append(vals, fmt.Sprintf("$%02X", b))
+	}
+	return strings.Join(vals, ", ")
+}
+
+func writeBin(raw []byte, size int, outdir, prefix string) (error, []string) {
+	names := []string{}
+	size *= 1024
+	for i := 0; i < len(raw)/size; i++ {
+		start, end := i*size, (i*size)+size
+		//fmt.Printf("[%d] start:%d end:%d len:%d exit:%d\n", i, start, end, len(raw), len(raw)/size)
+
+		var dat []byte
+		if i+size > len(raw) {
+			dat = raw[start:len(raw)]
+		} else {
+			dat = raw[start:end]
+		}
+
+		outname := filepath.Join(outdir, fmt.Sprintf("%s_%02X.bin", prefix, i))
+		names = append(names, filepath.Base(outname))
+		err := os.WriteFile(outname, dat, 0666)
+		if err != nil {
+			return err, nil
+		}
+	}
+	return nil, names
+}
+
+func hack(args *CmdHack) error {
+	raw, err := os.ReadFile(args.Input)
+	if err != nil {
+		return err
+	}
+
+	rom, err := ines.ReadInes(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("Error reading rom: %w", err)
+	}
+
+	layout, err := mapper.FromRom(rom)
+	if err != nil {
+		return err
+	}
+
+	prog, err := asm.AssembleFile(args.Source, -1)
+	if err != nil {
+		return err
+	}
+
+	err = prog.WriteRom(rom, args.Bank)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range prog.Chunks {
+		offset, err := asm.PrgOffset(layout, args.Bank, chunk.Address)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("$%04X-$%04X PRG offset $%05X: %d bytes\n",
+			chunk.Address, int(chunk.Address)+len(chunk.Data)-1, offset, len(chunk.Data))
+	}
+
+	target, err := rom.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if args.Output == "" {
+		ext := filepath.Ext(args.Input)
+		args.Output = args.Input[:len(args.Input)-len(ext)] + "_hack" + ext
+	}
+
+	fmt.Printf("Writing %s (CRC32 %s)\n", args.Output, ines.Crc32(crc32.ChecksumIEEE(target)).HexString())
+	err = os.WriteFile(args.Output, target, 0666)
+	if err != nil {
+		return err
+	}
+
+	if args.Patch == "" {
+		return nil
+	}
+
+	p, err := patch.Create(args.Patch, raw, target)
+	if err != nil {
+		return err
+	}
+
+	data, err := p.Bytes()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Writing %s\n", args.Patch)
+	return os.WriteFile(args.Patch, data, 0666)
+}
+
+// RomDiff is the output of the diff command.
+type RomDiff struct {
+	Original string
+	Modified string
+
+	Header []ines.HeaderChange
+	Prg    []DiffRange
+	Chr    []DiffRange
+
+	// Indexes of the CHR tiles that changed
+	ChrTiles []int
+}
+
+// DiffRange is a run of changed bytes inside of a single bank.  Bytes that are
+// only in one of the files count as changed.
+type DiffRange struct {
+	Bank       uint
+	Offset     uint // Offset into PRG or CHR
+	FileOffset uint // Offset into the original file
+	Size       uint
+	Address    string // bank:address in CPU or PPU space
+}
+
+func diff(args *CmdDiff) error {
+	a, err := ines.ReadRom(args.Original)
+	if err != nil {
+		return err
+	}
+
+	b, err := ines.ReadRom(args.Modified)
+	if err != nil {
+		return err
+	}
+
+	result := RomDiff{
+		Original: args.Original,
+		Modified: args.Modified,
+		Header:   a.Header.Diff(b.Header),
+	}
+
+	// Addresses come from the original ROM's mapper.
+	prgSize, bases := prgBanks(a)
+	result.Prg = diffRanges(a.Prgrom, b.Prgrom, prgSize, a.Header.PrgStart(), func(offset uint) string {
+		bank := offset / prgSize
+		if bank >= uint(len(bases)) {
+			return ""
+		}
+		return fmt.Sprintf("%02X:%04X", bank, uint(bases[bank])+offset%prgSize)
+	})
+
+	chrSize := uint(0x2000)
+	layout, err := mapper.FromRom(a)
+	if err == nil && layout.ChrBankSize() != 0 {
+		chrSize = layout.ChrBankSize()
+	}
+	result.Chr = diffRanges(a.Chrrom, b.Chrrom, chrSize, a.Header.ChrStart(), func(offset uint) string {
+		if layout != nil {
+			if locs := layout.ChrLocations(offset); len(locs) > 0 {
+				return fmt.Sprintf("%02X:%04X", offset/chrSize, locs[0].Address)
+			}
+		}
+		return fmt.Sprintf("%02X:%04X", offset/chrSize, offset%0x2000)
+	})
+
+	for i := 0; i < len(a.Chrrom) || i < len(b.Chrrom); i += 16 {
+		if !bytes.Equal(chrTile(a.Chrrom, i), chrTile(b.Chrrom, i)) {
+			result.ChrTiles = append(result.ChrTiles, i/16)
+		}
+	}
+
+	if args.Png != "" {
+		if len(result.ChrTiles) == 0 {
+			fmt.Fprintln(os.Stderr, "No CHR tiles changed.  Not writing", args.Png)
+		} else if err = writeTileDiff(args.Png, a.Chrrom, b.Chrrom, result.ChrTiles); err != nil {
+			return err
+		}
+	}
+
+	if args.Json {
+		raw, err := json.MarshalIndent(result, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(raw))
+		return nil
+	}
+
+	if len(result.Header) > 0 {
+		fmt.Println("Header:")
+		for _, c := range result.Header {
+			fmt.Printf("    %s: %s -> %s\n", c.Field, c.Old, c.New)
+		}
+	}
+
+	printDiffRanges("PRG", result.Prg, prgSize)
+	printDiffRanges("CHR", result.Chr, chrSize)
+
+	if len(result.ChrTiles) > 0 {
+		fmt.Printf("%d CHR tiles changed\n", len(result.ChrTiles))
+	}
+
+	if len(result.Header) == 0 && len(result.Prg) == 0 && len(result.Chr) == 0 {
+		fmt.Println("ROMs are identical")
+	}
+	return nil
+}
+
+// diffRanges returns the changed ranges between a and b.  Ranges are split at
+// bank boundaries.
+func diffRanges(a, b []byte, bankSize, fileStart uint, address func(offset uint) string) []DiffRange {
+	ranges := []DiffRange{}
+	length := uint(len(a))
+	if uint(len(b)) > length {
+		length = uint(len(b))
+	}
+
+	var current *DiffRange
+	for i := uint(0); i < length; i++ {
+		same := i < uint(len(a)) && i < uint(len(b)) && a[i] == b[i]
+		if same || (current != nil && i%bankSize == 0) {
+			current = nil
+		}
+
+		if same {
+			continue
+		}
+
+		if current == nil {
+			ranges = append(ranges, DiffRange{
+				Bank:       i / bankSize,
+				Offset:     i,
+				FileOffset: fileStart + i,
+				Address:    address(i),
+			})
+			current = &ranges[len(ranges)-1]
+		}
+		current.Size++
+	}
+
+	return ranges
+}
+
+func printDiffRanges(name string, ranges []DiffRange, bankSize uint) {
+	total := uint(0)
+	for i, r := range ranges {
+		if i == 0 || ranges[i-1].Bank != r.Bank {
+			fmt.Printf("%s bank %02X (%dk):\n", name, r.Bank, bankSize/1024)
+		}
+		fmt.Printf("    file $%06X-$%06X  %s $%05X  %s  %d bytes\n",
+			r.FileOffset, r.FileOffset+r.Size-1, name, r.Offset, r.Address, r.Size)
+		total += r.Size
+	}
+
+	if len(ranges) > 0 {
+		fmt.Printf("%s: %d bytes changed in %d ranges\n", name, total, len(ranges))
+	}
+}
+
+// chrTile returns the 16 bytes of a tile, or nil if it's past the end of the
+// data.
+func chrTile(chr []byte, offset int) []byte {
+	if offset+16 > len(chr) {
+		return nil
+	}
+	return chr[offset : offset+16]
+}
+
+// writeTileDiff draws each changed tile, original and modified side by side,
+// eight pairs to a row.
+func writeTileDiff(filename string, a, b []byte, tiles []int) error {
+	const scale = 2
+	const tile = 8 * scale
+	const pairWidth = tile*2 + 2
+	const gap = 6
+	const perRow = 8
+
+	ptA, err := nesimg.ReadCHR(bytes.NewReader(a[:len(a)/16*16]))
+	if err != nil {
+		return err
+	}
+	ptB, err := nesimg.ReadCHR(bytes.NewReader(b[:len(b)/16*16]))
+	if err != nil {
+		return err
+	}
+
+	rows := (len(tiles) + perRow - 1) / perRow
+	cols := perRow
+	if len(tiles) < perRow {
+		cols = len(tiles)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, cols*(pairWidth+gap)-gap, rows*(tile+gap)-gap))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{0x40, 0x40, 0x40, 0xFF}}, image.Point{}, draw.Src)
+
+	drawTile := func(pt *nesimg.PatternTable, id, x0, y0 int) {
+		if id >= len(pt.Patterns) {
+			return
+		}
+		t := pt.Patterns[id]
+		for y := 0; y < tile; y++ {
+			for x := 0; x < tile; x++ {
+				img.Set(x0+x, y0+y, t.At(x/scale, y/scale))
+			}
+		}
+	}
+
+	for i, id := range tiles {
+		x := (i % perRow) * (pairWidth + gap)
+		y := (i / perRow) * (tile + gap)
+		drawTile(ptA, id, x, y)
+		drawTile(ptB, id, x+tile+2, y)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return png.Encode(file, img)
+}
+
+func findFreespace(args *CmdFreespace) error {
+	rom, err := ines.ReadRom(args.Input)
+	if err != nil {
+		return err
+	}
+
+	opts := freespace.Options{MinLength: args.Min}
+	for _, f := range args.Fill {
+		val, err := strconv.ParseUint(strings.TrimPrefix(f, "$"), 16, 8)
+		if err != nil {
+			return fmt.Errorf("Invalid fill byte %q", f)
+		}
+		opts.Fill = append(opts.Fill, byte(val))
+	}
+
+	if args.Cdl != "" {
+		opts.Cdl, err = ines.LoadCdl(args.Cdl, rom.PrgSize(), rom.ChrSize())
+		if err != nil {
+			return err
+		}
+	}
+
+	printFreespace("PRG", freespace.Prg(rom, opts), true)
+
+	chr, err := freespace.Chr(rom, opts)
+	if err != nil {
+		return err
+	}
+	if chr.Size == 0 {
+		fmt.Println("CHR: none (CHR RAM)")
+	} else {
+		printFreespace("CHR", chr, false)
+	}
+	return nil
+}
+
+func printFreespace(name string, report *freespace.Report, prg bool) {
+	for i, r := range report.Ranges {
+		if i == 0 || report.Ranges[i-1].Bank != r.Bank {
+			fmt.Printf("%s bank %02X (%dk): %d bytes free\n",
+				name, r.Bank, report.BankSize/1024, report.BankFree(r.Bank))
+		}
+
+		fill := fmt.Sprintf("%d tiles", r.Size()/16)
+		if prg {
+			fill = fmt.Sprintf("$%02X", r.Fill)
+		}
+		fmt.Printf("    %s $%05X-$%05X  $%04X-$%04X  %5d bytes  %s\n",
+			name, r.Start, r.End, r.Address, r.EndAddress(), r.Size(), fill)
+	}
+
+	fmt.Printf("%s: %d of %d bytes free (%.1f%%) in %d ranges\n",
+		name, report.Free, report.Size, report.Percent(), len(report.Ranges))
+}
+
+func writeCatalog(args *CmdCatalog) error {
+	opts := catalog.Options{Workers: args.Jobs}
+	if args.Update != "" {
+		var err error
+		opts.Previous, err = catalog.LoadJSONL(args.Update)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	c, err := catalog.Scan(args.Input, opts)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if args.Output != "" {
+		out, err = os.Create(args.Output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	if args.Csv || strings.ToLower(filepath.Ext(args.Output)) == ".csv" {
+		err = c.WriteCSV(out)
+	} else {
+		err = c.WriteJSONL(out)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, e := range c.Entries {
+		if e.Error != "" {
+			failed++
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "%d ROMs in %d files, %d unchanged, %d errors\n", len(c.Entries), c.Files, c.Reused, failed)
+	return nil
+}
+
+func run(args *MainArgs) error {
+	switch {
+	case args.Pack != nil:
+		return pack(args.Pack)
+	case args.Unpack != nil:
+		return unpack(args.Unpack)
+	case args.Info != nil:
+		return info(args.Info)
+	case args.Convert != nil:
+		return convert(args.Convert)
+	case args.Identify != nil:
+		return identify(args.Identify)
+	case args.Rename != nil:
+		return rename(args.Rename)
+	case args.Disasm != nil:
+		return disassemble(args.Disasm)
+	case args.Hack != nil:
+		return hack(args.Hack)
+	case args.Diff != nil:
+		return diff(args.Diff)
+	case args.Freespace != nil:
+		return findFreespace(args.Freespace)
+	case args.Catalog != nil:
+		return writeCatalog(args.Catalog)
+	case args.Lint != nil:
+		return lint(args.Lint)
+	case args.FixHeader != nil:
+		return fixHeader(args.FixHeader)
+	case args.Patch != nil && args.Patch.Apply != nil:
+		return patchApply(args.Patch.Apply)
+	case args.Patch != nil && args.Patch.Create != nil:
+		return patchCreate(args.Patch.Create)
+	case args.Patch != nil && args.Patch.Info != nil:
+		return patchInfo(args.Patch.Info)
+	case args.Patch != nil:
+		return fmt.Errorf("Missing patch command: apply, create, or info")
+	case args.Header != nil && args.Header.Get != nil:
+		return headerGet(args.Header.Get)
+	case args.Header != nil && args.Header.Set != nil:
+		return headerSet(args.Header.Set)
+	case args.Header != nil:
+		return fmt.Errorf("Missing header command: get or set")
+	default:
+		return fmt.Errorf("huh?")
+	}
 }
 
 func main() {
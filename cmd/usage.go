@@ -11,9 +11,11 @@ import (
 	"image/png"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/alexflint/go-arg"
 	nesimg "github.com/zorchenhimer/go-nes/image"
+	"github.com/zorchenhimer/go-nes/mapper"
 	ines "github.com/zorchenhimer/go-nes/rom"
 )
 
@@ -21,6 +23,7 @@ type Options struct {
 	Input   string `arg:"positional,required" help:"input rom file"`
 	Output  string `arg:"required" help:"output image file"`
 	ChrSize int    `arg:"-c,--chr-size" default:"8" help:"CHR split size"`
+	Cdl     string `arg:"--cdl" help:"FCEUX or Mesen code/data log.  Draws a color coded usage map instead of the raw bits"`
 }
 
 func main() {
@@ -43,9 +46,9 @@ func main() {
 }
 
 func Run(args *Options) error {
-	raw, err := os.ReadFile(args.Input)
+	rom, err := ines.LoadRom(args.Input)
 	if err != nil {
-		return fmt.Errorf("unable te read input: %w", err)
+		return fmt.Errorf("unable to read input: %w", err)
 	}
 
 	if args.Output == "" {
@@ -53,52 +56,59 @@ func Run(args *Options) error {
 		args.Output = filepath.Base(args.Input[:len(args.Input)-len(ext)]) + ".png"
 	}
 
-	header, err := ines.ParseHeader(raw)
+	prg := rom.PrgRom()
+	chr := rom.ChrRom()
+
+	fmt.Printf("prg:%d(%d) chr:%d(%d)\n", len(prg), rom.PrgSize(), len(chr), rom.ChrSize())
+	fmt.Printf("prg:$%06X chr:$%06X\n", len(prg), len(chr))
+
+	// One column per PRG bank.  Unknown mappers get 16k columns.
+	bankSize := 1024 * 16
+	layout, err := mapper.FromRom(rom)
 	if err != nil {
-		return fmt.Errorf("error parsing header: %w", err)
+		fmt.Println(err)
+	} else {
+		bankSize = int(layout.PrgBankSize())
+		fmt.Printf("mapper:%d %s\n", layout.Number, layout.Name)
 	}
 
-	prg := raw[16:header.PrgSize]
-	chr := raw[16+header.PrgSize : 16+header.PrgSize+header.ChrSize]
-
-	fmt.Printf("prg:%d(%d) chr:%d(%d)\n", len(prg), header.PrgSize, len(chr), header.ChrSize)
-	fmt.Printf("prg:$%06X chr:$%06X\n", len(prg), len(chr))
-	slices := len(prg) / (1024 * 16)
+	slices := (len(prg) + (bankSize - 1)) / bankSize
 	fmt.Printf("slices:%d\n", slices)
 
-	pal := color.Palette{
-		color.White,
-		color.Black,
+	if layout != nil && args.Cdl == "" {
+		for i := 0; i < slices; i++ {
+			addrs := []string{}
+			for _, loc := range layout.PrgLocations(uint(i * bankSize)) {
+				addrs = append(addrs, fmt.Sprintf("$%04X", loc.Address))
+			}
+			fmt.Printf("bank %02X: $%06X %s\n", i, i*bankSize, strings.Join(addrs, " "))
+		}
 	}
 
-	images := []*image.Paletted{}
-	for i := 0; i <= slices; i++ {
-		start := i * 1024 * 16
-		img, err := GetChunkImage(prg[start:start+(1024*16)], pal)
+	var finalimg *image.Paletted
+	if args.Cdl != "" {
+		cdl, err := ines.LoadCdl(args.Cdl, uint(len(prg)), uint(len(chr)))
 		if err != nil {
-			return err
+			return fmt.Errorf("unable to read CDL: %w", err)
 		}
+		fmt.Printf("cdl:%s\n", cdl.Format)
 
-		images = append(images, img)
+		PrintSummary(cdl, layout, bankSize)
+		finalimg = GetUsageImage(cdl, bankSize)
+	} else {
+		finalimg, err = GetBitsImage(prg, bankSize)
+		if err != nil {
+			return err
+		}
 	}
-
-	finalimg := image.NewPaletted(image.Rect(0, 0, 16*8*len(images), 1024), pal)
 	fmt.Printf("finalimg: %#v\n", finalimg.Bounds())
-	for i := 0; i < len(images); i++ {
-		draw.Draw(
-			finalimg,
-			image.Rect(i*128, 0, (i*128)+(128), 1024),
-			images[i],
-			image.Pt(0, 0),
-			draw.Over)
-	}
 
 	err = WriteImage(finalimg, args.Output)
 	if err != nil {
 		return err
 	}
 
-	if header.ChrSize == 0 {
+	if len(chr) == 0 {
 		return nil
 	}
 
@@ -127,7 +137,7 @@ func Run(args *Options) error {
 		}
 	default:
 		chunk := args.ChrSize * 1024
-		count := int(header.ChrSize) / chunk
+		count := len(chr) / chunk
 		tilesPer := chunk / 16
 
 		//fmt.Printf("header.ChrSize:$%06X %d\nargs.ChrSize:%d\nchunk:%04X %d\ncount:%d\ntilesPer:%d\n",
@@ -162,6 +172,281 @@ func Run(args *Options) error {
 	return nil
 }
 
+// GetBitsImage draws one pixel per bit of PRG with one column per bank.
+func GetBitsImage(prg []byte, bankSize int) (*image.Paletted, error) {
+	pal := color.Palette{
+		color.White,
+		color.Black,
+	}
+
+	slices := (len(prg) + (bankSize - 1)) / bankSize
+	images := []*image.Paletted{}
+	for i := 0; i < slices; i++ {
+		start := i * bankSize
+		end := start + bankSize
+		if end > len(prg) {
+			end = len(prg)
+		}
+
+		img, err := GetChunkImage(prg[start:end], pal)
+		if err != nil {
+			return nil, err
+		}
+
+		images = append(images, img)
+	}
+
+	height := bankSize / 16
+	finalimg := image.NewPaletted(image.Rect(0, 0, 16*8*len(images), height), pal)
+	for i := 0; i < len(images); i++ {
+		draw.Draw(
+			finalimg,
+			image.Rect(i*128, 0, (i*128)+(128), height),
+			images[i],
+			image.Pt(0, 0),
+			draw.Over)
+	}
+
+	return finalimg, nil
+}
+
+// Palette indexes for the usage map.
+const (
+	PI_BACKGROUND uint8 = iota
+	PI_UNUSED
+	PI_CODE
+	PI_DATA
+	PI_AUDIO
+	PI_DRAWN
+	PI_READ
+	PI_TEXT
+)
+
+var usagePalette = color.Palette{
+	color.RGBA{0x30, 0x30, 0x30, 0xFF}, // background
+	color.RGBA{0x00, 0x00, 0x00, 0xFF}, // unused
+	color.RGBA{0xE0, 0x40, 0x40, 0xFF}, // code
+	color.RGBA{0x40, 0x80, 0xE0, 0xFF}, // data
+	color.RGBA{0x40, 0xC0, 0x40, 0xFF}, // audio
+	color.RGBA{0xE0, 0xC0, 0x40, 0xFF}, // CHR drawn
+	color.RGBA{0xA0, 0x60, 0xE0, 0xFF}, // CHR read
+	color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}, // text
+}
+
+var legend = []struct {
+	index uint8
+	label string
+}{
+	{PI_CODE, "CODE"},
+	{PI_DATA, "DATA"},
+	{PI_AUDIO, "PCM AUDIO"},
+	{PI_DRAWN, "CHR DRAWN"},
+	{PI_READ, "CHR READ"},
+	{PI_UNUSED, "UNUSED"},
+}
+
+// CHR columns in the usage map are always 8k.
+const chrColumnSize = 8 * 1024
+
+const (
+	margin      = 4
+	columnWidth = 128 // 16 bytes, 8 pixels each
+	labelHeight = (fontHeight + 2) * fontScale
+)
+
+// GetUsageImage draws the CDL flags with one color per usage.  PRG banks and
+// 8k of CHR are drawn in columns, each with its number and the percentage
+// used above it.  A legend is drawn at the bottom.
+func GetUsageImage(cdl *ines.Cdl, bankSize int) *image.Paletted {
+	type column struct {
+		label string
+		flags []byte
+		chr   bool
+	}
+
+	columns := []column{}
+	tallest := 0
+	for i := 0; i*bankSize < len(cdl.Prg); i++ {
+		flags := cdl.Prg[i*bankSize : min(len(cdl.Prg), (i+1)*bankSize)]
+		stats := ines.PrgStats(flags)
+		columns = append(columns, column{fmt.Sprintf("%02X %.0f%%", i, stats.Percent(stats.Used())), flags, false})
+		tallest = max(tallest, bankSize/16)
+	}
+
+	for i := 0; i*chrColumnSize < len(cdl.Chr); i++ {
+		flags := cdl.Chr[i*chrColumnSize : min(len(cdl.Chr), (i+1)*chrColumnSize)]
+		stats := ines.ChrStats(flags)
+		columns = append(columns, column{fmt.Sprintf("C%02X %.0f%%", i, stats.Percent(stats.Used())), flags, true})
+		tallest = max(tallest, chrColumnSize/16)
+	}
+
+	legendTop := margin + labelHeight + tallest + margin*2
+	width := margin + len(columns)*(columnWidth+margin)
+	height := legendTop + len(legend)*labelHeight + margin
+
+	// Make room for the legend on small ROMs
+	width = max(width, margin*2+columnWidth)
+
+	img := image.NewPaletted(image.Rect(0, 0, width, height), usagePalette)
+	draw.Draw(img, img.Bounds(), image.NewUniform(usagePalette[PI_BACKGROUND]), image.Point{}, draw.Src)
+
+	for c, col := range columns {
+		left := margin + c*(columnWidth+margin)
+		top := margin + labelHeight
+		drawText(img, left, margin, col.label)
+
+		for i, f := range col.flags {
+			idx := PI_UNUSED
+			if col.chr {
+				switch ines.ChrUsage(f) {
+				case ines.UC_DRAWN:
+					idx = PI_DRAWN
+				case ines.UC_READ:
+					idx = PI_READ
+				}
+			} else {
+				switch ines.PrgUsage(f) {
+				case ines.UP_CODE:
+					idx = PI_CODE
+				case ines.UP_DATA:
+					idx = PI_DATA
+				case ines.UP_AUDIO:
+					idx = PI_AUDIO
+				}
+			}
+
+			x := left + (i%16)*8
+			y := top + i/16
+			for px := 0; px < 8; px++ {
+				img.SetColorIndex(x+px, y, idx)
+			}
+		}
+	}
+
+	for i, l := range legend {
+		top := legendTop + i*labelHeight
+		swatch := image.Rect(margin, top, margin+fontHeight*fontScale, top+fontHeight*fontScale)
+		draw.Draw(img, swatch, image.NewUniform(usagePalette[l.index]), image.Point{}, draw.Src)
+		drawText(img, margin+(fontHeight+2)*fontScale, top, l.label)
+	}
+
+	return img
+}
+
+// PrintSummary prints the usage and free space for each PRG bank and 8k of
+// CHR.
+func PrintSummary(cdl *ines.Cdl, layout *mapper.Layout, bankSize int) {
+	fmt.Println("")
+	total := ines.PrgStats(cdl.Prg)
+	fmt.Printf("PRG: code %.1f%% data %.1f%% audio %.1f%% free %.1f%% (%d bytes)\n",
+		total.Percent(total.Code), total.Percent(total.Data), total.Percent(total.Audio),
+		total.Percent(total.Unused), total.Unused)
+
+	for i := 0; i*bankSize < len(cdl.Prg); i++ {
+		start := i * bankSize
+		flags := cdl.Prg[start:min(len(cdl.Prg), start+bankSize)]
+		stats := ines.PrgStats(flags)
+
+		addr := ""
+		if layout != nil {
+			if locs := layout.PrgLocations(uint(start)); len(locs) > 0 {
+				addr = fmt.Sprintf(" $%04X", locs[0].Address)
+			}
+		}
+
+		fmt.Printf("  bank %02X $%06X%s: code %5.1f%% data %5.1f%% audio %5.1f%% free %5.1f%% (%d bytes)",
+			i, start, addr,
+			stats.Percent(stats.Code), stats.Percent(stats.Data), stats.Percent(stats.Audio),
+			stats.Percent(stats.Unused), stats.Unused)
+
+		if runStart, runLen := ines.LargestUnused(flags); runLen > 0 {
+			runAddr := fmt.Sprintf("$%06X", start+runStart)
+			if addr != "" {
+				runAddr = fmt.Sprintf("$%04X", layout.PrgLocations(uint(start + runStart))[0].Address)
+			}
+			fmt.Printf(", largest %d at %s", runLen, runAddr)
+		}
+		fmt.Println("")
+	}
+
+	if len(cdl.Chr) == 0 {
+		return
+	}
+
+	total = ines.ChrStats(cdl.Chr)
+	fmt.Printf("CHR: drawn %.1f%% read %.1f%% free %.1f%% (%d bytes)\n",
+		total.Percent(total.Drawn), total.Percent(total.Read), total.Percent(total.Unused), total.Unused)
+
+	for i := 0; i*chrColumnSize < len(cdl.Chr); i++ {
+		start := i * chrColumnSize
+		stats := ines.ChrStats(cdl.Chr[start:min(len(cdl.Chr), start+chrColumnSize)])
+		fmt.Printf("  chr %02X $%06X: drawn %5.1f%% read %5.1f%% free %5.1f%% (%d bytes)\n",
+			i, start, stats.Percent(stats.Drawn), stats.Percent(stats.Read), stats.Percent(stats.Unused), stats.Unused)
+	}
+}
+
+const (
+	fontWidth  = 3
+	fontHeight = 5
+	fontScale  = 2
+)
+
+// A tiny 3x5 font for the usage map labels.  Each glyph is five rows of three
+// pixels.
+var font = map[rune]string{
+	'0': "111101101101111", '1': "010110010010111", '2': "111001111100111",
+	'3': "111001111001111", '4': "101101111001001", '5': "111100111001111",
+	'6': "111100111101111", '7': "111001001001001", '8': "111101111101111",
+	'9': "111101111001111", 'A': "010101111101101", 'B': "110101110101110",
+	'C': "011100100100011", 'D': "110101101101110", 'E': "111100110100111",
+	'F': "111100110100100", 'G': "011100101101011", 'H': "101101111101101",
+	'I': "111010010010111", 'J': "001001001101010", 'K': "101101110101101",
+	'L': "100100100100111", 'M': "101111111101101", 'N': "110101101101101",
+	'O': "010101101101010", 'P': "110101110100100", 'Q': "010101101110011",
+	'R': "110101110101101", 'S': "011100010001110", 'T': "111010010010010",
+	'U': "101101101101111", 'V': "101101101101010", 'W': "101101111111101",
+	'X': "101101010101101", 'Y': "101101010010010", 'Z': "111001010100111",
+	'%': "101001010100101", '-': "000000111000000", '.': "000000000000010",
+	' ': "000000000000000",
+}
+
+func drawText(img *image.Paletted, x, y int, text string) {
+	for _, r := range strings.ToUpper(text) {
+		glyph, ok := font[r]
+		if !ok {
+			glyph = font[' ']
+		}
+
+		for i, px := range glyph {
+			if px != '1' {
+				continue
+			}
+			gx := x + (i%fontWidth)*fontScale
+			gy := y + (i/fontWidth)*fontScale
+			for sy := 0; sy < fontScale; sy++ {
+				for sx := 0; sx < fontScale; sx++ {
+					img.SetColorIndex(gx+sx, gy+sy, PI_TEXT)
+				}
+			}
+		}
+		x += (fontWidth + 1) * fontScale
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 func WriteImage(raw image.Image, filename string) error {
 	fmt.Println(filename)
 	outfile, err := os.Create(filename)
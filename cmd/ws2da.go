@@ -11,14 +11,19 @@ import (
 	"strings"
 
 	"github.com/alexflint/go-arg"
+	"github.com/zorchenhimer/go-nes/mapper"
 	"github.com/zorchenhimer/go-nes/mesen"
+	ines "github.com/zorchenhimer/go-nes/rom"
 )
 
 type Args struct {
 	Input     string `arg:"positional,required" help:"Mesen2 JSON workspace file"`
 	Output    string `arg:"positional" help:"Output config file [default: STDOUT]"`
 	PrgStart  string `arg:"--prg-start" default:"$8000" help:"Offset to add to PRG ROM address values"`
+	Rom       string `arg:"--rom" help:"ROM file used to map PRG ROM addresses with its mapper.  Overrides --prg-start"`
+	Bank      int    `arg:"--bank" default:"-1" help:"Only write PRG ROM labels in this bank.  Banks are the size of the mapper's smallest PRG window"`
 	prgoffset uint
+	layout    *mapper.Layout
 }
 
 func (a Args) Description() string {
@@ -38,6 +43,23 @@ func main() {
 		args.prgoffset = off
 	}
 
+	if args.Rom != "" {
+		rom, err := ines.LoadRom(args.Rom)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		args.layout, err = mapper.FromRom(rom)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else if args.Bank >= 0 {
+		fmt.Fprintln(os.Stderr, "--bank requires --rom")
+		os.Exit(1)
+	}
+
 	err := run(args)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -81,8 +103,12 @@ func run(args *Args) error {
 
 	for _, label := range ws.Labels {
 		addr := label.Address
-		if label.MemoryType == "NesPrgRom" {
-			addr += args.prgoffset
+		if label.MemoryType == mesen.NesPrgRom {
+			var ok bool
+			addr, ok = args.prgAddress(label.Address)
+			if !ok {
+				continue
+			}
 		}
 
 		items := []string{
@@ -105,6 +131,27 @@ func run(args *Args) error {
 	return nil
 }
 
+// prgAddress returns the CPU address for an offset into PRG ROM.  False is
+// returned if the label should be skipped.
+func (args *Args) prgAddress(offset uint) (uint, bool) {
+	if args.layout == nil {
+		return offset + args.prgoffset, true
+	}
+
+	if args.Bank >= 0 && offset/args.layout.PrgBankSize() != uint(args.Bank) {
+		return 0, false
+	}
+
+	locs := args.layout.PrgLocations(offset)
+	if len(locs) == 0 {
+		fmt.Fprintf(os.Stderr, "PRG offset $%06X is outside the ROM\n", offset)
+		return 0, false
+	}
+
+	// The most likely address is first
+	return uint(locs[0].Address), true
+}
+
 func readWorkspace(source string) (*mesen.Workspace, error) {
 	var input io.Reader
 
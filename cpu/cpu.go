@@ -0,0 +1,506 @@
+// Package cpu is a 6502 core for running ROM routines outside of an emulator,
+// mostly for tests.
+//
+// Each instruction runs in one step with the cycle count of real hardware,
+// including page crossings and taken branches.  Dummy reads and writes are not
+// done.  Decimal mode is ignored, same as the NES's 2A03.
+package cpu
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zorchenhimer/go-nes/disasm"
+)
+
+// Bus is the CPU address space.
+type Bus interface {
+	Read(address uint16) uint8
+	Write(address uint16, value uint8)
+}
+
+// Memory is a bus with 64k of RAM and nothing else.
+type Memory [0x10000]uint8
+
+func (m *Memory) Read(address uint16) uint8 {
+	return m[address]
+}
+
+func (m *Memory) Write(address uint16, value uint8) {
+	m[address] = value
+}
+
+// Status flags
+const (
+	FLAG_C uint8 = 1 << iota // Carry
+	FLAG_Z                   // Zero
+	FLAG_I                   // Interrupt disable
+	FLAG_D                   // Decimal
+	FLAG_B                   // Break.  Only exists on the stack.
+	FLAG_U                   // Unused.  Always set.
+	FLAG_V                   // Overflow
+	FLAG_N                   // Negative
+)
+
+// ErrCycleLimit is returned by Call() when the routine doesn't return in time.
+var ErrCycleLimit = errors.New("Cycle limit reached")
+
+// Call() returns when an RTS lands here with the stack back where it started.
+// This is the high byte of the IRQ vector, so it is never run as code.
+const callReturn uint16 = 0xFFFF
+
+// CPU is the 6502 state.  Registers can be set directly.
+type CPU struct {
+	A, X, Y uint8
+	S       uint8 // Stack pointer
+	P       uint8 // Status flags
+	PC      uint16
+
+	Cycles uint64
+	Bus    Bus
+
+	// Run the stable unofficial opcodes.  Unstable ones (ane, sha, etc) and
+	// jam are always an error.
+	Illegal bool
+}
+
+// New returns a CPU in its power on state.  PC is zero until Reset() is
+// called or it is set.
+func New(bus Bus) *CPU {
+	return &CPU{
+		S:   0xFD,
+		P:   FLAG_I | FLAG_U,
+		Bus: bus,
+	}
+}
+
+// Reset jumps to the reset vector.
+func (c *CPU) Reset() {
+	c.S -= 3
+	c.P |= FLAG_I
+	c.PC = c.read16(0xFFFC)
+	c.Cycles += 7
+}
+
+// Flag returns true if all the given flags are set.
+func (c *CPU) Flag(flag uint8) bool {
+	return c.P&flag == flag
+}
+
+// SetFlag sets or clears flags.
+func (c *CPU) SetFlag(flag uint8, set bool) {
+	if set {
+		c.P |= flag
+	} else {
+		c.P &^= flag
+	}
+}
+
+// Call runs the subroutine at address until it returns or maxCycles have
+// passed.  The cycles for a JSR are added as if the routine was called from
+// code, so Cycles goes up by the same amount as a JSR in a ROM would take.
+func (c *CPU) Call(address uint16, maxCycles uint64) error {
+	start := c.S
+	limit := c.Cycles + maxCycles
+
+	c.push16(callReturn - 1)
+	c.PC = address
+	c.Cycles += 6
+
+	for {
+		if c.PC == callReturn && c.S == start {
+			return nil
+		}
+
+		if c.Cycles >= limit {
+			return fmt.Errorf("%w at $%04X after %d cycles", ErrCycleLimit, c.PC, maxCycles)
+		}
+
+		if _, err := c.Step(); err != nil {
+			return err
+		}
+	}
+}
+
+// Run runs instructions until maxCycles have passed.
+func (c *CPU) Run(maxCycles uint64) error {
+	limit := c.Cycles + maxCycles
+	for c.Cycles < limit {
+		if _, err := c.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Step runs a single instruction and returns the number of cycles it took.
+func (c *CPU) Step() (int, error) {
+	start := c.Cycles
+	pc := c.PC
+	code := c.Bus.Read(pc)
+	op := disasm.Opcodes[code]
+
+	if op.Illegal && (!c.Illegal || !stable(op)) {
+		return 0, fmt.Errorf("Unsupported opcode $%02X (%s) at $%04X", code, op.Name, pc)
+	}
+
+	c.PC += uint16(op.Mode.Size())
+	addr, crossed := c.address(op.Mode, pc)
+	c.Cycles += uint64(op.Cycles)
+	if op.PageCycle && crossed && op.Mode != disasm.AM_REL {
+		c.Cycles++
+	}
+
+	acc := op.Mode == disasm.AM_ACC
+
+	switch op.Name {
+	// Loads and stores
+	case "lda":
+		c.A = c.setZN(c.Bus.Read(addr))
+	case "ldx":
+		c.X = c.setZN(c.Bus.Read(addr))
+	case "ldy":
+		c.Y = c.setZN(c.Bus.Read(addr))
+	case "lax":
+		c.A = c.setZN(c.Bus.Read(addr))
+		c.X = c.A
+	case "sta":
+		c.Bus.Write(addr, c.A)
+	case "stx":
+		c.Bus.Write(addr, c.X)
+	case "sty":
+		c.Bus.Write(addr, c.Y)
+	case "sax":
+		c.Bus.Write(addr, c.A&c.X)
+
+	// Transfers
+	case "tax":
+		c.X = c.setZN(c.A)
+	case "tay":
+		c.Y = c.setZN(c.A)
+	case "txa":
+		c.A = c.setZN(c.X)
+	case "tya":
+		c.A = c.setZN(c.Y)
+	case "tsx":
+		c.X = c.setZN(c.S)
+	case "txs":
+		c.S = c.X
+
+	// Stack
+	case "pha":
+		c.push(c.A)
+	case "php":
+		c.push(c.P | FLAG_B | FLAG_U)
+	case "pla":
+		c.A = c.setZN(c.pull())
+	case "plp":
+		c.P = c.pull()&^FLAG_B | FLAG_U
+
+	// Logic
+	case "and":
+		c.A = c.setZN(c.A & c.Bus.Read(addr))
+	case "ora":
+		c.A = c.setZN(c.A | c.Bus.Read(addr))
+	case "eor":
+		c.A = c.setZN(c.A ^ c.Bus.Read(addr))
+	case "bit":
+		val := c.Bus.Read(addr)
+		c.SetFlag(FLAG_Z, c.A&val == 0)
+		c.SetFlag(FLAG_V, val&0x40 != 0)
+		c.SetFlag(FLAG_N, val&0x80 != 0)
+
+	// Arithmetic
+	case "adc":
+		c.adc(c.Bus.Read(addr))
+	case "sbc":
+		c.adc(^c.Bus.Read(addr))
+	case "cmp":
+		c.compare(c.A, c.Bus.Read(addr))
+	case "cpx":
+		c.compare(c.X, c.Bus.Read(addr))
+	case "cpy":
+		c.compare(c.Y, c.Bus.Read(addr))
+	case "inc":
+		c.modify(addr, false, c.inc)
+	case "dec":
+		c.modify(addr, false, c.dec)
+	case "inx":
+		c.X = c.setZN(c.X + 1)
+	case "iny":
+		c.Y = c.setZN(c.Y + 1)
+	case "dex":
+		c.X = c.setZN(c.X - 1)
+	case "dey":
+		c.Y = c.setZN(c.Y - 1)
+
+	// Shifts
+	case "asl":
+		c.modify(addr, acc, c.asl)
+	case "lsr":
+		c.modify(addr, acc, c.lsr)
+	case "rol":
+		c.modify(addr, acc, c.rol)
+	case "ror":
+		c.modify(addr, acc, c.ror)
+
+	// Jumps and interrupts
+	case "jmp":
+		c.PC = addr
+	case "jsr":
+		c.push16(c.PC - 1)
+		c.PC = addr
+	case "rts":
+		c.PC = c.pull16() + 1
+	case "rti":
+		c.P = c.pull()&^FLAG_B | FLAG_U
+		c.PC = c.pull16()
+	case "brk":
+		c.push16(c.PC + 1)
+		c.push(c.P | FLAG_B | FLAG_U)
+		c.P |= FLAG_I
+		c.PC = c.read16(0xFFFE)
+
+	// Branches
+	case "bcc":
+		c.branch(!c.Flag(FLAG_C), addr)
+	case "bcs":
+		c.branch(c.Flag(FLAG_C), addr)
+	case "bne":
+		c.branch(!c.Flag(FLAG_Z), addr)
+	case "beq":
+		c.branch(c.Flag(FLAG_Z), addr)
+	case "bpl":
+		c.branch(!c.Flag(FLAG_N), addr)
+	case "bmi":
+		c.branch(c.Flag(FLAG_N), addr)
+	case "bvc":
+		c.branch(!c.Flag(FLAG_V), addr)
+	case "bvs":
+		c.branch(c.Flag(FLAG_V), addr)
+
+	// Flags
+	case "clc":
+		c.P &^= FLAG_C
+	case "sec":
+		c.P |= FLAG_C
+	case "cli":
+		c.P &^= FLAG_I
+	case "sei":
+		c.P |= FLAG_I
+	case "cld":
+		c.P &^= FLAG_D
+	case "sed":
+		c.P |= FLAG_D
+	case "clv":
+		c.P &^= FLAG_V
+
+	case "nop":
+
+	// Unofficial read-modify-write combos
+	case "slo":
+		c.A = c.setZN(c.A | c.modify(addr, false, c.asl))
+	case "rla":
+		c.A = c.setZN(c.A & c.modify(addr, false, c.rol))
+	case "sre":
+		c.A = c.setZN(c.A ^ c.modify(addr, false, c.lsr))
+	case "rra":
+		c.adc(c.modify(addr, false, c.ror))
+	case "dcp":
+		c.compare(c.A, c.modify(addr, false, c.dec))
+	case "isc":
+		c.adc(^c.modify(addr, false, c.inc))
+
+	// Unofficial immediates
+	case "anc":
+		c.A = c.setZN(c.A & c.Bus.Read(addr))
+		c.SetFlag(FLAG_C, c.A&0x80 != 0)
+	case "alr":
+		c.A = c.lsr(c.A & c.Bus.Read(addr))
+	case "arr":
+		c.A &= c.Bus.Read(addr)
+		c.A = c.setZN(c.A>>1 | c.P&FLAG_C<<7)
+		c.SetFlag(FLAG_C, c.A&0x40 != 0)
+		c.SetFlag(FLAG_V, (c.A>>6^c.A>>5)&1 != 0)
+	case "axs":
+		val := c.Bus.Read(addr)
+		ax := c.A & c.X
+		c.SetFlag(FLAG_C, ax >= val)
+		c.X = c.setZN(ax - val)
+
+	default:
+		return 0, fmt.Errorf("Unsupported opcode $%02X (%s) at $%04X", code, op.Name, pc)
+	}
+
+	return int(c.Cycles - start), nil
+}
+
+// stable returns true for unofficial opcodes that behave the same on every
+// console.
+func stable(op disasm.Opcode) bool {
+	switch op.Name {
+	case "jam", "ane", "sha", "shx", "shy", "tas", "las":
+		return false
+	case "lax":
+		return op.Mode != disasm.AM_IMM
+	}
+	return true
+}
+
+// address returns the effective address for an instruction at pc.  crossed is
+// true if indexing crossed a page.
+func (c *CPU) address(mode disasm.Mode, pc uint16) (addr uint16, crossed bool) {
+	switch mode {
+	case disasm.AM_IMP, disasm.AM_ACC:
+		return 0, false
+
+	case disasm.AM_IMM:
+		return pc + 1, false
+
+	case disasm.AM_ZP:
+		return uint16(c.Bus.Read(pc + 1)), false
+
+	case disasm.AM_ZPX:
+		return uint16(c.Bus.Read(pc+1) + c.X), false
+
+	case disasm.AM_ZPY:
+		return uint16(c.Bus.Read(pc+1) + c.Y), false
+
+	case disasm.AM_ABS:
+		return c.read16(pc + 1), false
+
+	case disasm.AM_ABX:
+		base := c.read16(pc + 1)
+		addr = base + uint16(c.X)
+		return addr, base&0xFF00 != addr&0xFF00
+
+	case disasm.AM_ABY:
+		base := c.read16(pc + 1)
+		addr = base + uint16(c.Y)
+		return addr, base&0xFF00 != addr&0xFF00
+
+	case disasm.AM_IND:
+		// The high byte doesn't carry into the next page.
+		ptr := c.read16(pc + 1)
+		lo := c.Bus.Read(ptr)
+		hi := c.Bus.Read(ptr&0xFF00 | uint16(uint8(ptr)+1))
+		return uint16(lo) | uint16(hi)<<8, false
+
+	case disasm.AM_IZX:
+		return c.readZp16(c.Bus.Read(pc+1) + c.X), false
+
+	case disasm.AM_IZY:
+		base := c.readZp16(c.Bus.Read(pc + 1))
+		addr = base + uint16(c.Y)
+		return addr, base&0xFF00 != addr&0xFF00
+
+	case disasm.AM_REL:
+		return uint16(int(pc) + 2 + int(int8(c.Bus.Read(pc+1)))), false
+	}
+
+	return 0, false
+}
+
+func (c *CPU) read16(address uint16) uint16 {
+	return uint16(c.Bus.Read(address)) | uint16(c.Bus.Read(address+1))<<8
+}
+
+// readZp16 reads a pointer from zero page, wrapping around at $FF.
+func (c *CPU) readZp16(address uint8) uint16 {
+	return uint16(c.Bus.Read(uint16(address))) | uint16(c.Bus.Read(uint16(address+1)))<<8
+}
+
+func (c *CPU) push(value uint8) {
+	c.Bus.Write(0x100|uint16(c.S), value)
+	c.S--
+}
+
+func (c *CPU) push16(value uint16) {
+	c.push(uint8(value >> 8))
+	c.push(uint8(value))
+}
+
+func (c *CPU) pull() uint8 {
+	c.S++
+	return c.Bus.Read(0x100 | uint16(c.S))
+}
+
+func (c *CPU) pull16() uint16 {
+	lo := c.pull()
+	return uint16(lo) | uint16(c.pull())<<8
+}
+
+func (c *CPU) setZN(value uint8) uint8 {
+	c.SetFlag(FLAG_Z, value == 0)
+	c.SetFlag(FLAG_N, value&0x80 != 0)
+	return value
+}
+
+func (c *CPU) adc(value uint8) {
+	sum := uint16(c.A) + uint16(value) + uint16(c.P&FLAG_C)
+	result := uint8(sum)
+	c.SetFlag(FLAG_C, sum > 0xFF)
+	c.SetFlag(FLAG_V, (c.A^result)&(value^result)&0x80 != 0)
+	c.A = c.setZN(result)
+}
+
+func (c *CPU) compare(reg, value uint8) {
+	c.SetFlag(FLAG_C, reg >= value)
+	c.setZN(reg - value)
+}
+
+func (c *CPU) branch(taken bool, target uint16) {
+	if !taken {
+		return
+	}
+
+	c.Cycles++
+	if c.PC&0xFF00 != target&0xFF00 {
+		c.Cycles++
+	}
+	c.PC = target
+}
+
+// modify runs f on the accumulator or on memory and returns the result.
+func (c *CPU) modify(addr uint16, acc bool, f func(uint8) uint8) uint8 {
+	if acc {
+		c.A = f(c.A)
+		return c.A
+	}
+
+	val := f(c.Bus.Read(addr))
+	c.Bus.Write(addr, val)
+	return val
+}
+
+// These are used with modify() for the accumulator or memory versions.
+
+func (c *CPU) inc(v uint8) uint8 {
+	return c.setZN(v + 1)
+}
+
+func (c *CPU) dec(v uint8) uint8 {
+	return c.setZN(v - 1)
+}
+
+func (c *CPU) asl(v uint8) uint8 {
+	c.SetFlag(FLAG_C, v&0x80 != 0)
+	return c.setZN(v << 1)
+}
+
+func (c *CPU) lsr(v uint8) uint8 {
+	c.SetFlag(FLAG_C, v&0x01 != 0)
+	return c.setZN(v >> 1)
+}
+
+func (c *CPU) rol(v uint8) uint8 {
+	carry := c.P & FLAG_C
+	c.SetFlag(FLAG_C, v&0x80 != 0)
+	return c.setZN(v<<1 | carry)
+}
+
+func (c *CPU) ror(v uint8) uint8 {
+	carry := c.P & FLAG_C
+	c.SetFlag(FLAG_C, v&0x01 != 0)
+	return c.setZN(v>>1 | carry<<7)
+}
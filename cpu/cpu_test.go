@@ -0,0 +1,204 @@
+package cpu
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zorchenhimer/go-nes/asm"
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+func load(t *testing.T, src string) *Memory {
+	t.Helper()
+	prog, err := asm.Assemble([]byte(src), "test.s", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem := &Memory{}
+	for _, chunk := range prog.Chunks {
+		copy(mem[chunk.Address:], chunk.Data)
+	}
+	return mem
+}
+
+func TestMultiply(t *testing.T) {
+	// $00 * $01, result in A (high) and $02 (low)
+	mem := load(t, `
+.org $8000
+	lda #0
+	ldx #8
+@loop:
+	lsr $01
+	bcc :+
+	clc
+	adc $00
+:	ror a
+	ror $02
+	dex
+	bne @loop
+	rts
+`)
+
+	tests := [][2]uint8{{0, 0}, {1, 1}, {12, 34}, {255, 255}, {200, 3}}
+	for _, tt := range tests {
+		c := New(mem)
+		mem[0x00], mem[0x01] = tt[0], tt[1]
+		if err := c.Call(0x8000, 1000); err != nil {
+			t.Fatal(err)
+		}
+
+		want := uint16(tt[0]) * uint16(tt[1])
+		got := uint16(c.A)<<8 | uint16(mem[0x02])
+		if got != want {
+			t.Errorf("%d * %d: expected $%04X, got $%04X", tt[0], tt[1], want, got)
+		}
+	}
+}
+
+func TestCycles(t *testing.T) {
+	mem := load(t, `
+.org $8000
+	ldx #$10        ; 2
+	lda $1234,x     ; 4
+	lda $12F8,x     ; 5, page crossed
+	sta $0200,x     ; 5
+	beq :+          ; 3, taken
+:	bne :+          ; 2, not taken
+:	rts             ; 6
+Forever:
+	jmp Forever
+`)
+
+	c := New(mem)
+	if err := c.Call(0x8000, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plus 6 for the JSR
+	if c.Cycles != 33 {
+		t.Errorf("Expected 33 cycles, got %d", c.Cycles)
+	}
+
+	err := c.Call(0x8010, 100)
+	if !errors.Is(err, ErrCycleLimit) {
+		t.Errorf("Expected a cycle limit error, got %v", err)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	mem := &Memory{}
+	c := New(mem)
+
+	// sed, clc, lda #$7F, adc #$01.  Decimal mode is ignored.
+	copy(mem[0x8000:], []byte{0xF8, 0x18, 0xA9, 0x7F, 0x69, 0x01})
+	c.PC = 0x8000
+	for i := 0; i < 4; i++ {
+		if _, err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if c.A != 0x80 || !c.Flag(FLAG_V|FLAG_N) || c.Flag(FLAG_C) || c.Flag(FLAG_Z) {
+		t.Errorf("Bad result: A=$%02X P=%08b", c.A, c.P)
+	}
+
+	// lax $10 is only run with Illegal set
+	copy(mem[0x9000:], []byte{0xA7, 0x10})
+	mem[0x10] = 0x55
+	c.PC = 0x9000
+	if _, err := c.Step(); err == nil {
+		t.Errorf("Unofficial opcode ran without Illegal")
+	}
+
+	c.Illegal = true
+	if _, err := c.Step(); err != nil || c.A != 0x55 || c.X != 0x55 {
+		t.Errorf("lax failed: A=$%02X X=$%02X %v", c.A, c.X, err)
+	}
+}
+
+// testRom returns a ROM where the first byte of every 8k bank is the bank
+// number.
+func testRom(mapper uint, prgSize uint) *rom.NesRom {
+	prg := make([]byte, prgSize)
+	for i := 0; i < len(prg); i += 0x2000 {
+		prg[i] = byte(i / 0x2000)
+	}
+	return &rom.NesRom{
+		Header: &rom.Header{Mapper: mapper, PrgSize: prgSize},
+		Prgrom: prg,
+	}
+}
+
+func checkBanks(t *testing.T, name string, b *NesBus, want [4]uint8) {
+	t.Helper()
+	for i, addr := range []uint16{0x8000, 0xA000, 0xC000, 0xE000} {
+		if got := b.Read(addr); got != want[i] {
+			t.Errorf("%s: expected bank %d at $%04X, got %d", name, want[i], addr, got)
+		}
+	}
+}
+
+func TestNesBus(t *testing.T) {
+	b, err := NewNesBus(testRom(0, 0x4000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBanks(t, "NROM-128", b, [4]uint8{0, 1, 0, 1})
+
+	b, _ = NewNesBus(testRom(2, 0x20000))
+	checkBanks(t, "UxROM", b, [4]uint8{0, 1, 14, 15})
+	b.Write(0x8000, 3)
+	checkBanks(t, "UxROM", b, [4]uint8{6, 7, 14, 15})
+
+	b, _ = NewNesBus(testRom(1, 0x20000))
+	checkBanks(t, "MMC1", b, [4]uint8{0, 1, 14, 15})
+	for i := 0; i < 5; i++ {
+		b.Write(0xE000, 2>>i)
+	}
+	checkBanks(t, "MMC1", b, [4]uint8{4, 5, 14, 15})
+
+	b, _ = NewNesBus(testRom(4, 0x40000))
+	checkBanks(t, "MMC3", b, [4]uint8{0, 1, 30, 31})
+	b.Write(0x8000, 6)
+	b.Write(0x8001, 5)
+	checkBanks(t, "MMC3", b, [4]uint8{5, 1, 30, 31})
+	b.Write(0x8000, 0x46)
+	checkBanks(t, "MMC3", b, [4]uint8{30, 1, 5, 31})
+
+	if _, err := NewNesBus(testRom(5, 0x8000)); err == nil {
+		t.Errorf("Expected an error for an unsupported mapper")
+	}
+}
+
+func TestNesBusCall(t *testing.T) {
+	r := testRom(2, 0x10000)
+	prog, err := asm.Assemble([]byte(`
+.org $C000
+	lda #1          ; switch to bank 1
+	sta $8000
+	lda $8000
+	sta $0300
+	rts
+`), "test.s", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = prog.WriteRom(r, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := NewNesBus(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(b)
+	if err = c.Call(0xC000, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Ram[0x300] != 2 {
+		t.Errorf("Expected bank 2 to be read, got %d", b.Ram[0x300])
+	}
+}
@@ -0,0 +1,180 @@
+package cpu
+
+import (
+	"fmt"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// NesBus maps a ROM's PRG into the CPU address space with NROM, UxROM, MMC1,
+// or MMC3 banking.  RAM and PRG RAM are always there.  Reads from the PPU, APU,
+// and I/O registers return zero and writes to them are ignored.
+type NesBus struct {
+	Ram    [0x800]uint8
+	PrgRam [0x2000]uint8 // $6000-$7FFF
+
+	prg    []byte
+	mapper uint
+	banks  [4]int // PRG offsets for $8000, $A000, $C000, and $E000
+
+	// UxROM bank, MMC1 registers, or MMC3 bank registers
+	regs       [8]uint8
+	shift      uint8 // MMC1 shift register
+	shifts     uint8 // MMC1 writes to the shift register so far
+	bankSelect uint8 // MMC3 bank select
+}
+
+// NewNesBus returns a bus for the ROM in its power on state.  The trainer, if
+// present, is loaded at $7000.
+func NewNesBus(r *rom.NesRom) (*NesBus, error) {
+	switch r.MapperNumber() {
+	case 0, 1, 2, 4:
+	default:
+		return nil, fmt.Errorf("Mapper %d is not supported", r.MapperNumber())
+	}
+
+	if len(r.Prgrom) == 0 || len(r.Prgrom)%0x2000 != 0 {
+		return nil, fmt.Errorf("PRG size is not a multiple of 8k: $%X", len(r.Prgrom))
+	}
+
+	b := &NesBus{
+		prg:    r.Prgrom,
+		mapper: r.MapperNumber(),
+	}
+	copy(b.PrgRam[0x1000:], r.Trainer)
+
+	switch b.mapper {
+	case 1:
+		b.regs[0] = 0x0C // PRG mode 3, last bank fixed at $C000
+	case 4:
+		b.regs[7] = 1
+	}
+
+	b.update()
+	return b, nil
+}
+
+func (b *NesBus) Read(address uint16) uint8 {
+	switch {
+	case address < 0x2000:
+		return b.Ram[address&0x7FF]
+	case address >= 0x8000:
+		window := (address - 0x8000) >> 13
+		return b.prg[b.banks[window]+int(address&0x1FFF)]
+	case address >= 0x6000:
+		return b.PrgRam[address-0x6000]
+	}
+	return 0
+}
+
+func (b *NesBus) Write(address uint16, value uint8) {
+	switch {
+	case address < 0x2000:
+		b.Ram[address&0x7FF] = value
+	case address >= 0x8000:
+		b.writeMapper(address, value)
+	case address >= 0x6000:
+		b.PrgRam[address-0x6000] = value
+	}
+}
+
+// PrgOffset returns the PRG ROM offset currently mapped at a CPU address.
+// False is returned for addresses below $8000.
+func (b *NesBus) PrgOffset(address uint16) (int, bool) {
+	if address < 0x8000 {
+		return 0, false
+	}
+	return b.banks[(address-0x8000)>>13] + int(address&0x1FFF), true
+}
+
+func (b *NesBus) writeMapper(address uint16, value uint8) {
+	switch b.mapper {
+	case 2:
+		b.regs[0] = value
+
+	case 1:
+		if value&0x80 != 0 {
+			b.shift, b.shifts = 0, 0
+			b.regs[0] |= 0x0C
+			break
+		}
+
+		b.shift |= (value & 1) << b.shifts
+		b.shifts++
+		if b.shifts < 5 {
+			return
+		}
+
+		b.regs[(address>>13)&3] = b.shift
+		b.shift, b.shifts = 0, 0
+
+	case 4:
+		switch address & 0xE001 {
+		case 0x8000:
+			b.bankSelect = value
+		case 0x8001:
+			b.regs[b.bankSelect&7] = value
+		}
+		// Mirroring, PRG RAM protect, and IRQs don't matter here.
+	}
+
+	b.update()
+}
+
+// update recalculates the PRG bank offsets from the mapper registers.
+func (b *NesBus) update() {
+	const bank8 = 0x2000
+	count8 := len(b.prg) / bank8
+	count16 := (count8 + 1) / 2
+
+	// bank16 returns the offsets for both halves of a 16k bank.  ROMs smaller
+	// than 16k are mirrored.
+	bank16 := func(bank int) (int, int) {
+		bank %= count16
+		lo := (bank * 2) % count8
+		hi := (bank*2 + 1) % count8
+		return lo * bank8, hi * bank8
+	}
+
+	switch b.mapper {
+	case 0:
+		for i := range b.banks {
+			b.banks[i] = (i % count8) * bank8
+		}
+
+	case 2:
+		b.banks[0], b.banks[1] = bank16(int(b.regs[0]))
+		b.banks[2], b.banks[3] = bank16(count16 - 1)
+
+	case 1:
+		// 512k boards use a CHR register bit to pick the 256k half.
+		bank := int(b.regs[3] & 0x0F)
+		outer := 0
+		if count16 > 16 {
+			outer = int(b.regs[1] & 0x10)
+		}
+
+		switch (b.regs[0] >> 2) & 3 {
+		case 0, 1:
+			b.banks[0], b.banks[1] = bank16(outer | bank&^1)
+			b.banks[2], b.banks[3] = bank16(outer | bank | 1)
+		case 2:
+			b.banks[0], b.banks[1] = bank16(outer)
+			b.banks[2], b.banks[3] = bank16(outer | bank)
+		case 3:
+			b.banks[0], b.banks[1] = bank16(outer | bank)
+			b.banks[2], b.banks[3] = bank16(outer | 0x0F)
+		}
+
+	case 4:
+		r6 := int(b.regs[6]) % count8
+		r7 := int(b.regs[7]) % count8
+		second := (count8 - 2 + count8) % count8
+
+		if b.bankSelect&0x40 == 0 {
+			b.banks = [4]int{r6 * bank8, r7 * bank8, second * bank8, (count8 - 1) * bank8}
+		} else {
+			b.banks = [4]int{second * bank8, r7 * bank8, r6 * bank8, (count8 - 1) * bank8}
+		}
+	}
+}
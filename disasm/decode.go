@@ -0,0 +1,97 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Instruction is a single decoded instruction.
+type Instruction struct {
+	Address uint16
+	Opcode  Opcode
+	Bytes   []byte
+
+	// Operand value.  For branches this is the target address, not the
+	// offset.
+	Operand uint16
+}
+
+// Decode decodes the instruction at the start of data.  An error is returned
+// if data is too short.
+func Decode(data []byte, address uint16) (Instruction, error) {
+	if len(data) == 0 {
+		return Instruction{}, fmt.Errorf("No data at $%04X", address)
+	}
+
+	op := Opcodes[data[0]]
+	size := op.Mode.Size()
+	if len(data) < size {
+		return Instruction{}, fmt.Errorf("Instruction at $%04X runs past the end of the data", address)
+	}
+
+	ins := Instruction{
+		Address: address,
+		Opcode:  op,
+		Bytes:   data[:size],
+	}
+
+	switch size {
+	case 2:
+		ins.Operand = uint16(data[1])
+	case 3:
+		ins.Operand = uint16(data[1]) | uint16(data[2])<<8
+	}
+
+	if op.Mode == AM_REL {
+		ins.Operand = uint16(int(address) + 2 + int(int8(data[1])))
+	}
+
+	return ins, nil
+}
+
+// Size returns the length of the instruction in bytes.
+func (i Instruction) Size() int {
+	return len(i.Bytes)
+}
+
+// Target returns the address the operand points to.  False is returned for
+// modes without an address (implied, immediate, etc).
+func (i Instruction) Target() (uint16, bool) {
+	switch i.Opcode.Mode {
+	case AM_IMP, AM_ACC, AM_IMM:
+		return 0, false
+	}
+	return i.Operand, true
+}
+
+// IsBranch returns true for conditional branches.
+func (i Instruction) IsBranch() bool {
+	return i.Opcode.Mode == AM_REL
+}
+
+// IsJump returns true for JMP and JSR.
+func (i Instruction) IsJump() bool {
+	return i.Opcode.Name == "jmp" || i.Opcode.Name == "jsr"
+}
+
+// Ends returns true if execution doesn't continue with the next instruction.
+func (i Instruction) Ends() bool {
+	switch i.Opcode.Name {
+	case "jmp", "rts", "rti", "brk", "jam":
+		return true
+	}
+	return false
+}
+
+// OperandString formats the operand as a hex number.
+func (i Instruction) OperandString() string {
+	switch i.Opcode.Mode {
+	case AM_IMM, AM_ZP, AM_ZPX, AM_ZPY, AM_IZX, AM_IZY:
+		return fmt.Sprintf("$%02X", i.Operand)
+	}
+	return fmt.Sprintf("$%04X", i.Operand)
+}
+
+func (i Instruction) String() string {
+	return strings.ToUpper(strings.TrimSpace(i.Opcode.Name + " " + i.Opcode.Mode.Format(i.OperandString())))
+}
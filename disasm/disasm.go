@@ -0,0 +1,515 @@
+// Package disasm is a 6502 disassembler that writes ca65 source.
+//
+// Code is found by tracing from entry points (the vectors, labels, or code
+// bytes in a CDL file).  Everything that isn't traced is written as data, so
+// the output always assembles back to the same bytes.
+package disasm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// Disassembler disassembles a single bank of PRG mapped at Base.
+type Disassembler struct {
+	Data []byte
+	Base uint16
+
+	// Decode unofficial opcodes while tracing.  They are written with .byte
+	// so the output assembles without .setcpu "6502X".
+	Illegal bool
+
+	// Optional CDL flags for Data using the rom.UP_* values.  Bytes that are
+	// only marked as data are never traced as code.
+	Flags []byte
+
+	// Segment is written before the source if it isn't empty.
+	Segment string
+
+	labels map[uint16]*label
+	starts []bool // first byte of an instruction
+	code   []bool // any byte of an instruction
+	words  map[uint16]bool
+}
+
+type label struct {
+	name    string
+	comment string
+}
+
+// New returns a disassembler for data mapped at base.
+func New(data []byte, base uint16) *Disassembler {
+	return &Disassembler{
+		Data:   data,
+		Base:   base,
+		labels: make(map[uint16]*label),
+		starts: make([]bool, len(data)),
+		code:   make([]bool, len(data)),
+		words:  make(map[uint16]bool),
+	}
+}
+
+// Contains returns true if the address is inside the bank.
+func (d *Disassembler) Contains(address uint16) bool {
+	return address >= d.Base && int(address)-int(d.Base) < len(d.Data)
+}
+
+// SetLabel adds a label and comment at the given address.  Addresses outside
+// the bank are written as constants if they are used.  Either the name or the
+// comment can be empty.
+func (d *Disassembler) SetLabel(address uint16, name, comment string) {
+	if name != "" {
+		name = LabelName(name)
+	}
+	d.labels[address] = &label{name: name, comment: comment}
+}
+
+// Label returns the name of the label at the given address.
+func (d *Disassembler) Label(address uint16) (string, bool) {
+	if l, ok := d.labels[address]; ok && l.name != "" {
+		return l.name, true
+	}
+	return "", false
+}
+
+// IsCode returns true if the address was traced as the start of an
+// instruction.
+func (d *Disassembler) IsCode(address uint16) bool {
+	return d.Contains(address) && d.starts[address-d.Base]
+}
+
+// decode returns the instruction at an address in the bank.  False is
+// returned if it shouldn't be traced.
+func (d *Disassembler) decode(address uint16) (Instruction, bool) {
+	ins, err := Decode(d.Data[address-d.Base:], address)
+	if err != nil {
+		return ins, false
+	}
+
+	if ins.Opcode.Illegal && (!d.Illegal || ins.Opcode.Name == "jam") {
+		return ins, false
+	}
+
+	off := int(address - d.Base)
+	for i := 0; i < ins.Size(); i++ {
+		if d.code[off+i] {
+			return ins, false
+		}
+
+		if d.Flags != nil && off+i < len(d.Flags) {
+			f := d.Flags[off+i]
+			if f&(rom.UP_DATA|rom.UP_LDADATA|rom.UP_AUDIO) != 0 && f&(rom.UP_CODE|rom.UP_JMPCODE) == 0 {
+				return ins, false
+			}
+		}
+	}
+
+	return ins, true
+}
+
+// Trace follows code from the given entry points.  Tracing stops at RTS,
+// RTI, BRK, JMP, and anything that can't be decoded.  Branch, JMP, and JSR
+// targets in the bank are traced as well.
+func (d *Disassembler) Trace(entries ...uint16) {
+	queue := append([]uint16{}, entries...)
+	for len(queue) > 0 {
+		address := queue[0]
+		queue = queue[1:]
+
+		for d.Contains(address) && !d.starts[address-d.Base] {
+			ins, ok := d.decode(address)
+			if !ok {
+				break
+			}
+
+			off := int(address - d.Base)
+			d.starts[off] = true
+			for i := 0; i < ins.Size(); i++ {
+				d.code[off+i] = true
+			}
+
+			if ins.IsBranch() || (ins.IsJump() && ins.Opcode.Mode == AM_ABS) {
+				queue = append(queue, ins.Operand)
+			}
+
+			if ins.Ends() {
+				break
+			}
+
+			next := int(address) + ins.Size()
+			if next > 0xFFFF {
+				break
+			}
+			address = uint16(next)
+		}
+	}
+}
+
+// Vector names for TraceVectors()
+var vectorNames = []struct {
+	address uint16
+	name    string
+}{
+	{0xFFFA, "Nmi"},
+	{0xFFFC, "Reset"},
+	{0xFFFE, "Irq"},
+}
+
+// TraceVectors traces the NMI, reset, and IRQ handlers if the vectors are in
+// this bank.  Handlers without a label are given one.
+func (d *Disassembler) TraceVectors() {
+	if !d.Contains(0xFFFA) || !d.Contains(0xFFFF) {
+		return
+	}
+
+	for _, v := range vectorNames {
+		off := v.address - d.Base
+		target := uint16(d.Data[off]) | uint16(d.Data[off+1])<<8
+		d.words[v.address] = true
+
+		if !d.Contains(target) {
+			continue
+		}
+
+		if _, ok := d.Label(target); !ok && !d.nameUsed(v.name) {
+			d.SetLabel(target, v.name, "")
+		}
+		d.Trace(target)
+	}
+}
+
+// TraceCdl traces from the start of each run of code in the CDL flags.
+func (d *Disassembler) TraceCdl() {
+	entries := []uint16{}
+	for i, f := range d.Flags {
+		if i >= len(d.Data) {
+			break
+		}
+
+		if f&rom.UP_CODE != 0 && (i == 0 || d.Flags[i-1]&rom.UP_CODE == 0 || f&rom.UP_ENTRY != 0) {
+			entries = append(entries, d.Base+uint16(i))
+		}
+	}
+	d.Trace(entries...)
+}
+
+func (d *Disassembler) nameUsed(name string) bool {
+	for _, l := range d.labels {
+		if l.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// start returns the address of the instruction that contains the given
+// address.
+func (d *Disassembler) start(address uint16) uint16 {
+	for !d.starts[address-d.Base] {
+		address--
+	}
+	return address
+}
+
+// ensureLabel makes sure a reference to the address can be written with a
+// label.  Addresses inside an instruction get a label on the instruction.
+func (d *Disassembler) ensureLabel(address uint16) {
+	off := address - d.Base
+	if d.code[off] && !d.starts[off] {
+		address = d.start(address)
+		off = address - d.Base
+	}
+
+	if _, ok := d.Label(address); ok {
+		return
+	}
+
+	prefix := "D_"
+	if d.starts[off] {
+		prefix = "L_"
+	}
+
+	comment := ""
+	if l, ok := d.labels[address]; ok {
+		comment = l.comment
+	}
+	d.labels[address] = &label{name: fmt.Sprintf("%s%04X", prefix, address), comment: comment}
+}
+
+// ref returns the operand for an address.  Constants that are used are added
+// to used.
+func (d *Disassembler) ref(address uint16, mode Mode, used map[uint16]bool) string {
+	var expr string
+	switch {
+	case d.Contains(address):
+		if name, ok := d.Label(address); ok {
+			expr = name
+		} else if !d.code[address-d.Base] {
+			expr = fmt.Sprintf("$%04X", address)
+		} else {
+			start := d.start(address)
+			name, _ := d.Label(start)
+			expr = fmt.Sprintf("%s+%d", name, address-start)
+		}
+
+	default:
+		if name, ok := d.Label(address); ok {
+			used[address] = true
+			expr = name
+		} else if address < 0x100 && mode != AM_ABS && mode != AM_ABX && mode != AM_ABY && mode != AM_IND {
+			expr = fmt.Sprintf("$%02X", address)
+		} else {
+			expr = fmt.Sprintf("$%04X", address)
+		}
+	}
+
+	// Keep absolute addressing for zero page addresses
+	if address < 0x100 && (mode == AM_ABS || mode == AM_ABX || mode == AM_ABY) {
+		expr = "a:" + expr
+	}
+
+	return expr
+}
+
+// instructions returns every traced instruction in address order.
+func (d *Disassembler) instructions() []Instruction {
+	list := []Instruction{}
+	for off, s := range d.starts {
+		if s {
+			ins, _ := Decode(d.Data[off:], d.Base+uint16(off))
+			list = append(list, ins)
+		}
+	}
+	return list
+}
+
+func (d *Disassembler) word(address uint16) uint16 {
+	off := address - d.Base
+	return uint16(d.Data[off]) | uint16(d.Data[off+1])<<8
+}
+
+// isWord returns true if a vector should be written with .word at the
+// address.
+func (d *Disassembler) isWord(address uint16) bool {
+	if !d.words[address] || !d.Contains(address+1) {
+		return false
+	}
+
+	off := address - d.Base
+	if d.code[off] || d.code[off+1] {
+		return false
+	}
+
+	_, named := d.Label(address + 1)
+	return !named
+}
+
+// Source returns the ca65 source for the bank.
+func (d *Disassembler) Source() string {
+	instructions := d.instructions()
+
+	// Give every referenced address in the bank a label first.
+	for _, ins := range instructions {
+		target, ok := ins.Target()
+		if ok && !ins.Opcode.Illegal && d.Contains(target) {
+			d.ensureLabel(target)
+		}
+
+		// Labels inside the instruction are defined from its start.
+		for i := 1; i < ins.Size(); i++ {
+			if _, ok := d.Label(ins.Address + uint16(i)); ok {
+				d.ensureLabel(ins.Address)
+			}
+		}
+	}
+
+	for address := range d.words {
+		if d.isWord(address) && d.Contains(d.word(address)) {
+			d.ensureLabel(d.word(address))
+		}
+	}
+
+	used := make(map[uint16]bool)
+	body := &strings.Builder{}
+	d.writeBody(body, used)
+
+	sb := &strings.Builder{}
+	addrs := []int{}
+	for address := range used {
+		addrs = append(addrs, int(address))
+	}
+	sort.Ints(addrs)
+
+	for _, a := range addrs {
+		l := d.labels[uint16(a)]
+		if l.comment != "" {
+			writeComment(sb, l.comment)
+		}
+		if a < 0x100 {
+			fmt.Fprintf(sb, "%s = $%02X\n", l.name, a)
+		} else {
+			fmt.Fprintf(sb, "%s = $%04X\n", l.name, a)
+		}
+	}
+	if len(addrs) > 0 {
+		sb.WriteString("\n")
+	}
+
+	if d.Segment != "" {
+		fmt.Fprintf(sb, ".segment %q\n\n", d.Segment)
+	}
+
+	sb.WriteString(body.String())
+	return sb.String()
+}
+
+// WriteTo writes the ca65 source for the bank.
+func (d *Disassembler) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, d.Source())
+	return int64(n), err
+}
+
+func (d *Disassembler) writeBody(sb *strings.Builder, used map[uint16]bool) {
+	data := []byte{}
+	dataStart := 0
+
+	flush := func() {
+		if len(data) == 0 {
+			return
+		}
+
+		vals := []string{}
+		for _, b := range data {
+			vals = append(vals, fmt.Sprintf("$%02X", b))
+		}
+		writeLine(sb, ".byte "+strings.Join(vals, ", "), d.Base+uint16(dataStart), "")
+		data = data[:0]
+	}
+
+	for off := 0; off < len(d.Data); {
+		address := d.Base + uint16(off)
+
+		if l, ok := d.labels[address]; ok {
+			flush()
+			if l.comment != "" {
+				writeComment(sb, l.comment)
+			}
+			if l.name != "" {
+				fmt.Fprintf(sb, "%s:\n", l.name)
+			}
+		}
+
+		if d.starts[off] {
+			flush()
+			ins, _ := Decode(d.Data[off:], address)
+			d.writeInstruction(sb, ins, used)
+			off += ins.Size()
+			continue
+		}
+
+		if d.isWord(address) {
+			flush()
+			// Words are always two bytes, so no "a:"
+			writeLine(sb, ".word "+d.ref(d.word(address), AM_IND, used), address, "")
+			off += 2
+			continue
+		}
+
+		if len(data) == 0 {
+			dataStart = off
+		}
+		data = append(data, d.Data[off])
+		off++
+
+		if len(data) == 16 {
+			flush()
+		}
+	}
+	flush()
+}
+
+func (d *Disassembler) writeInstruction(sb *strings.Builder, ins Instruction, used map[uint16]bool) {
+	if ins.Opcode.Illegal {
+		vals := []string{}
+		for _, b := range ins.Bytes {
+			vals = append(vals, fmt.Sprintf("$%02X", b))
+		}
+		writeLine(sb, ".byte "+strings.Join(vals, ", "), ins.Address, strings.ToLower(ins.String()))
+		d.writeInnerLabels(sb, ins)
+		return
+	}
+
+	operand := ""
+	switch ins.Opcode.Mode {
+	case AM_IMP:
+	case AM_ACC:
+		operand = "a"
+	case AM_IMM:
+		operand = fmt.Sprintf("#$%02X", ins.Operand)
+	default:
+		operand = ins.Opcode.Mode.Format(d.ref(ins.Operand, ins.Opcode.Mode, used))
+	}
+
+	writeLine(sb, strings.TrimSpace(ins.Opcode.Name+" "+operand), ins.Address, "")
+	d.writeInnerLabels(sb, ins)
+}
+
+// writeInnerLabels defines labels that point inside an instruction relative
+// to its start.
+func (d *Disassembler) writeInnerLabels(sb *strings.Builder, ins Instruction) {
+	start, _ := d.Label(ins.Address)
+	for i := 1; i < ins.Size(); i++ {
+		if name, ok := d.Label(ins.Address + uint16(i)); ok {
+			fmt.Fprintf(sb, "%s = %s+%d\n", name, start, i)
+		}
+	}
+}
+
+func writeLine(sb *strings.Builder, text string, address uint16, comment string) {
+	if comment != "" {
+		comment = " " + comment
+	}
+	fmt.Fprintf(sb, "    %-32s ; $%04X%s\n", text, address, comment)
+}
+
+func writeComment(sb *strings.Builder, comment string) {
+	for _, line := range strings.Split(strings.TrimRight(comment, "\n"), "\n") {
+		fmt.Fprintf(sb, "; %s\n", strings.TrimRight(line, "\r"))
+	}
+}
+
+// LabelName turns a name into a valid ca65 identifier.
+func LabelName(name string) string {
+	clean := []rune{}
+	for i, r := range name {
+		switch {
+		case r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z'):
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				clean = append(clean, '_')
+			}
+		default:
+			r = '_'
+		}
+		clean = append(clean, r)
+	}
+
+	name = string(clean)
+	if reserved[strings.ToLower(name)] {
+		name += "_"
+	}
+	return name
+}
+
+// Register names and mnemonics can't be used as labels.
+var reserved = map[string]bool{"a": true, "x": true, "y": true, "s": true, "z": true}
+
+func init() {
+	for _, op := range Opcodes {
+		reserved[op.Name] = true
+	}
+}
@@ -0,0 +1,109 @@
+package disasm
+
+import (
+	"strings"
+	"testing"
+)
+
+func testBank() []byte {
+	bank := make([]byte, 0x4000)
+	for i := range bank {
+		bank[i] = 0xFF
+	}
+
+	// $C000: sei, ldx #$00, lda Table,x, lda a:$0010, sta $10, jsr $C015,
+	// bne $C000, jmp $C002, two bytes of data, lax $10 (illegal), rts
+	code := []byte{
+		0x78, 0xA2, 0x00, 0xBD, 0x20, 0xC0, 0xAD, 0x10, 0x00, 0x85, 0x10,
+		0x20, 0x15, 0xC0, 0xD0, 0xF0, 0x4C, 0x02, 0xC0, 0x00, 0x00,
+		0xA7, 0x10, 0x60,
+	}
+	copy(bank, code)
+	copy(bank[0x20:], []byte{0x01, 0x02, 0x03})
+
+	// NMI, reset, IRQ
+	copy(bank[0x3FFA:], []byte{0x17, 0xC0, 0x00, 0xC0, 0x17, 0xC0})
+	return bank
+}
+
+func TestDisassemble(t *testing.T) {
+	d := New(testBank(), 0xC000)
+	d.Segment = "BANK_00"
+	d.SetLabel(0xC020, "Table", "Lookup table")
+	d.SetLabel(0x2000, "PpuCtrl", "")
+	d.TraceVectors()
+
+	if d.IsCode(0xC015) {
+		t.Errorf("Illegal opcode traced without Illegal set")
+	}
+
+	src := d.Source()
+	t.Log(src)
+
+	expect := []string{
+		".segment \"BANK_00\"",
+		"Reset:",
+		"lda Table,x",
+		"lda a:$0010",
+		"sta $10",
+		"jsr D_C015",
+		"bne Reset",
+		"jmp L_C001+1",
+		"; Lookup table\nTable:",
+		".word Nmi",
+		".word Reset",
+	}
+	for _, e := range expect {
+		if !strings.Contains(src, e) {
+			t.Errorf("Missing %q", e)
+		}
+	}
+
+	if strings.Contains(src, "PpuCtrl") {
+		t.Errorf("Unused constant written")
+	}
+
+	d = New(testBank(), 0xC000)
+	d.Illegal = true
+	d.TraceVectors()
+	src = d.Source()
+
+	if !d.IsCode(0xC015) || !d.IsCode(0xC017) {
+		t.Errorf("Illegal opcode not traced")
+	}
+
+	if !strings.Contains(src, ".byte $A7, $10") || !strings.Contains(src, "; $C015 lax $10") {
+		t.Errorf("Illegal opcode not written as bytes:\n%s", src)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		raw  []byte
+		addr uint16
+		want string
+	}{
+		{[]byte{0xA9, 0x10}, 0x8000, "LDA #$10"},
+		{[]byte{0xB1, 0x20}, 0x8000, "LDA ($20),Y"},
+		{[]byte{0x6C, 0xFC, 0xFF}, 0x8000, "JMP ($FFFC)"},
+		{[]byte{0xD0, 0xFE}, 0x8000, "BNE $8000"},
+		{[]byte{0x10, 0x7F}, 0x8000, "BPL $8081"},
+		{[]byte{0x0A}, 0x8000, "ASL A"},
+	}
+
+	for _, tt := range tests {
+		ins, err := Decode(tt.raw, tt.addr)
+		if err != nil {
+			t.Errorf("%X: %v", tt.raw, err)
+			continue
+		}
+
+		if ins.String() != tt.want {
+			t.Errorf("%X: expected %q, got %q", tt.raw, tt.want, ins.String())
+		}
+	}
+
+	if _, err := Decode([]byte{0xAD, 0x00}, 0x8000); err == nil {
+		t.Errorf("Expected an error for a short instruction")
+	}
+}
@@ -0,0 +1,365 @@
+package disasm
+
+import (
+	"fmt"
+)
+
+// Mode is an addressing mode.
+type Mode uint8
+
+const (
+	AM_IMP Mode = iota // Implied
+	AM_ACC             // Accumulator
+	AM_IMM             // #$00
+	AM_ZP              // $00
+	AM_ZPX             // $00,x
+	AM_ZPY             // $00,y
+	AM_ABS             // $0000
+	AM_ABX             // $0000,x
+	AM_ABY             // $0000,y
+	AM_IND             // ($0000)
+	AM_IZX             // ($00,x)
+	AM_IZY             // ($00),y
+	AM_REL             // Branch offset
+)
+
+// Size returns the instruction length for the mode, including the opcode.
+func (m Mode) Size() int {
+	switch m {
+	case AM_IMP, AM_ACC:
+		return 1
+	case AM_ABS, AM_ABX, AM_ABY, AM_IND:
+		return 3
+	}
+	return 2
+}
+
+// Format returns the operand formatted for the mode.  The operand should
+// already be a string, such as a label or a hex number.
+func (m Mode) Format(operand string) string {
+	switch m {
+	case AM_IMP:
+		return ""
+	case AM_ACC:
+		return "a"
+	case AM_IMM:
+		return "#" + operand
+	case AM_ZPX, AM_ABX:
+		return operand + ",x"
+	case AM_ZPY, AM_ABY:
+		return operand + ",y"
+	case AM_IND:
+		return "(" + operand + ")"
+	case AM_IZX:
+		return "(" + operand + ",x)"
+	case AM_IZY:
+		return "(" + operand + "),y"
+	}
+	return operand
+}
+
+func (m Mode) String() string {
+	switch m {
+	case AM_IMP:
+		return "Implied"
+	case AM_ACC:
+		return "Accumulator"
+	case AM_IMM:
+		return "Immediate"
+	case AM_ZP:
+		return "Zero Page"
+	case AM_ZPX:
+		return "Zero Page,X"
+	case AM_ZPY:
+		return "Zero Page,Y"
+	case AM_ABS:
+		return "Absolute"
+	case AM_ABX:
+		return "Absolute,X"
+	case AM_ABY:
+		return "Absolute,Y"
+	case AM_IND:
+		return "Indirect"
+	case AM_IZX:
+		return "(Indirect,X)"
+	case AM_IZY:
+		return "(Indirect),Y"
+	case AM_REL:
+		return "Relative"
+	}
+	return fmt.Sprintf("Unknown (%d)", uint8(m))
+}
+
+// Opcode describes a single opcode.
+type Opcode struct {
+	Name   string // ca65 mnemonic, lowercase
+	Mode   Mode
+	Cycles uint8 // Base cycle count
+
+	// An extra cycle is taken when indexing crosses a page.  Branches always
+	// take an extra cycle when taken, plus another when crossing a page.
+	PageCycle bool
+
+	Illegal bool // Unofficial opcode
+}
+
+// Opcodes is indexed by the opcode byte.  Unofficial names follow ca65's
+// 6502X names.
+var Opcodes = [256]Opcode{
+	{"brk", AM_IMP, 7, false, false}, // $00
+	{"ora", AM_IZX, 6, false, false}, // $01
+	{"jam", AM_IMP, 2, false, true},  // $02
+	{"slo", AM_IZX, 8, false, true},  // $03
+	{"nop", AM_ZP, 3, false, true},   // $04
+	{"ora", AM_ZP, 3, false, false},  // $05
+	{"asl", AM_ZP, 5, false, false},  // $06
+	{"slo", AM_ZP, 5, false, true},   // $07
+	{"php", AM_IMP, 3, false, false}, // $08
+	{"ora", AM_IMM, 2, false, false}, // $09
+	{"asl", AM_ACC, 2, false, false}, // $0A
+	{"anc", AM_IMM, 2, false, true},  // $0B
+	{"nop", AM_ABS, 4, false, true},  // $0C
+	{"ora", AM_ABS, 4, false, false}, // $0D
+	{"asl", AM_ABS, 6, false, false}, // $0E
+	{"slo", AM_ABS, 6, false, true},  // $0F
+	{"bpl", AM_REL, 2, true, false},  // $10
+	{"ora", AM_IZY, 5, true, false},  // $11
+	{"jam", AM_IMP, 2, false, true},  // $12
+	{"slo", AM_IZY, 8, false, true},  // $13
+	{"nop", AM_ZPX, 4, false, true},  // $14
+	{"ora", AM_ZPX, 4, false, false}, // $15
+	{"asl", AM_ZPX, 6, false, false}, // $16
+	{"slo", AM_ZPX, 6, false, true},  // $17
+	{"clc", AM_IMP, 2, false, false}, // $18
+	{"ora", AM_ABY, 4, true, false},  // $19
+	{"nop", AM_IMP, 2, false, true},  // $1A
+	{"slo", AM_ABY, 7, false, true},  // $1B
+	{"nop", AM_ABX, 4, true, true},   // $1C
+	{"ora", AM_ABX, 4, true, false},  // $1D
+	{"asl", AM_ABX, 7, false, false}, // $1E
+	{"slo", AM_ABX, 7, false, true},  // $1F
+	{"jsr", AM_ABS, 6, false, false}, // $20
+	{"and", AM_IZX, 6, false, false}, // $21
+	{"jam", AM_IMP, 2, false, true},  // $22
+	{"rla", AM_IZX, 8, false, true},  // $23
+	{"bit", AM_ZP, 3, false, false},  // $24
+	{"and", AM_ZP, 3, false, false},  // $25
+	{"rol", AM_ZP, 5, false, false},  // $26
+	{"rla", AM_ZP, 5, false, true},   // $27
+	{"plp", AM_IMP, 4, false, false}, // $28
+	{"and", AM_IMM, 2, false, false}, // $29
+	{"rol", AM_ACC, 2, false, false}, // $2A
+	{"anc", AM_IMM, 2, false, true},  // $2B
+	{"bit", AM_ABS, 4, false, false}, // $2C
+	{"and", AM_ABS, 4, false, false}, // $2D
+	{"rol", AM_ABS, 6, false, false}, // $2E
+	{"rla", AM_ABS, 6, false, true},  // $2F
+	{"bmi", AM_REL, 2, true, false},  // $30
+	{"and", AM_IZY, 5, true, false},  // $31
+	{"jam", AM_IMP, 2, false, true},  // $32
+	{"rla", AM_IZY, 8, false, true},  // $33
+	{"nop", AM_ZPX, 4, false, true},  // $34
+	{"and", AM_ZPX, 4, false, false}, // $35
+	{"rol", AM_ZPX, 6, false, false}, // $36
+	{"rla", AM_ZPX, 6, false, true},  // $37
+	{"sec", AM_IMP, 2, false, false}, // $38
+	{"and", AM_ABY, 4, true, false},  // $39
+	{"nop", AM_IMP, 2, false, true},  // $3A
+	{"rla", AM_ABY, 7, false, true},  // $3B
+	{"nop", AM_ABX, 4, true, true},   // $3C
+	{"and", AM_ABX, 4, true, false},  // $3D
+	{"rol", AM_ABX, 7, false, false}, // $3E
+	{"rla", AM_ABX, 7, false, true},  // $3F
+	{"rti", AM_IMP, 6, false, false}, // $40
+	{"eor", AM_IZX, 6, false, false}, // $41
+	{"jam", AM_IMP, 2, false, true},  // $42
+	{"sre", AM_IZX, 8, false, true},  // $43
+	{"nop", AM_ZP, 3, false, true},   // $44
+	{"eor", AM_ZP, 3, false, false},  // $45
+	{"lsr", AM_ZP, 5, false, false},  // $46
+	{"sre", AM_ZP, 5, false, true},   // $47
+	{"pha", AM_IMP, 3, false, false}, // $48
+	{"eor", AM_IMM, 2, false, false}, // $49
+	{"lsr", AM_ACC, 2, false, false}, // $4A
+	{"alr", AM_IMM, 2, false, true},  // $4B
+	{"jmp", AM_ABS, 3, false, false}, // $4C
+	{"eor", AM_ABS, 4, false, false}, // $4D
+	{"lsr", AM_ABS, 6, false, false}, // $4E
+	{"sre", AM_ABS, 6, false, true},  // $4F
+	{"bvc", AM_REL, 2, true, false},  // $50
+	{"eor", AM_IZY, 5, true, false},  // $51
+	{"jam", AM_IMP, 2, false, true},  // $52
+	{"sre", AM_IZY, 8, false, true},  // $53
+	{"nop", AM_ZPX, 4, false, true},  // $54
+	{"eor", AM_ZPX, 4, false, false}, // $55
+	{"lsr", AM_ZPX, 6, false, false}, // $56
+	{"sre", AM_ZPX, 6, false, true},  // $57
+	{"cli", AM_IMP, 2, false, false}, // $58
+	{"eor", AM_ABY, 4, true, false},  // $59
+	{"nop", AM_IMP, 2, false, true},  // $5A
+	{"sre", AM_ABY, 7, false, true},  // $5B
+	{"nop", AM_ABX, 4, true, true},   // $5C
+	{"eor", AM_ABX, 4, true, false},  // $5D
+	{"lsr", AM_ABX, 7, false, false}, // $5E
+	{"sre", AM_ABX, 7, false, true},  // $5F
+	{"rts", AM_IMP, 6, false, false}, // $60
+	{"adc", AM_IZX, 6, false, false}, // $61
+	{"jam", AM_IMP, 2, false, true},  // $62
+	{"rra", AM_IZX, 8, false, true},  // $63
+	{"nop", AM_ZP, 3, false, true},   // $64
+	{"adc", AM_ZP, 3, false, false},  // $65
+	{"ror", AM_ZP, 5, false, false},  // $66
+	{"rra", AM_ZP, 5, false, true},   // $67
+	{"pla", AM_IMP, 4, false, false}, // $68
+	{"adc", AM_IMM, 2, false, false}, // $69
+	{"ror", AM_ACC, 2, false, false}, // $6A
+	{"arr", AM_IMM, 2, false, true},  // $6B
+	{"jmp", AM_IND, 5, false, false}, // $6C
+	{"adc", AM_ABS, 4, false, false}, // $6D
+	{"ror", AM_ABS, 6, false, false}, // $6E
+	{"rra", AM_ABS, 6, false, true},  // $6F
+	{"bvs", AM_REL, 2, true, false},  // $70
+	{"adc", AM_IZY, 5, true, false},  // $71
+	{"jam", AM_IMP, 2, false, true},  // $72
+	{"rra", AM_IZY, 8, false, true},  // $73
+	{"nop", AM_ZPX, 4, false, true},  // $74
+	{"adc", AM_ZPX, 4, false, false}, // $75
+	{"ror", AM_ZPX, 6, false, false}, // $76
+	{"rra", AM_ZPX, 6, false, true},  // $77
+	{"sei", AM_IMP, 2, false, false}, // $78
+	{"adc", AM_ABY, 4, true, false},  // $79
+	{"nop", AM_IMP, 2, false, true},  // $7A
+	{"rra", AM_ABY, 7, false, true},  // $7B
+	{"nop", AM_ABX, 4, true, true},   // $7C
+	{"adc", AM_ABX, 4, true, false},  // $7D
+	{"ror", AM_ABX, 7, false, false}, // $7E
+	{"rra", AM_ABX, 7, false, true},  // $7F
+	{"nop", AM_IMM, 2, false, true},  // $80
+	{"sta", AM_IZX, 6, false, false}, // $81
+	{"nop", AM_IMM, 2, false, true},  // $82
+	{"sax", AM_IZX, 6, false, true},  // $83
+	{"sty", AM_ZP, 3, false, false},  // $84
+	{"sta", AM_ZP, 3, false, false},  // $85
+	{"stx", AM_ZP, 3, false, false},  // $86
+	{"sax", AM_ZP, 3, false, true},   // $87
+	{"dey", AM_IMP, 2, false, false}, // $88
+	{"nop", AM_IMM, 2, false, true},  // $89
+	{"txa", AM_IMP, 2, false, false}, // $8A
+	{"ane", AM_IMM, 2, false, true},  // $8B
+	{"sty", AM_ABS, 4, false, false}, // $8C
+	{"sta", AM_ABS, 4, false, false}, // $8D
+	{"stx", AM_ABS, 4, false, false}, // $8E
+	{"sax", AM_ABS, 4, false, true},  // $8F
+	{"bcc", AM_REL, 2, true, false},  // $90
+	{"sta", AM_IZY, 6, false, false}, // $91
+	{"jam", AM_IMP, 2, false, true},  // $92
+	{"sha", AM_IZY, 6, false, true},  // $93
+	{"sty", AM_ZPX, 4, false, false}, // $94
+	{"sta", AM_ZPX, 4, false, false}, // $95
+	{"stx", AM_ZPY, 4, false, false}, // $96
+	{"sax", AM_ZPY, 4, false, true},  // $97
+	{"tya", AM_IMP, 2, false, false}, // $98
+	{"sta", AM_ABY, 5, false, false}, // $99
+	{"txs", AM_IMP, 2, false, false}, // $9A
+	{"tas", AM_ABY, 5, false, true},  // $9B
+	{"shy", AM_ABX, 5, false, true},  // $9C
+	{"sta", AM_ABX, 5, false, false}, // $9D
+	{"shx", AM_ABY, 5, false, true},  // $9E
+	{"sha", AM_ABY, 5, false, true},  // $9F
+	{"ldy", AM_IMM, 2, false, false}, // $A0
+	{"lda", AM_IZX, 6, false, false}, // $A1
+	{"ldx", AM_IMM, 2, false, false}, // $A2
+	{"lax", AM_IZX, 6, false, true},  // $A3
+	{"ldy", AM_ZP, 3, false, false},  // $A4
+	{"lda", AM_ZP, 3, false, false},  // $A5
+	{"ldx", AM_ZP, 3, false, false},  // $A6
+	{"lax", AM_ZP, 3, false, true},   // $A7
+	{"tay", AM_IMP, 2, false, false}, // $A8
+	{"lda", AM_IMM, 2, false, false}, // $A9
+	{"tax", AM_IMP, 2, false, false}, // $AA
+	{"lax", AM_IMM, 2, false, true},  // $AB
+	{"ldy", AM_ABS, 4, false, false}, // $AC
+	{"lda", AM_ABS, 4, false, false}, // $AD
+	{"ldx", AM_ABS, 4, false, false}, // $AE
+	{"lax", AM_ABS, 4, false, true},  // $AF
+	{"bcs", AM_REL, 2, true, false},  // $B0
+	{"lda", AM_IZY, 5, true, false},  // $B1
+	{"jam", AM_IMP, 2, false, true},  // $B2
+	{"lax", AM_IZY, 5, true, true},   // $B3
+	{"ldy", AM_ZPX, 4, false, false}, // $B4
+	{"lda", AM_ZPX, 4, false, false}, // $B5
+	{"ldx", AM_ZPY, 4, false, false}, // $B6
+	{"lax", AM_ZPY, 4, false, true},  // $B7
+	{"clv", AM_IMP, 2, false, false}, // $B8
+	{"lda", AM_ABY, 4, true, false},  // $B9
+	{"tsx", AM_IMP, 2, false, false}, // $BA
+	{"las", AM_ABY, 4, true, true},   // $BB
+	{"ldy", AM_ABX, 4, true, false},  // $BC
+	{"lda", AM_ABX, 4, true, false},  // $BD
+	{"ldx", AM_ABY, 4, true, false},  // $BE
+	{"lax", AM_ABY, 4, true, true},   // $BF
+	{"cpy", AM_IMM, 2, false, false}, // $C0
+	{"cmp", AM_IZX, 6, false, false}, // $C1
+	{"nop", AM_IMM, 2, false, true},  // $C2
+	{"dcp", AM_IZX, 8, false, true},  // $C3
+	{"cpy", AM_ZP, 3, false, false},  // $C4
+	{"cmp", AM_ZP, 3, false, false},  // $C5
+	{"dec", AM_ZP, 5, false, false},  // $C6
+	{"dcp", AM_ZP, 5, false, true},   // $C7
+	{"iny", AM_IMP, 2, false, false}, // $C8
+	{"cmp", AM_IMM, 2, false, false}, // $C9
+	{"dex", AM_IMP, 2, false, false}, // $CA
+	{"axs", AM_IMM, 2, false, true},  // $CB
+	{"cpy", AM_ABS, 4, false, false}, // $CC
+	{"cmp", AM_ABS, 4, false, false}, // $CD
+	{"dec", AM_ABS, 6, false, false}, // $CE
+	{"dcp", AM_ABS, 6, false, true},  // $CF
+	{"bne", AM_REL, 2, true, false},  // $D0
+	{"cmp", AM_IZY, 5, true, false},  // $D1
+	{"jam", AM_IMP, 2, false, true},  // $D2
+	{"dcp", AM_IZY, 8, false, true},  // $D3
+	{"nop", AM_ZPX, 4, false, true},  // $D4
+	{"cmp", AM_ZPX, 4, false, false}, // $D5
+	{"dec", AM_ZPX, 6, false, false}, // $D6
+	{"dcp", AM_ZPX, 6, false, true},  // $D7
+	{"cld", AM_IMP, 2, false, false}, // $D8
+	{"cmp", AM_ABY, 4, true, false},  // $D9
+	{"nop", AM_IMP, 2, false, true},  // $DA
+	{"dcp", AM_ABY, 7, false, true},  // $DB
+	{"nop", AM_ABX, 4, true, true},   // $DC
+	{"cmp", AM_ABX, 4, true, false},  // $DD
+	{"dec", AM_ABX, 7, false, false}, // $DE
+	{"dcp", AM_ABX, 7, false, true},  // $DF
+	{"cpx", AM_IMM, 2, false, false}, // $E0
+	{"sbc", AM_IZX, 6, false, false}, // $E1
+	{"nop", AM_IMM, 2, false, true},  // $E2
+	{"isc", AM_IZX, 8, false, true},  // $E3
+	{"cpx", AM_ZP, 3, false, false},  // $E4
+	{"sbc", AM_ZP, 3, false, false},  // $E5
+	{"inc", AM_ZP, 5, false, false},  // $E6
+	{"isc", AM_ZP, 5, false, true},   // $E7
+	{"inx", AM_IMP, 2, false, false}, // $E8
+	{"sbc", AM_IMM, 2, false, false}, // $E9
+	{"nop", AM_IMP, 2, false, false}, // $EA
+	{"sbc", AM_IMM, 2, false, true},  // $EB
+	{"cpx", AM_ABS, 4, false, false}, // $EC
+	{"sbc", AM_ABS, 4, false, false}, // $ED
+	{"inc", AM_ABS, 6, false, false}, // $EE
+	{"isc", AM_ABS, 6, false, true},  // $EF
+	{"beq", AM_REL, 2, true, false},  // $F0
+	{"sbc", AM_IZY, 5, true, false},  // $F1
+	{"jam", AM_IMP, 2, false, true},  // $F2
+	{"isc", AM_IZY, 8, false, true},  // $F3
+	{"nop", AM_ZPX, 4, false, true},  // $F4
+	{"sbc", AM_ZPX, 4, false, false}, // $F5
+	{"inc", AM_ZPX, 6, false, false}, // $F6
+	{"isc", AM_ZPX, 6, false, true},  // $F7
+	{"sed", AM_IMP, 2, false, false}, // $F8
+	{"sbc", AM_ABY, 4, true, false},  // $F9
+	{"nop", AM_IMP, 2, false, true},  // $FA
+	{"isc", AM_ABY, 7, false, true},  // $FB
+	{"nop", AM_ABX, 4, true, true},   // $FC
+	{"sbc", AM_ABX, 4, true, false},  // $FD
+	{"inc", AM_ABX, 7, false, false}, // $FE
+	{"isc", AM_ABX, 7, false, true},  // $FF
+}
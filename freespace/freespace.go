@@ -0,0 +1,219 @@
+// Package freespace finds unused space in PRG and CHR ROM.
+//
+// PRG space is runs of a fill byte.  CHR space is empty tiles.  Either can be
+// wrong for a given game (a table of zeros looks just like free space), so a
+// code/data log can be given to rule out bytes that are known to be used.
+package freespace
+
+import (
+	"bytes"
+
+	nesimg "github.com/zorchenhimer/go-nes/image"
+	"github.com/zorchenhimer/go-nes/mapper"
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// Range is a run of free space inside a single bank.  Offsets are into PRG or
+// CHR ROM.
+type Range struct {
+	Bank    uint
+	Start   uint
+	End     uint   // Last free byte
+	Address uint16 // CPU address of Start for PRG, PPU address for CHR
+	Fill    byte   // Fill value for PRG ranges
+}
+
+// Size returns the length of the range in bytes.
+func (r Range) Size() uint {
+	return r.End - r.Start + 1
+}
+
+// EndAddress returns the address of the last byte in the range.
+func (r Range) EndAddress() uint16 {
+	return r.Address + uint16(r.Size()-1)
+}
+
+// Report is the free space in PRG or CHR ROM.
+type Report struct {
+	Size     uint // Size of the ROM area
+	BankSize uint
+	Ranges   []Range
+	Free     uint // Total free bytes
+}
+
+// Percent returns the free space as a percentage of the whole area.
+func (r *Report) Percent() float64 {
+	if r.Size == 0 {
+		return 0
+	}
+	return float64(r.Free) / float64(r.Size) * 100
+}
+
+// BankFree returns the free bytes in a bank.
+func (r *Report) BankFree(bank uint) uint {
+	free := uint(0)
+	for _, rng := range r.Ranges {
+		if rng.Bank == bank {
+			free += rng.Size()
+		}
+	}
+	return free
+}
+
+// Options for Prg() and Chr().  The zero value uses the defaults.
+type Options struct {
+	Fill      []byte   // Fill values for PRG.  Defaults to $FF and $00.
+	MinLength uint     // Shortest PRG run to count.  Defaults to 16.
+	Cdl       *rom.Cdl // Anything the log marks as used is never free.
+}
+
+const defaultMinLength = 16
+
+// Prg finds runs of fill bytes in PRG ROM.  Runs don't cross bank boundaries.
+// Unknown mappers are treated as 16k banks at $8000.
+func Prg(r rom.Rom, opts Options) *Report {
+	data := r.PrgRom()
+	fill := opts.Fill
+	if len(fill) == 0 {
+		fill = []byte{0xFF, 0x00}
+	}
+	minLength := opts.MinLength
+	if minLength == 0 {
+		minLength = defaultMinLength
+	}
+
+	var used []byte
+	if opts.Cdl != nil {
+		used = opts.Cdl.Prg
+	}
+
+	layout, _ := mapper.FromRom(r)
+	bankSize := uint(0x4000)
+	if layout != nil {
+		bankSize = layout.PrgBankSize()
+	}
+
+	report := &Report{Size: uint(len(data)), BankSize: bankSize}
+	address := func(offset uint) uint16 {
+		if layout != nil {
+			if locs := layout.PrgLocations(offset); len(locs) > 0 {
+				return locs[0].Address
+			}
+		}
+		return 0x8000 + uint16(offset%bankSize)
+	}
+
+	for i := uint(0); i < uint(len(data)); {
+		value := data[i]
+		if bytes.IndexByte(fill, value) < 0 || isUsed(used, i, false) {
+			i++
+			continue
+		}
+
+		start := i
+		i++
+		for i < uint(len(data)) && i%bankSize != 0 && data[i] == value && !isUsed(used, i, false) {
+			i++
+		}
+
+		if i-start >= minLength {
+			report.add(Range{
+				Bank:    start / bankSize,
+				Start:   start,
+				End:     i - 1,
+				Address: address(start),
+				Fill:    value,
+			})
+		}
+	}
+
+	return report
+}
+
+// Chr finds empty tiles in CHR ROM.  Runs of empty tiles are merged into a
+// single range.  CHR RAM games have an empty report.
+func Chr(r rom.Rom, opts Options) (*Report, error) {
+	data := r.ChrRom()
+	report := &Report{Size: uint(len(data))}
+	if len(data) == 0 {
+		return report, nil
+	}
+
+	pt, err := nesimg.ReadCHR(bytes.NewReader(data[:len(data)/16*16]))
+	if err != nil {
+		return nil, err
+	}
+
+	var used []byte
+	if opts.Cdl != nil {
+		used = opts.Cdl.Chr
+	}
+
+	layout, _ := mapper.FromRom(r)
+	report.BankSize = 0x2000
+	if layout != nil && layout.ChrBankSize() != 0 {
+		report.BankSize = layout.ChrBankSize()
+	}
+
+	address := func(offset uint) uint16 {
+		if layout != nil {
+			if locs := layout.ChrLocations(offset); len(locs) > 0 {
+				return locs[0].Address
+			}
+		}
+		return uint16(offset % 0x2000)
+	}
+
+	empty := func(id int) bool {
+		if !pt.Patterns[id].IsEmpty() {
+			return false
+		}
+		for i := uint(id * 16); i < uint(id*16+16); i++ {
+			if isUsed(used, i, true) {
+				return false
+			}
+		}
+		return true
+	}
+
+	tilesPerBank := int(report.BankSize / 16)
+	for id := 0; id < len(pt.Patterns); {
+		if !empty(id) {
+			id++
+			continue
+		}
+
+		start := id
+		id++
+		for id < len(pt.Patterns) && id%tilesPerBank != 0 && empty(id) {
+			id++
+		}
+
+		offset := uint(start * 16)
+		report.add(Range{
+			Bank:    offset / report.BankSize,
+			Start:   offset,
+			End:     uint(id*16) - 1,
+			Address: address(offset),
+		})
+	}
+
+	return report, nil
+}
+
+func (r *Report) add(rng Range) {
+	r.Ranges = append(r.Ranges, rng)
+	r.Free += rng.Size()
+}
+
+// isUsed returns true if the code/data log marks the byte as used.
+func isUsed(flags []byte, offset uint, chr bool) bool {
+	if offset >= uint(len(flags)) {
+		return false
+	}
+
+	if chr {
+		return rom.ChrUsage(flags[offset]) != rom.UC_UNKOWN
+	}
+	return rom.PrgUsage(flags[offset]) != rom.UP_UNKNOWN
+}
@@ -0,0 +1,94 @@
+package freespace
+
+import (
+	"testing"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// testRom returns a UxROM with two 16k PRG banks and 8k of CHR.  Each bank
+// has a run of $FF at the end that continues into the next bank.
+func testRom() *rom.NesRom {
+	prg := make([]byte, 0x8000)
+	for i := range prg {
+		prg[i] = byte(i%251) | 1
+	}
+	for i := 0x3F00; i < 0x4080; i++ {
+		prg[i] = 0xFF
+	}
+	// A zero run that is too short to count
+	for i := 0x5000; i < 0x5008; i++ {
+		prg[i] = 0x00
+	}
+
+	chr := make([]byte, 0x2000)
+	for i := range chr {
+		chr[i] = 0xAA
+	}
+	// Tiles 2-4 are empty
+	for i := 0x20; i < 0x50; i++ {
+		chr[i] = 0
+	}
+
+	return &rom.NesRom{
+		Header: &rom.Header{Mapper: 2, PrgSize: 0x8000, ChrSize: 0x2000},
+		Prgrom: prg,
+		Chrrom: chr,
+	}
+}
+
+func TestPrg(t *testing.T) {
+	r := testRom()
+	report := Prg(r, Options{})
+
+	expect := []Range{
+		{Bank: 0, Start: 0x3F00, End: 0x3FFF, Address: 0xBF00, Fill: 0xFF},
+		{Bank: 1, Start: 0x4000, End: 0x407F, Address: 0xC000, Fill: 0xFF},
+	}
+	if len(report.Ranges) != len(expect) {
+		t.Fatalf("Expected %d ranges, got %v", len(expect), report.Ranges)
+	}
+	for i, rng := range expect {
+		if report.Ranges[i] != rng {
+			t.Errorf("Range %d: expected %v, got %v", i, rng, report.Ranges[i])
+		}
+	}
+
+	if report.Free != 0x180 || report.BankFree(1) != 0x80 {
+		t.Errorf("Bad totals: %d %d", report.Free, report.BankFree(1))
+	}
+
+	if report := Prg(r, Options{Fill: []byte{0x00}, MinLength: 8}); len(report.Ranges) != 1 || report.Ranges[0].Start != 0x5000 {
+		t.Errorf("Expected the zero run, got %v", report.Ranges)
+	}
+
+	// Data that is read in the middle of the first run splits it
+	cdl := &rom.Cdl{Prg: make([]byte, 0x8000)}
+	cdl.Prg[0x3F80] = rom.UP_DATA
+	report = Prg(r, Options{Cdl: cdl})
+	if len(report.Ranges) != 3 || report.Ranges[0].End != 0x3F7F || report.Ranges[1].Start != 0x3F81 {
+		t.Errorf("CDL wasn't used: %v", report.Ranges)
+	}
+}
+
+func TestChr(t *testing.T) {
+	r := testRom()
+	report, err := Chr(r, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Ranges) != 1 || report.Ranges[0].Start != 0x20 || report.Ranges[0].End != 0x4F {
+		t.Fatalf("Bad ranges: %v", report.Ranges)
+	}
+
+	cdl := &rom.Cdl{Chr: make([]byte, 0x2000)}
+	cdl.Chr[0x35] = rom.UC_READ
+	report, err = Chr(r, Options{Cdl: cdl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Ranges) != 2 || report.Free != 0x20 {
+		t.Errorf("CDL wasn't used: %v", report.Ranges)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/zorchenhimer/go-nes
 
-go 1.12
+go 1.16
 
 require (
 	github.com/alexflint/go-arg v1.4.2 // indirect
@@ -0,0 +1,246 @@
+// Package mapper describes how common mappers lay out PRG and CHR banks in
+// the CPU and PPU address space.  It is used to translate between file
+// offsets and (bank, address) pairs.
+//
+// Only the layout is modeled, not the registers.  Switchable windows can hold
+// any bank, so a file offset will usually have more than one candidate
+// address.  Candidates are ordered with the most likely one first.
+package mapper
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// BANK_NONE is used for switchable windows that don't have a usual bank.
+const BANK_NONE int = math.MinInt32
+
+// Window is a range of CPU or PPU address space that banks are mapped into.
+type Window struct {
+	Address    uint16
+	Size       uint
+	Switchable bool
+
+	// Bank is the bank that is always mapped into a fixed window, or the
+	// bank that is usually mapped into a switchable one.  Negative values
+	// count back from the last bank (-1 is the last bank).
+	Bank int
+}
+
+func fixed(address uint16, size uint, bank int) Window {
+	return Window{Address: address, Size: size, Bank: bank}
+}
+
+func switchable(address uint16, size uint) Window {
+	return Window{Address: address, Size: size, Switchable: true, Bank: BANK_NONE}
+}
+
+// switchableAt is a switchable window with a bank that is mapped at power on
+// or in the mode most games use.
+func switchableAt(address uint16, size uint, bank int) Window {
+	return Window{Address: address, Size: size, Switchable: true, Bank: bank}
+}
+
+// Contains returns true if the address is inside the window.
+func (w Window) Contains(address uint16) bool {
+	return uint(address) >= uint(w.Address) && uint(address) < uint(w.Address)+w.Size
+}
+
+// bank resolves the window's bank for the given bank count.  False is
+// returned if the window has no bank or the bank doesn't exist.
+func (w Window) bank(count uint) (uint, bool) {
+	if w.Bank == BANK_NONE || count == 0 {
+		return 0, false
+	}
+
+	bank := w.Bank
+	if bank < 0 {
+		bank += int(count)
+	}
+
+	if bank < 0 || bank >= int(count) {
+		return 0, false
+	}
+	return uint(bank), true
+}
+
+// Mapper describes the PRG and CHR windows of a mapper.  CHR windows are
+// empty if the mapper only uses CHR RAM.
+type Mapper struct {
+	Number uint
+	Name   string
+
+	Prg []Window
+	Chr []Window
+}
+
+// Lookup returns the description of a mapper.  An error is returned if the
+// mapper isn't known.
+func Lookup(number uint) (*Mapper, error) {
+	m, ok := mappers[number]
+	if !ok {
+		return nil, fmt.Errorf("Mapper %d is not supported", number)
+	}
+	return m, nil
+}
+
+// Location is a bank and the address a byte in it is mapped to.  Bank numbers
+// are in units of BankSize.
+type Location struct {
+	Bank     uint
+	BankSize uint
+	Address  uint16
+
+	// Fixed is true if the bank is always mapped at this address, or
+	// usually mapped for switchable windows.
+	Fixed bool
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%02X:%04X", l.Bank, l.Address)
+}
+
+// Layout is a mapper with known PRG and CHR ROM sizes.
+type Layout struct {
+	*Mapper
+
+	PrgSize uint
+	ChrSize uint // zero for CHR RAM
+}
+
+// New returns the layout for a mapper with the given ROM sizes in bytes.
+func New(number uint, prgSize, chrSize uint) (*Layout, error) {
+	m, err := Lookup(number)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Layout{Mapper: m, PrgSize: prgSize, ChrSize: chrSize}, nil
+}
+
+// FromRom returns the layout for a ROM.
+func FromRom(r rom.Rom) (*Layout, error) {
+	return New(r.MapperNumber(), r.PrgSize(), r.ChrSize())
+}
+
+// PrgBankSize returns the smallest PRG window size.
+func (l *Layout) PrgBankSize() uint {
+	return smallest(l.Prg)
+}
+
+// ChrBankSize returns the smallest CHR window size, or zero if the mapper has
+// no CHR ROM.
+func (l *Layout) ChrBankSize() uint {
+	if l.ChrSize == 0 {
+		return 0
+	}
+	return smallest(l.Chr)
+}
+
+// PrgLocations returns the candidate banks and CPU addresses for an offset
+// into PRG ROM.  Offsets do not include the header or trainer.
+func (l *Layout) PrgLocations(offset uint) []Location {
+	return locations(l.Prg, l.PrgSize, offset)
+}
+
+// ChrLocations returns the candidate banks and PPU addresses for an offset
+// into CHR ROM.
+func (l *Layout) ChrLocations(offset uint) []Location {
+	return locations(l.Chr, l.ChrSize, offset)
+}
+
+// PrgOffset returns the offset into PRG ROM for a bank mapped at the given
+// CPU address.  The bank number is in units of the size of the window that
+// contains the address.
+func (l *Layout) PrgOffset(bank uint, address uint16) (uint, error) {
+	return offset(l.Prg, l.PrgSize, bank, address)
+}
+
+// PrgFixedBank returns the bank that is always mapped at a CPU address.
+// False is returned if the address is in a switchable window or has no ROM.
+func (l *Layout) PrgFixedBank(address uint16) (uint, bool) {
+	for _, w := range l.Prg {
+		if w.Contains(address) && !w.Switchable {
+			return w.bank(bankCount(w, l.PrgSize))
+		}
+	}
+	return 0, false
+}
+
+// ChrOffset returns the offset into CHR ROM for a bank mapped at the given
+// PPU address.
+func (l *Layout) ChrOffset(bank uint, address uint16) (uint, error) {
+	return offset(l.Chr, l.ChrSize, bank, address)
+}
+
+func smallest(windows []Window) uint {
+	var size uint
+	for _, w := range windows {
+		if size == 0 || w.Size < size {
+			size = w.Size
+		}
+	}
+	return size
+}
+
+// bankCount returns the number of banks the window size can select.  ROMs
+// smaller than the window are mirrored and count as a single bank.
+func bankCount(w Window, romSize uint) uint {
+	return (romSize + w.Size - 1) / w.Size
+}
+
+func locations(windows []Window, romSize, offset uint) []Location {
+	if offset >= romSize {
+		return nil
+	}
+
+	usual := []Location{}
+	other := []Location{}
+	for _, w := range windows {
+		count := bankCount(w, romSize)
+		bank := offset / w.Size
+		loc := Location{
+			Bank:     bank,
+			BankSize: w.Size,
+			Address:  w.Address + uint16(offset%w.Size),
+		}
+
+		fixedBank, ok := w.bank(count)
+		switch {
+		case ok && fixedBank == bank:
+			loc.Fixed = true
+			usual = append(usual, loc)
+		case w.Switchable:
+			other = append(other, loc)
+		}
+	}
+
+	return append(usual, other...)
+}
+
+func offset(windows []Window, romSize, bank uint, address uint16) (uint, error) {
+	for _, w := range windows {
+		if !w.Contains(address) {
+			continue
+		}
+
+		count := bankCount(w, romSize)
+		if bank >= count {
+			return 0, fmt.Errorf("Bank %d out of range at $%04X: %d banks of %d bytes", bank, address, count, w.Size)
+		}
+
+		if !w.Switchable {
+			if fixedBank, ok := w.bank(count); !ok || fixedBank != bank {
+				return 0, fmt.Errorf("Bank %d cannot be mapped at $%04X", bank, address)
+			}
+		}
+
+		// Small ROMs are mirrored across the window.
+		within := uint(address-w.Address) % romSize
+		return bank*w.Size + within, nil
+	}
+
+	return 0, fmt.Errorf("No ROM is mapped at $%04X", address)
+}
@@ -0,0 +1,77 @@
+package mapper
+
+import (
+	"testing"
+)
+
+func TestPrgLocations(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapper  uint
+		prgSize uint
+		offset  uint
+		want    []string
+	}{
+		{"nrom-128", 0, 0x4000, 0x0123, []string{"00:8123", "00:C123"}},
+		{"nrom-256", 0, 0x8000, 0x4123, []string{"01:C123"}},
+		{"uxrom first", 2, 0x20000, 0x0010, []string{"00:8010"}},
+		{"uxrom last", 2, 0x20000, 0x1C010, []string{"07:C010", "07:8010"}},
+		{"mmc1 last", 1, 0x40000, 0x3FFFC, []string{"0F:FFFC", "0F:BFFC"}},
+		{"mmc3 last", 4, 0x40000, 0x3E000, []string{"1F:E000", "1F:8000", "1F:A000", "1F:C000"}},
+		{"mmc3 second to last", 4, 0x40000, 0x3C001, []string{"1E:C001", "1E:8001", "1E:A001"}},
+		{"vrc6", 24, 0x40000, 0x2000, []string{"00:A000", "01:C000"}},
+		{"axrom", 7, 0x40000, 0x18000, []string{"03:8000"}},
+		{"out of range", 0, 0x4000, 0x4000, []string{}},
+	}
+
+	for _, tt := range tests {
+		l, err := New(tt.mapper, tt.prgSize, 0)
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.name, err)
+		}
+
+		locs := l.PrgLocations(tt.offset)
+		if len(locs) != len(tt.want) {
+			t.Errorf("[%s] expected %v, got %v", tt.name, tt.want, locs)
+			continue
+		}
+
+		for i, loc := range locs {
+			if loc.String() != tt.want[i] {
+				t.Errorf("[%s] expected %v, got %v", tt.name, tt.want, locs)
+				break
+			}
+
+			// Every candidate should map back to the same offset.
+			off, err := l.PrgOffset(loc.Bank, loc.Address)
+			if err != nil {
+				t.Errorf("[%s] %s: %v", tt.name, loc, err)
+			} else if off != tt.offset {
+				t.Errorf("[%s] %s: offset $%X, expected $%X", tt.name, loc, off, tt.offset)
+			}
+		}
+	}
+}
+
+func TestPrgOffsetErrors(t *testing.T) {
+	l, err := New(2, 0x20000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.PrgOffset(3, 0xC000); err == nil {
+		t.Errorf("Expected an error for a bank in the fixed window")
+	}
+
+	if _, err := l.PrgOffset(8, 0x8000); err == nil {
+		t.Errorf("Expected an error for a bank that doesn't exist")
+	}
+
+	if _, err := l.PrgOffset(0, 0x6000); err == nil {
+		t.Errorf("Expected an error for an address without ROM")
+	}
+
+	if _, err := New(1000, 0x8000, 0); err == nil {
+		t.Errorf("Expected an error for an unknown mapper")
+	}
+}
@@ -0,0 +1,178 @@
+package mapper
+
+const (
+	k1  uint = 0x0400
+	k4  uint = 0x1000
+	k8  uint = 0x2000
+	k16 uint = 0x4000
+	k32 uint = 0x8000
+)
+
+// Eight switchable 1k CHR windows.
+var chr1k = []Window{
+	switchable(0x0000, k1), switchable(0x0400, k1), switchable(0x0800, k1), switchable(0x0C00, k1),
+	switchable(0x1000, k1), switchable(0x1400, k1), switchable(0x1800, k1), switchable(0x1C00, k1),
+}
+
+// Two switchable 4k CHR windows.
+var chr4k = []Window{
+	switchable(0x0000, k4), switchable(0x1000, k4),
+}
+
+// Fixed 8k of CHR.  Usually RAM.
+var chrFixed = []Window{
+	fixed(0x0000, k8, 0),
+}
+
+// 8k PRG banks with the last one fixed at $E000 and the second to last usually
+// at $C000.  Some of these can swap $8000 and $C000, which makes every window
+// but $E000 switchable.
+var prg8kSwap = []Window{
+	switchable(0x8000, k8),
+	switchable(0xA000, k8),
+	switchableAt(0xC000, k8, -2),
+	fixed(0xE000, k8, -1),
+}
+
+// 16k switchable at $8000 and the last 16k fixed at $C000.
+var prg16kFixedLast = []Window{
+	switchableAt(0x8000, k16, 0),
+	fixed(0xC000, k16, -1),
+}
+
+// NROM sized PRG.  16k ROMs are mirrored.
+var prgNrom = []Window{
+	fixed(0x8000, k16, 0),
+	fixed(0xC000, k16, -1),
+}
+
+// VRC2 and VRC4.  VRC4 has the same swap mode as MMC3.
+var vrc24 = &Mapper{Name: "VRC2/VRC4", Prg: prg8kSwap, Chr: chr1k}
+
+// VRC6 mixes a 16k and an 8k window.
+var vrc6 = &Mapper{
+	Name: "VRC6",
+	Prg: []Window{
+		switchableAt(0x8000, k16, 0),
+		switchable(0xC000, k8),
+		fixed(0xE000, k8, -1),
+	},
+	Chr: chr1k,
+}
+
+var mappers = map[uint]*Mapper{
+	0: &Mapper{Name: "NROM", Prg: prgNrom, Chr: chrFixed},
+
+	// MMC1 can fix either 16k window or switch 32k at a time.  Most games
+	// keep the last bank at $C000.  The outer bank of SUROM and SXROM is not
+	// modeled.
+	1: &Mapper{
+		Name: "MMC1",
+		Prg: []Window{
+			switchableAt(0x8000, k16, 0),
+			switchableAt(0xC000, k16, -1),
+		},
+		Chr: chr4k,
+	},
+
+	2: &Mapper{Name: "UxROM", Prg: prg16kFixedLast, Chr: chrFixed},
+
+	3: &Mapper{
+		Name: "CNROM",
+		Prg:  prgNrom,
+		Chr:  []Window{switchableAt(0x0000, k8, 0)},
+	},
+
+	4: &Mapper{Name: "MMC3", Prg: prg8kSwap, Chr: chr1k},
+
+	// MMC5 powers on in 8k mode with the last bank at $E000.  $E000 is
+	// always ROM, but it can still be switched.
+	5: &Mapper{
+		Name: "MMC5",
+		Prg: []Window{
+			switchable(0x8000, k8),
+			switchable(0xA000, k8),
+			switchable(0xC000, k8),
+			switchableAt(0xE000, k8, -1),
+		},
+		Chr: chr1k,
+	},
+
+	7: &Mapper{
+		Name: "AxROM",
+		Prg:  []Window{switchableAt(0x8000, k32, 0)},
+		Chr:  chrFixed,
+	},
+
+	9: &Mapper{
+		Name: "MMC2",
+		Prg: []Window{
+			switchableAt(0x8000, k8, 0),
+			fixed(0xA000, k8, -3),
+			fixed(0xC000, k8, -2),
+			fixed(0xE000, k8, -1),
+		},
+		Chr: chr4k,
+	},
+
+	10: &Mapper{Name: "MMC4", Prg: prg16kFixedLast, Chr: chr4k},
+
+	21: vrc24,
+	22: vrc24,
+	23: vrc24,
+	24: vrc6,
+	25: vrc24,
+	26: vrc6,
+
+	66: &Mapper{
+		Name: "GxROM",
+		Prg:  []Window{switchableAt(0x8000, k32, 0)},
+		Chr:  []Window{switchableAt(0x0000, k8, 0)},
+	},
+
+	// FME-7 can map ROM or RAM at $6000.
+	69: &Mapper{
+		Name: "FME-7",
+		Prg: []Window{
+			switchable(0x8000, k8),
+			switchable(0xA000, k8),
+			switchable(0xC000, k8),
+			fixed(0xE000, k8, -1),
+			switchable(0x6000, k8),
+		},
+		Chr: chr1k,
+	},
+
+	73: &Mapper{Name: "VRC3", Prg: prg16kFixedLast, Chr: chrFixed},
+
+	75: &Mapper{
+		Name: "VRC1",
+		Prg: []Window{
+			switchable(0x8000, k8),
+			switchable(0xA000, k8),
+			switchable(0xC000, k8),
+			fixed(0xE000, k8, -1),
+		},
+		Chr: chr4k,
+	},
+
+	85: &Mapper{
+		Name: "VRC7",
+		Prg: []Window{
+			switchable(0x8000, k8),
+			switchable(0xA000, k8),
+			switchable(0xC000, k8),
+			fixed(0xE000, k8, -1),
+		},
+		Chr: chr1k,
+	},
+}
+
+// Fill in the numbers.  Some descriptions are shared between mappers.
+func init() {
+	for num, m := range mappers {
+		cp := *m
+		cp.Number = num
+		mappers[num] = &cp
+	}
+}
@@ -0,0 +1,193 @@
+package nsf
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Format string
+
+const (
+	NSF  Format = "NSF"
+	NSFE Format = "NSFe"
+)
+
+// Region is the PAL/NTSC byte.
+type Region uint8
+
+const (
+	R_NTSC Region = 0x00
+	R_PAL  Region = 0x01
+	R_DUAL Region = 0x02
+)
+
+func (r Region) String() string {
+	if r&0x02 != 0 {
+		return "Dual PAL/NTSC"
+	}
+
+	if r&0x01 != 0 {
+		return "PAL"
+	}
+
+	return "NTSC"
+}
+
+// ExpansionAudio holds the extra sound chip flags.
+type ExpansionAudio uint8
+
+const (
+	EA_VRC6   ExpansionAudio = 0x01
+	EA_VRC7   ExpansionAudio = 0x02
+	EA_FDS    ExpansionAudio = 0x04
+	EA_MMC5   ExpansionAudio = 0x08
+	EA_N163   ExpansionAudio = 0x10
+	EA_S5B    ExpansionAudio = 0x20
+	EA_VT02   ExpansionAudio = 0x40
+	EA_UNUSED ExpansionAudio = 0x80
+)
+
+var expansionNames = []string{"VRC6", "VRC7", "FDS", "MMC5", "Namco 163", "Sunsoft 5B", "VT02+", "Unknown"}
+
+func (ea ExpansionAudio) String() string {
+	if ea == 0 {
+		return "None"
+	}
+
+	names := []string{}
+	for i, name := range expansionNames {
+		if ea&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// NSF2 flags in byte $7C of the header
+const (
+	F2_IRQ           uint8 = 0x10
+	F2_NONRETURNINIT uint8 = 0x20
+	F2_SUPPRESSPLAY  uint8 = 0x40
+	F2_METADATAREQ   uint8 = 0x80
+)
+
+// Nsf holds a decoded NSF, NSF2, or NSFe file.
+type Nsf struct {
+	Format  Format
+	Version uint8 // NSF header version.  Zero for NSFe.
+
+	TotalSongs   uint8
+	StartingSong uint8 // One-based
+
+	LoadAddress uint16
+	InitAddress uint16
+	PlayAddress uint16
+
+	SongName  string
+	Artist    string
+	Copyright string
+	Ripper    string `json:",omitempty"`
+
+	// Play speed in 1/1000000th second ticks
+	NtscSpeed  uint16
+	PalSpeed   uint16
+	DendySpeed uint16 `json:",omitempty"`
+
+	Bankswitch     [8]uint8
+	Region         Region
+	ExpansionAudio ExpansionAudio
+	Nsf2Flags      uint8
+
+	// Values from the tlbl, time, fade, and plst chunks.  Times are in
+	// milliseconds.  A negative value means the time isn't known.
+	TrackLabels []string `json:",omitempty"`
+	TrackTimes  []int32  `json:",omitempty"`
+	TrackFades  []int32  `json:",omitempty"`
+	Playlist    []uint8  `json:",omitempty"`
+
+	// Chunk order for NSFe and NSF2 metadata.  Used to write chunks back in
+	// the same order they were read.
+	ChunkOrder []string `json:",omitempty"`
+
+	// Chunks that aren't decoded.  These are written back out as-is.
+	UnknownChunks map[string][]byte `json:",omitempty"`
+
+	Data []byte `json:"-"` // program data
+}
+
+// IsBankswitched returns true if any of the bankswitch init values are
+// non-zero.
+func (n *Nsf) IsBankswitched() bool {
+	for _, b := range n.Bankswitch {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// BankPadding returns the number of bytes that come before the program data
+// in the first bank.  This is only non-zero for bankswitched files.
+func (n *Nsf) BankPadding() int {
+	if !n.IsBankswitched() {
+		return 0
+	}
+	return int(n.LoadAddress & 0x0FFF)
+}
+
+// Banks splits the program data into banks of the given size.  For
+// bankswitched files the first bank is padded so the data lines up with the
+// load address.  The last bank may be short.
+func (n *Nsf) Banks(size int) [][]byte {
+	data := append(make([]byte, n.BankPadding()), n.Data...)
+
+	banks := [][]byte{}
+	for start := 0; start < len(data); start += size {
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		banks = append(banks, data[start:end])
+	}
+	return banks
+}
+
+func (n *Nsf) Debug() string {
+	return fmt.Sprintf(`%s:
+	Version: %d
+	TotalSongs: %d
+	StartingSong: %d
+	LoadAddress: $%04X
+	InitAddress: $%04X
+	PlayAddress: $%04X
+	SongName: %s
+	Artist: %s
+	Copyright: %s
+	Ripper: %s
+	NtscSpeed: %d
+	PalSpeed: %d
+	Bankswitch: % X
+	Region: %s
+	ExpansionAudio: %s
+	Nsf2Flags: %02X
+	DataSize: %d`,
+		n.Format,
+		n.Version,
+		n.TotalSongs,
+		n.StartingSong,
+		n.LoadAddress,
+		n.InitAddress,
+		n.PlayAddress,
+		n.SongName,
+		n.Artist,
+		n.Copyright,
+		n.Ripper,
+		n.NtscSpeed,
+		n.PalSpeed,
+		n.Bankswitch[:],
+		n.Region,
+		n.ExpansionAudio,
+		n.Nsf2Flags,
+		len(n.Data),
+	)
+}
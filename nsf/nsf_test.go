@@ -0,0 +1,152 @@
+package nsf
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testNsf(format Format, version uint8) *Nsf {
+	return &Nsf{
+		Format:       format,
+		Version:      version,
+		TotalSongs:   3,
+		StartingSong: 2,
+		LoadAddress:  0x8123,
+		InitAddress:  0x8200,
+		PlayAddress:  0x8300,
+		SongName:     "Test Song",
+		Artist:       "Test Artist",
+		Copyright:    "2024 Nobody",
+		Ripper:       "Ripper",
+		NtscSpeed:    16639,
+		PalSpeed:     19997,
+		Bankswitch:   [8]uint8{0, 1, 2, 3, 4, 5, 6, 7},
+		Region:       R_DUAL,
+		TrackLabels:  []string{"One", "Two", "Three"},
+		TrackTimes:   []int32{60000, -1, 125000},
+		Data:         bytes.Repeat([]byte{0xEA}, 0x2345),
+	}
+}
+
+// Write each format, read it back, and write it again.
+func TestNsfRecode(t *testing.T) {
+	tests := []struct {
+		format  Format
+		version uint8
+	}{
+		{NSF, 1},
+		{NSF, 2},
+		{NSFE, 0},
+	}
+
+	for _, tt := range tests {
+		orig := testNsf(tt.format, tt.version)
+		if tt.version == 1 {
+			orig.Ripper = ""
+			orig.TrackLabels = nil
+			orig.TrackTimes = nil
+		}
+
+		first, err := orig.Bytes()
+		if err != nil {
+			t.Fatalf("[%s v%d] %v", tt.format, tt.version, err)
+		}
+
+		n, err := Read(bytes.NewReader(first))
+		if err != nil {
+			t.Fatalf("[%s v%d] %v", tt.format, tt.version, err)
+		}
+
+		if n.Format != tt.format || n.StartingSong != 2 || n.LoadAddress != 0x8123 || n.Artist != "Test Artist" {
+			t.Errorf("[%s v%d] field mismatch:\n%s", tt.format, tt.version, n.Debug())
+		}
+
+		if !bytes.Equal(n.Data, orig.Data) {
+			t.Errorf("[%s v%d] data mismatch", tt.format, tt.version)
+		}
+
+		if tt.version != 1 && (len(n.TrackTimes) != 3 || n.TrackTimes[1] != -1 || n.Ripper != "Ripper") {
+			t.Errorf("[%s v%d] metadata mismatch: %v %q", tt.format, tt.version, n.TrackTimes, n.Ripper)
+		}
+
+		second, err := n.Bytes()
+		if err != nil {
+			t.Fatalf("[%s v%d] %v", tt.format, tt.version, err)
+		}
+
+		if !bytes.Equal(first, second) {
+			t.Errorf("[%s v%d] second write does not match the first", tt.format, tt.version)
+		}
+	}
+}
+
+func TestNsfBanks(t *testing.T) {
+	n := testNsf(NSF, 1)
+
+	banks := n.Banks(0x1000)
+	if len(banks) != 3 {
+		t.Fatalf("Expected 3 banks, found %d", len(banks))
+	}
+
+	if len(banks[0]) != 0x1000 || banks[0][0x122] != 0x00 || banks[0][0x123] != 0xEA {
+		t.Errorf("First bank padding is wrong")
+	}
+
+	if len(banks[2]) != 0x468 {
+		t.Errorf("Last bank length is wrong: %X", len(banks[2]))
+	}
+}
+
+// Header strings that fill the whole field have no NUL terminator.
+func TestNsfLongStrings(t *testing.T) {
+	n := testNsf(NSF, 1)
+	n.Ripper = ""
+	n.TrackLabels = nil
+	n.TrackTimes = nil
+	n.SongName = "0123456789ABCDEF0123456789ABCDEF"
+
+	raw, err := n.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := Read(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if read.SongName != n.SongName || read.Artist != n.Artist {
+		t.Errorf("String mismatch: %q %q", read.SongName, read.Artist)
+	}
+
+	// NSF2 files don't need an auth chunk for them either.
+	n.Version = 2
+	first, err := n.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains(first, []byte("auth")) {
+		t.Errorf("Unexpected auth chunk for a 32 byte song name")
+	}
+
+	read, err = Read(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := read.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first, second) {
+		t.Errorf("Second NSF2 write does not match the first")
+	}
+
+	n.Version = 1
+	n.SongName += "!"
+	if _, err = n.Bytes(); err == nil {
+		t.Errorf("Expected an error for a 33 byte song name")
+	}
+}
@@ -0,0 +1,226 @@
+package nsf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	nsfMagic  = []byte{0x4E, 0x45, 0x53, 0x4D, 0x1A} // NESM<EOF>
+	nsfeMagic = []byte("NSFE")
+)
+
+const headerSize = 0x80
+
+func ReadFile(filename string) (*Nsf, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %q: %w", filename, err)
+	}
+	defer file.Close()
+
+	return Read(file)
+}
+
+// Read decodes an NSF, NSF2, or NSFe file.
+func Read(r io.Reader) (*Nsf, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading NSF: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(raw, nsfMagic):
+		return readNsf(raw)
+	case bytes.HasPrefix(raw, nsfeMagic):
+		n := &Nsf{Format: NSFE}
+		err = n.readChunks(raw[4:], true)
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+
+	return nil, fmt.Errorf("Not an NSF or NSFe file")
+}
+
+func readNsf(raw []byte) (*Nsf, error) {
+	if len(raw) < headerSize {
+		return nil, fmt.Errorf("NSF header too short: %d bytes", len(raw))
+	}
+
+	n := &Nsf{
+		Format:         NSF,
+		Version:        raw[0x05],
+		TotalSongs:     raw[0x06],
+		StartingSong:   raw[0x07],
+		LoadAddress:    binary.LittleEndian.Uint16(raw[0x08:]),
+		InitAddress:    binary.LittleEndian.Uint16(raw[0x0A:]),
+		PlayAddress:    binary.LittleEndian.Uint16(raw[0x0C:]),
+		SongName:       headerString(raw[0x0E:0x2E]),
+		Artist:         headerString(raw[0x2E:0x4E]),
+		Copyright:      headerString(raw[0x4E:0x6E]),
+		NtscSpeed:      binary.LittleEndian.Uint16(raw[0x6E:]),
+		PalSpeed:       binary.LittleEndian.Uint16(raw[0x78:]),
+		Region:         Region(raw[0x7A]),
+		ExpansionAudio: ExpansionAudio(raw[0x7B]),
+		Nsf2Flags:      raw[0x7C],
+	}
+	copy(n.Bankswitch[:], raw[0x70:0x78])
+
+	dataLen := int(raw[0x7D]) | int(raw[0x7E])<<8 | int(raw[0x7F])<<16
+	if n.Version < 2 || dataLen == 0 {
+		n.Data = raw[headerSize:]
+		return n, nil
+	}
+
+	if headerSize+dataLen > len(raw) {
+		return nil, fmt.Errorf("Program data length too large: %d", dataLen)
+	}
+
+	n.Data = raw[headerSize : headerSize+dataLen]
+	err := n.readChunks(raw[headerSize+dataLen:], false)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading NSF2 metadata: %w", err)
+	}
+
+	return n, nil
+}
+
+// headerString decodes a fixed length, NUL padded string.
+func headerString(raw []byte) string {
+	if idx := bytes.IndexByte(raw, 0x00); idx >= 0 {
+		raw = raw[:idx]
+	}
+	return string(raw)
+}
+
+// splitStrings splits NUL terminated strings.
+func splitStrings(raw []byte) []string {
+	strs := []string{}
+	for len(raw) > 0 {
+		idx := bytes.IndexByte(raw, 0x00)
+		if idx < 0 {
+			strs = append(strs, string(raw))
+			break
+		}
+		strs = append(strs, string(raw[:idx]))
+		raw = raw[idx+1:]
+	}
+	return strs
+}
+
+func readInt32s(raw []byte) []int32 {
+	vals := []int32{}
+	for i := 0; i+4 <= len(raw); i += 4 {
+		vals = append(vals, int32(binary.LittleEndian.Uint32(raw[i:])))
+	}
+	return vals
+}
+
+// readChunks reads NSFe chunks until NEND or the end of the data.
+func (n *Nsf) readChunks(raw []byte, nsfe bool) error {
+	foundInfo := false
+	foundData := false
+
+	for len(raw) >= 8 {
+		length := int(binary.LittleEndian.Uint32(raw))
+		id := string(raw[4:8])
+		raw = raw[8:]
+
+		if length > len(raw) {
+			return fmt.Errorf("Chunk %q length too large: %d", id, length)
+		}
+
+		val := raw[:length]
+		raw = raw[length:]
+		n.ChunkOrder = append(n.ChunkOrder, id)
+
+		switch id {
+		case "INFO":
+			if len(val) < 9 {
+				return fmt.Errorf("INFO chunk too short: %d bytes", len(val))
+			}
+			foundInfo = true
+			n.LoadAddress = binary.LittleEndian.Uint16(val[0:])
+			n.InitAddress = binary.LittleEndian.Uint16(val[2:])
+			n.PlayAddress = binary.LittleEndian.Uint16(val[4:])
+			n.Region = Region(val[6])
+			n.ExpansionAudio = ExpansionAudio(val[7])
+			n.TotalSongs = val[8]
+			n.StartingSong = 1
+			if len(val) > 9 {
+				n.StartingSong = val[9] + 1
+			}
+
+		case "DATA":
+			foundData = true
+			n.Data = val
+
+		case "BANK":
+			copy(n.Bankswitch[:], val)
+
+		case "RATE":
+			if len(val) >= 2 {
+				n.NtscSpeed = binary.LittleEndian.Uint16(val[0:])
+			}
+			if len(val) >= 4 {
+				n.PalSpeed = binary.LittleEndian.Uint16(val[2:])
+			}
+			if len(val) >= 6 {
+				n.DendySpeed = binary.LittleEndian.Uint16(val[4:])
+			}
+
+		case "auth":
+			strs := splitStrings(val)
+			fields := []*string{&n.SongName, &n.Artist, &n.Copyright, &n.Ripper}
+			for i := 0; i < len(strs) && i < len(fields); i++ {
+				*fields[i] = strs[i]
+			}
+
+		case "tlbl":
+			n.TrackLabels = splitStrings(val)
+
+		case "time":
+			n.TrackTimes = readInt32s(val)
+
+		case "fade":
+			n.TrackFades = readInt32s(val)
+
+		case "plst":
+			n.Playlist = append([]uint8{}, val...)
+
+		case "NEND":
+			return n.checkChunks(nsfe, foundInfo, foundData)
+
+		default:
+			// Chunks starting with an uppercase letter are required to play
+			// the file correctly.  Keep them regardless.
+			if n.UnknownChunks == nil {
+				n.UnknownChunks = make(map[string][]byte)
+			}
+			n.UnknownChunks[id] = val
+		}
+	}
+
+	return n.checkChunks(nsfe, foundInfo, foundData)
+}
+
+func (n *Nsf) checkChunks(nsfe, foundInfo, foundData bool) error {
+	if !nsfe {
+		return nil
+	}
+
+	if !foundInfo {
+		return fmt.Errorf("Missing INFO chunk")
+	}
+
+	if !foundData {
+		return fmt.Errorf("Missing DATA chunk")
+	}
+
+	return nil
+}
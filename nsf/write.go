@@ -0,0 +1,300 @@
+package nsf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+func (n *Nsf) WriteFile(filename string) error {
+	raw, err := n.Bytes()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, raw, 0666)
+}
+
+func (n *Nsf) WriteTo(w io.Writer) (int64, error) {
+	raw, err := n.Bytes()
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := w.Write(raw)
+	return int64(count), err
+}
+
+// Bytes encodes the file in its Format.
+func (n *Nsf) Bytes() ([]byte, error) {
+	switch n.Format {
+	case NSF:
+		return n.nsfBytes()
+	case NSFE:
+		return n.nsfeBytes()
+	}
+	return nil, fmt.Errorf("Unknown format %q", n.Format)
+}
+
+func (n *Nsf) nsfBytes() ([]byte, error) {
+	header := make([]byte, headerSize)
+	copy(header, nsfMagic)
+
+	header[0x05] = n.Version
+	header[0x06] = n.TotalSongs
+	header[0x07] = n.StartingSong
+	binary.LittleEndian.PutUint16(header[0x08:], n.LoadAddress)
+	binary.LittleEndian.PutUint16(header[0x0A:], n.InitAddress)
+	binary.LittleEndian.PutUint16(header[0x0C:], n.PlayAddress)
+
+	strs := []struct {
+		offset int
+		val    string
+	}{
+		{0x0E, n.SongName},
+		{0x2E, n.Artist},
+		{0x4E, n.Copyright},
+	}
+
+	order := n.chunkOrder(false)
+	hasAuth := false
+	for _, id := range order {
+		if id == "auth" {
+			hasAuth = true
+		}
+	}
+
+	for _, s := range strs {
+		// A full 32 byte field is written without a NUL terminator.  NSF2
+		// files keep the full string in the auth chunk.
+		val := []byte(s.val)
+		if len(val) > 32 {
+			if n.Version < 2 || !hasAuth {
+				return nil, fmt.Errorf("String too long for the NSF header: %q", s.val)
+			}
+			val = val[:32]
+		}
+		copy(header[s.offset:], val)
+	}
+
+	binary.LittleEndian.PutUint16(header[0x6E:], n.NtscSpeed)
+	copy(header[0x70:], n.Bankswitch[:])
+	binary.LittleEndian.PutUint16(header[0x78:], n.PalSpeed)
+	header[0x7A] = uint8(n.Region)
+	header[0x7B] = uint8(n.ExpansionAudio)
+
+	if n.Version < 2 {
+		return append(header, n.Data...), nil
+	}
+
+	header[0x7C] = n.Nsf2Flags
+
+	metadata, err := n.chunkBytes(order)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the NEND chunk.  No metadata.
+	if len(metadata) <= 8 {
+		return append(header, n.Data...), nil
+	}
+
+	if len(n.Data) > 0xFFFFFF {
+		return nil, fmt.Errorf("Program data too large: %d bytes", len(n.Data))
+	}
+
+	header[0x7D] = uint8(len(n.Data))
+	header[0x7E] = uint8(len(n.Data) >> 8)
+	header[0x7F] = uint8(len(n.Data) >> 16)
+
+	raw := append(header, n.Data...)
+	return append(raw, metadata...), nil
+}
+
+func (n *Nsf) nsfeBytes() ([]byte, error) {
+	raw, err := n.chunkBytes(n.chunkOrder(true))
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, nsfeMagic...), raw...), nil
+}
+
+// chunkOrder returns the order chunks should be written in.  Chunks that were
+// read from a file keep their original order.  NEND is always last.
+func (n *Nsf) chunkOrder(nsfe bool) []string {
+	order := []string{}
+	seen := map[string]bool{"NEND": true}
+	add := func(id string) {
+		if seen[id] {
+			return
+		}
+
+		// These are in the NSF header
+		if !nsfe && (id == "INFO" || id == "DATA" || id == "BANK" || id == "RATE") {
+			return
+		}
+
+		seen[id] = true
+		order = append(order, id)
+	}
+
+	for _, id := range n.ChunkOrder {
+		add(id)
+	}
+
+	add("INFO")
+	if n.IsBankswitched() {
+		add("BANK")
+	}
+	if n.NtscSpeed != 0 || n.PalSpeed != 0 || n.DendySpeed != 0 {
+		add("RATE")
+	}
+	add("DATA")
+
+	if n.needsAuth(nsfe) {
+		add("auth")
+	}
+	if len(n.TrackLabels) > 0 {
+		add("tlbl")
+	}
+	if len(n.TrackTimes) > 0 {
+		add("time")
+	}
+	if len(n.TrackFades) > 0 {
+		add("fade")
+	}
+	if len(n.Playlist) > 0 {
+		add("plst")
+	}
+
+	unknown := []string{}
+	for id := range n.UnknownChunks {
+		unknown = append(unknown, id)
+	}
+	sort.Strings(unknown)
+	for _, id := range unknown {
+		add(id)
+	}
+
+	return append(order, "NEND")
+}
+
+// needsAuth returns true if the strings need an auth chunk.  NSF2 files only
+// need one if the strings don't fit in the header.
+func (n *Nsf) needsAuth(nsfe bool) bool {
+	strs := []string{n.SongName, n.Artist, n.Copyright}
+	if n.Ripper != "" {
+		return true
+	}
+
+	for _, s := range strs {
+		if nsfe && s != "" {
+			return true
+		}
+
+		if len(s) > 32 {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Nsf) chunkBytes(order []string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	for _, id := range order {
+		if len(id) != 4 {
+			return nil, fmt.Errorf("Invalid chunk ID %q", id)
+		}
+
+		val, err := n.chunkValue(id)
+		if err != nil {
+			return nil, err
+		}
+
+		length := make([]byte, 4)
+		binary.LittleEndian.PutUint32(length, uint32(len(val)))
+		buf.Write(length)
+		buf.WriteString(id)
+		buf.Write(val)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (n *Nsf) chunkValue(id string) ([]byte, error) {
+	switch id {
+	case "INFO":
+		val := make([]byte, 10)
+		binary.LittleEndian.PutUint16(val[0:], n.LoadAddress)
+		binary.LittleEndian.PutUint16(val[2:], n.InitAddress)
+		binary.LittleEndian.PutUint16(val[4:], n.PlayAddress)
+		val[6] = uint8(n.Region)
+		val[7] = uint8(n.ExpansionAudio)
+		val[8] = n.TotalSongs
+		if n.StartingSong > 0 {
+			val[9] = n.StartingSong - 1
+		}
+		return val, nil
+
+	case "DATA":
+		return n.Data, nil
+
+	case "BANK":
+		return n.Bankswitch[:], nil
+
+	case "RATE":
+		val := make([]byte, 4)
+		binary.LittleEndian.PutUint16(val[0:], n.NtscSpeed)
+		binary.LittleEndian.PutUint16(val[2:], n.PalSpeed)
+		if n.DendySpeed != 0 {
+			val = append(val, uint8(n.DendySpeed), uint8(n.DendySpeed>>8))
+		}
+		return val, nil
+
+	case "auth":
+		return joinStrings([]string{n.SongName, n.Artist, n.Copyright, n.Ripper}), nil
+
+	case "tlbl":
+		return joinStrings(n.TrackLabels), nil
+
+	case "time":
+		return writeInt32s(n.TrackTimes), nil
+
+	case "fade":
+		return writeInt32s(n.TrackFades), nil
+
+	case "plst":
+		return n.Playlist, nil
+
+	case "NEND":
+		return []byte{}, nil
+	}
+
+	if val, ok := n.UnknownChunks[id]; ok {
+		return val, nil
+	}
+
+	return nil, fmt.Errorf("No data for chunk %q", id)
+}
+
+func joinStrings(strs []string) []byte {
+	raw := []byte{}
+	for _, s := range strs {
+		raw = append(raw, []byte(s)...)
+		raw = append(raw, 0x00)
+	}
+	return raw
+}
+
+func writeInt32s(vals []int32) []byte {
+	raw := make([]byte, len(vals)*4)
+	for i, v := range vals {
+		binary.LittleEndian.PutUint32(raw[i*4:], uint32(v))
+	}
+	return raw
+}
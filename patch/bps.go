@@ -0,0 +1,493 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+var bpsMagic = []byte("BPS1")
+
+type BpsCommand uint8
+
+const (
+	BPS_SOURCEREAD BpsCommand = iota
+	BPS_TARGETREAD
+	BPS_SOURCECOPY
+	BPS_TARGETCOPY
+)
+
+func (c BpsCommand) String() string {
+	switch c {
+	case BPS_SOURCEREAD:
+		return "SourceRead"
+	case BPS_TARGETREAD:
+		return "TargetRead"
+	case BPS_SOURCECOPY:
+		return "SourceCopy"
+	case BPS_TARGETCOPY:
+		return "TargetCopy"
+	}
+	return fmt.Sprintf("Unknown command %d", uint8(c))
+}
+
+// BpsAction is a single command in a BPS patch.  Data is only used by
+// TargetRead.  Offset is only used by SourceCopy and TargetCopy and is
+// relative to the end of the previous copy of the same kind.
+type BpsAction struct {
+	Command BpsCommand
+	Length  int
+	Offset  int
+	Data    []byte
+}
+
+// Bps is a decoded BPS (beat) patch.
+type Bps struct {
+	SourceSize int
+	TargetSize int
+	Metadata   []byte
+
+	Actions []BpsAction
+
+	SourceCrc rom.Crc32
+	TargetCrc rom.Crc32
+	PatchCrc  rom.Crc32
+}
+
+const (
+	// Shortest copy that is worth an action.
+	bpsMinMatch = 4
+
+	// Number of earlier positions checked for each hash.
+	bpsMaxChain = 32
+
+	bpsHashBits = 16
+)
+
+func ReadBpsFile(filename string) (*Bps, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %q: %w", filename, err)
+	}
+	return ParseBps(raw)
+}
+
+func ReadBps(r io.Reader) (*Bps, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading BPS patch: %w", err)
+	}
+	return ParseBps(raw)
+}
+
+// ParseBps decodes a BPS patch and verifies the patch CRC.
+func ParseBps(raw []byte) (*Bps, error) {
+	if !bytes.HasPrefix(raw, bpsMagic) {
+		return nil, fmt.Errorf("Not a BPS patch")
+	}
+
+	if len(raw) < len(bpsMagic)+12 {
+		return nil, fmt.Errorf("BPS patch too short")
+	}
+
+	footer := raw[len(raw)-12:]
+	p := &Bps{
+		SourceCrc: rom.Crc32(binary.LittleEndian.Uint32(footer[0:])),
+		TargetCrc: rom.Crc32(binary.LittleEndian.Uint32(footer[4:])),
+		PatchCrc:  rom.Crc32(binary.LittleEndian.Uint32(footer[8:])),
+		Actions:   []BpsAction{},
+	}
+
+	crc := rom.Crc32(crc32.ChecksumIEEE(raw[:len(raw)-4]))
+	if crc != p.PatchCrc {
+		return nil, fmt.Errorf("Patch CRC mismatch: expected %s, found %s", p.PatchCrc.HexString(), crc.HexString())
+	}
+
+	rd := &bpsReader{data: raw[len(bpsMagic) : len(raw)-12]}
+	p.SourceSize = rd.size()
+	p.TargetSize = rd.size()
+	metaSize := int(rd.number())
+	p.Metadata = rd.bytes(metaSize)
+
+	for rd.err == nil && len(rd.data) > 0 {
+		val := rd.number()
+		act := BpsAction{
+			Command: BpsCommand(val & 0x03),
+			Length:  int(val>>2) + 1,
+		}
+
+		switch act.Command {
+		case BPS_TARGETREAD:
+			act.Data = rd.bytes(act.Length)
+		case BPS_SOURCECOPY, BPS_TARGETCOPY:
+			offset := rd.number()
+			act.Offset = int(offset >> 1)
+			if offset&0x01 != 0 {
+				act.Offset = -act.Offset
+			}
+		}
+
+		p.Actions = append(p.Actions, act)
+	}
+
+	if rd.err != nil {
+		return nil, rd.err
+	}
+
+	return p, nil
+}
+
+func (p *Bps) Bytes() ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte{}, bpsMagic...))
+	writeBpsNumber(buf, uint64(p.SourceSize))
+	writeBpsNumber(buf, uint64(p.TargetSize))
+	writeBpsNumber(buf, uint64(len(p.Metadata)))
+	buf.Write(p.Metadata)
+
+	for _, act := range p.Actions {
+		if act.Length < 1 {
+			return nil, fmt.Errorf("Invalid %s length: %d", act.Command, act.Length)
+		}
+
+		writeBpsNumber(buf, uint64(act.Length-1)<<2|uint64(act.Command&0x03))
+
+		switch act.Command {
+		case BPS_TARGETREAD:
+			if len(act.Data) != act.Length {
+				return nil, fmt.Errorf("TargetRead data length mismatch: %d != %d", len(act.Data), act.Length)
+			}
+			buf.Write(act.Data)
+
+		case BPS_SOURCECOPY, BPS_TARGETCOPY:
+			if act.Offset < 0 {
+				writeBpsNumber(buf, uint64(-act.Offset)<<1|1)
+			} else {
+				writeBpsNumber(buf, uint64(act.Offset)<<1)
+			}
+		}
+	}
+
+	footer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(footer[0:], uint32(p.SourceCrc))
+	binary.LittleEndian.PutUint32(footer[4:], uint32(p.TargetCrc))
+	buf.Write(footer)
+
+	p.PatchCrc = rom.Crc32(crc32.ChecksumIEEE(buf.Bytes()))
+	crc := make([]byte, 4)
+	binary.LittleEndian.PutUint32(crc, uint32(p.PatchCrc))
+	buf.Write(crc)
+
+	return buf.Bytes(), nil
+}
+
+// Apply returns a patched copy of source.  The size and CRC of the source and
+// target are checked against the values in the patch.
+func (p *Bps) Apply(source []byte) ([]byte, error) {
+	if len(source) != p.SourceSize {
+		return nil, fmt.Errorf("Source size mismatch: expected %d, found %d", p.SourceSize, len(source))
+	}
+
+	crc := rom.Crc32(crc32.ChecksumIEEE(source))
+	if crc != p.SourceCrc {
+		return nil, fmt.Errorf("Source CRC mismatch: expected %s, found %s", p.SourceCrc.HexString(), crc.HexString())
+	}
+
+	target := make([]byte, p.TargetSize)
+	outOffset := 0
+	sourceRel := 0
+	targetRel := 0
+
+	for _, act := range p.Actions {
+		if act.Length < 1 || outOffset+act.Length > len(target) {
+			return nil, fmt.Errorf("%s at $%X writes past the end of the target", act.Command, outOffset)
+		}
+
+		switch act.Command {
+		case BPS_SOURCEREAD:
+			if outOffset+act.Length > len(source) {
+				return nil, fmt.Errorf("SourceRead at $%X reads past the end of the source", outOffset)
+			}
+			copy(target[outOffset:], source[outOffset:outOffset+act.Length])
+
+		case BPS_TARGETREAD:
+			copy(target[outOffset:], act.Data)
+
+		case BPS_SOURCECOPY:
+			sourceRel += act.Offset
+			if sourceRel < 0 || sourceRel+act.Length > len(source) {
+				return nil, fmt.Errorf("SourceCopy at $%X reads outside the source", outOffset)
+			}
+			copy(target[outOffset:], source[sourceRel:sourceRel+act.Length])
+			sourceRel += act.Length
+
+		case BPS_TARGETCOPY:
+			targetRel += act.Offset
+			if targetRel < 0 || targetRel >= outOffset {
+				return nil, fmt.Errorf("TargetCopy at $%X reads outside the written target", outOffset)
+			}
+
+			// The source and destination can overlap, so copy one byte at
+			// a time.
+			for i := 0; i < act.Length; i++ {
+				target[outOffset+i] = target[targetRel+i]
+			}
+			targetRel += act.Length
+
+		default:
+			return nil, fmt.Errorf("Unknown BPS command: %d", act.Command)
+		}
+
+		outOffset += act.Length
+	}
+
+	if outOffset != len(target) {
+		return nil, fmt.Errorf("Patch only wrote %d of %d target bytes", outOffset, len(target))
+	}
+
+	crc = rom.Crc32(crc32.ChecksumIEEE(target))
+	if crc != p.TargetCrc {
+		return nil, fmt.Errorf("Target CRC mismatch: expected %s, found %s", p.TargetCrc.HexString(), crc.HexString())
+	}
+
+	return target, nil
+}
+
+// CreateBps builds a patch that turns source into target.  Unchanged data is
+// read from the source in place, moved data is copied from the source, and
+// repeated data is copied from earlier in the target.
+func CreateBps(source, target []byte) (*Bps, error) {
+	p := &Bps{
+		SourceSize: len(source),
+		TargetSize: len(target),
+		SourceCrc:  rom.Crc32(crc32.ChecksumIEEE(source)),
+		TargetCrc:  rom.Crc32(crc32.ChecksumIEEE(target)),
+		Actions:    []BpsAction{},
+	}
+
+	sourceIdx := newBpsIndex(source)
+	for i := 0; i+bpsMinMatch <= len(source); i++ {
+		sourceIdx.add(i)
+	}
+	targetIdx := newBpsIndex(target)
+
+	sourceRel := 0
+	targetRel := 0
+	literal := -1 // start of the pending TargetRead
+
+	flush := func(end int) {
+		if literal < 0 {
+			return
+		}
+		p.Actions = append(p.Actions, BpsAction{
+			Command: BPS_TARGETREAD,
+			Length:  end - literal,
+			Data:    target[literal:end],
+		})
+		literal = -1
+	}
+
+	indexed := 0
+	for out := 0; out < len(target); {
+		// Only positions before out can be used for TargetCopy.
+		for ; indexed < out && indexed+bpsMinMatch <= len(target); indexed++ {
+			targetIdx.add(indexed)
+		}
+
+		cmd := BPS_SOURCEREAD
+		length := 0
+		if out < len(source) {
+			length = matchLength(source[out:], target[out:])
+		}
+
+		pos, l := sourceIdx.find(target, out, len(source))
+		if l > length {
+			cmd, length = BPS_SOURCECOPY, l
+		}
+
+		// Target matches can overlap the current position.  Apply copies
+		// these one byte at a time.
+		tpos, l := targetIdx.find(target, out, len(target))
+		if l > length {
+			cmd, length, pos = BPS_TARGETCOPY, l, tpos
+		}
+
+		if length < bpsMinMatch {
+			if literal < 0 {
+				literal = out
+			}
+			out++
+			continue
+		}
+
+		flush(out)
+		act := BpsAction{Command: cmd, Length: length}
+
+		switch cmd {
+		case BPS_SOURCECOPY:
+			act.Offset = pos - sourceRel
+			sourceRel = pos + length
+		case BPS_TARGETCOPY:
+			act.Offset = pos - targetRel
+			targetRel = pos + length
+		}
+
+		p.Actions = append(p.Actions, act)
+		out += length
+	}
+	flush(len(target))
+
+	return p, nil
+}
+
+// CreateBpsRom builds a patch between two ROM files.
+func CreateBpsRom(source, target rom.Rom) (*Bps, error) {
+	src, err := RomBytes(source)
+	if err != nil {
+		return nil, err
+	}
+
+	tgt, err := RomBytes(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return CreateBps(src, tgt)
+}
+
+// bpsIndex is a hash chain of positions in data, keyed on the bytes at each
+// position.
+type bpsIndex struct {
+	data []byte
+	head []int32
+	prev []int32
+}
+
+func newBpsIndex(data []byte) *bpsIndex {
+	idx := &bpsIndex{
+		data: data,
+		head: make([]int32, 1<<bpsHashBits),
+		prev: make([]int32, len(data)),
+	}
+
+	for i := range idx.head {
+		idx.head[i] = -1
+	}
+	return idx
+}
+
+func bpsHash(data []byte) uint32 {
+	val := binary.LittleEndian.Uint32(data)
+	return (val * 2654435761) >> (32 - bpsHashBits)
+}
+
+func (idx *bpsIndex) add(pos int) {
+	h := bpsHash(idx.data[pos:])
+	idx.prev[pos] = idx.head[h]
+	idx.head[h] = int32(pos)
+}
+
+// find returns the position and length of the longest match for target[out:]
+// in data[:limit].
+func (idx *bpsIndex) find(target []byte, out, limit int) (int, int) {
+	if out+bpsMinMatch > len(target) {
+		return 0, 0
+	}
+
+	bestPos, bestLen := 0, 0
+	pos := idx.head[bpsHash(target[out:])]
+	for depth := 0; pos >= 0 && depth < bpsMaxChain; depth++ {
+		l := matchLength(idx.data[pos:limit], target[out:])
+		if l > bestLen {
+			bestPos, bestLen = int(pos), l
+		}
+		pos = idx.prev[pos]
+	}
+
+	return bestPos, bestLen
+}
+
+func matchLength(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+func writeBpsNumber(buf *bytes.Buffer, val uint64) {
+	for {
+		x := byte(val & 0x7F)
+		val >>= 7
+		if val == 0 {
+			buf.WriteByte(0x80 | x)
+			return
+		}
+		buf.WriteByte(x)
+		val--
+	}
+}
+
+type bpsReader struct {
+	data []byte
+	err  error
+}
+
+func (r *bpsReader) number() uint64 {
+	var val uint64
+	var shift uint64 = 1
+
+	for {
+		if len(r.data) == 0 {
+			if r.err == nil {
+				r.err = fmt.Errorf("Unexpected end of BPS patch")
+			}
+			return 0
+		}
+
+		x := r.data[0]
+		r.data = r.data[1:]
+		val += uint64(x&0x7F) * shift
+		if x&0x80 != 0 {
+			return val
+		}
+		shift <<= 7
+		if shift > 1<<56 {
+			if r.err == nil {
+				r.err = fmt.Errorf("Invalid number in BPS patch")
+			}
+			return 0
+		}
+		val += shift
+	}
+}
+
+// size reads a source or target size.
+func (r *bpsReader) size() int {
+	val := r.number()
+	if val > maxSize {
+		if r.err == nil {
+			r.err = fmt.Errorf("Size too large: %d", val)
+		}
+		return 0
+	}
+	return int(val)
+}
+
+func (r *bpsReader) bytes(length int) []byte {
+	if length < 0 || length > len(r.data) {
+		if r.err == nil {
+			r.err = fmt.Errorf("Unexpected end of BPS patch")
+		}
+		r.data = nil
+		return nil
+	}
+
+	val := r.data[:length]
+	r.data = r.data[length:]
+	return val
+}
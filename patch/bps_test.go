@@ -0,0 +1,75 @@
+package patch
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBpsRoundTrip(t *testing.T) {
+	source := testData(512 * 1024)
+
+	target := append([]byte{}, source[:0x1000]...)
+	target = append(target, bytes.Repeat([]byte{0xFF}, 0x800)...) // repeated data
+	target = append(target, source[0x40000:0x48000]...)           // moved data
+	target = append(target, testData(0x100)[:0x100]...)           // copied from the start
+	target = append(target, source[0x1000:]...)
+	target[0x20000] ^= 0x55
+
+	start := time.Now()
+	p, err := CreateBps(source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := p.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseBps(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := parsed.Apply(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Create and apply took %s", elapsed)
+	}
+
+	if !bytes.Equal(out, target) {
+		t.Fatalf("Patched data does not match the target")
+	}
+
+	if len(raw) > 1024 {
+		t.Errorf("Patch is larger than expected: %d bytes", len(raw))
+	}
+
+	// Wrong source
+	bad := append([]byte{}, source...)
+	bad[0] ^= 0xFF
+	if _, err := parsed.Apply(bad); err == nil {
+		t.Errorf("Expected a source CRC error")
+	}
+
+	// Corrupt patch
+	raw[len(raw)/2] ^= 0xFF
+	if _, err := ParseBps(raw); err == nil {
+		t.Errorf("Expected a patch CRC error")
+	}
+}
+
+func TestBpsOversized(t *testing.T) {
+	raw, err := (&Bps{TargetSize: 1 << 62}).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseBps(raw); err == nil {
+		t.Errorf("Expected an error for an oversized target")
+	}
+}
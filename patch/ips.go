@@ -0,0 +1,295 @@
+package patch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+var (
+	ipsMagic = []byte("PATCH")
+	ipsEof   = []byte("EOF")
+)
+
+const (
+	// Offsets are 24-bit and this one reads as "EOF".
+	ipsEofOffset  = 0x454F46
+	ipsMaxOffset  = 0xFFFFFF
+	ipsMaxSize    = 0xFFFF
+	ipsRecordSize = 5
+
+	// Runs of identical bytes at least this long get their own RLE record.
+	ipsRleMin = 16
+)
+
+// IpsRecord is a single change.  If RleSize is non-zero Data is ignored and
+// RleValue is repeated RleSize times.
+type IpsRecord struct {
+	Offset   uint32
+	Data     []byte
+	RleSize  uint16
+	RleValue byte
+}
+
+func (r IpsRecord) Size() int {
+	if r.RleSize > 0 {
+		return int(r.RleSize)
+	}
+	return len(r.Data)
+}
+
+// Ips is a decoded IPS patch.  Truncate is the size of the patched file if
+// the patch uses the truncation extension, otherwise it is zero.
+type Ips struct {
+	Records  []IpsRecord
+	Truncate uint32
+}
+
+func ReadIpsFile(filename string) (*Ips, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %q: %w", filename, err)
+	}
+	return ParseIps(raw)
+}
+
+func ReadIps(r io.Reader) (*Ips, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading IPS patch: %w", err)
+	}
+	return ParseIps(raw)
+}
+
+func ParseIps(raw []byte) (*Ips, error) {
+	if !bytes.HasPrefix(raw, ipsMagic) {
+		return nil, fmt.Errorf("Not an IPS patch")
+	}
+	raw = raw[len(ipsMagic):]
+
+	p := &Ips{Records: []IpsRecord{}}
+	for {
+		if len(raw) < 3 {
+			return nil, fmt.Errorf("Unexpected end of IPS patch")
+		}
+
+		if bytes.Equal(raw[:3], ipsEof) {
+			raw = raw[3:]
+			break
+		}
+
+		if len(raw) < ipsRecordSize {
+			return nil, fmt.Errorf("Unexpected end of IPS patch")
+		}
+
+		rec := IpsRecord{
+			Offset: uint32(raw[0])<<16 | uint32(raw[1])<<8 | uint32(raw[2]),
+		}
+		size := int(raw[3])<<8 | int(raw[4])
+		raw = raw[ipsRecordSize:]
+
+		if size == 0 {
+			if len(raw) < 3 {
+				return nil, fmt.Errorf("Truncated RLE record at offset $%06X", rec.Offset)
+			}
+			rec.RleSize = uint16(raw[0])<<8 | uint16(raw[1])
+			rec.RleValue = raw[2]
+			raw = raw[3:]
+
+		} else {
+			if len(raw) < size {
+				return nil, fmt.Errorf("Truncated record at offset $%06X", rec.Offset)
+			}
+			rec.Data = raw[:size]
+			raw = raw[size:]
+		}
+
+		p.Records = append(p.Records, rec)
+	}
+
+	// Truncation extension
+	if len(raw) >= 3 {
+		p.Truncate = uint32(raw[0])<<16 | uint32(raw[1])<<8 | uint32(raw[2])
+	}
+
+	return p, nil
+}
+
+func (p *Ips) Bytes() ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte{}, ipsMagic...))
+
+	for _, rec := range p.Records {
+		if rec.Offset > ipsMaxOffset {
+			return nil, fmt.Errorf("Record offset too large for IPS: $%X", rec.Offset)
+		}
+
+		if rec.Offset == ipsEofOffset {
+			return nil, fmt.Errorf("Record offset $%06X cannot be used in IPS", rec.Offset)
+		}
+
+		buf.Write([]byte{byte(rec.Offset >> 16), byte(rec.Offset >> 8), byte(rec.Offset)})
+
+		if rec.RleSize > 0 {
+			buf.Write([]byte{0, 0, byte(rec.RleSize >> 8), byte(rec.RleSize), rec.RleValue})
+			continue
+		}
+
+		if len(rec.Data) == 0 || len(rec.Data) > ipsMaxSize {
+			return nil, fmt.Errorf("Invalid record size at offset $%06X: %d", rec.Offset, len(rec.Data))
+		}
+
+		buf.Write([]byte{byte(len(rec.Data) >> 8), byte(len(rec.Data))})
+		buf.Write(rec.Data)
+	}
+
+	buf.Write(ipsEof)
+
+	if p.Truncate > 0 {
+		if p.Truncate > ipsMaxOffset {
+			return nil, fmt.Errorf("Truncate size too large for IPS: %d", p.Truncate)
+		}
+		buf.Write([]byte{byte(p.Truncate >> 16), byte(p.Truncate >> 8), byte(p.Truncate)})
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Apply returns a patched copy of source.  Records past the end of the source
+// grow the output and any gaps are filled with zeros.
+func (p *Ips) Apply(source []byte) ([]byte, error) {
+	out := append([]byte{}, source...)
+
+	for _, rec := range p.Records {
+		end := int(rec.Offset) + rec.Size()
+		if end > len(out) {
+			out = append(out, make([]byte, end-len(out))...)
+		}
+
+		if rec.RleSize > 0 {
+			for i := int(rec.Offset); i < end; i++ {
+				out[i] = rec.RleValue
+			}
+		} else {
+			copy(out[rec.Offset:], rec.Data)
+		}
+	}
+
+	if p.Truncate > 0 {
+		size := int(p.Truncate)
+		if size > len(out) {
+			out = append(out, make([]byte, size-len(out))...)
+		}
+		out = out[:size]
+	}
+
+	return out, nil
+}
+
+// CreateIps builds a patch that turns source into target.  Unchanged gaps
+// shorter than a record header are included in the surrounding record and
+// long runs of a single value are written as RLE records.
+func CreateIps(source, target []byte) (*Ips, error) {
+	if len(target) > ipsMaxOffset+1 {
+		return nil, fmt.Errorf("Target too large for IPS: %d bytes", len(target))
+	}
+
+	p := &Ips{Records: []IpsRecord{}}
+	changed := func(i int) bool {
+		return i >= len(source) || source[i] != target[i]
+	}
+
+	for i := 0; i < len(target); {
+		if !changed(i) {
+			i++
+			continue
+		}
+
+		start := i
+		last := i + 1
+		for j := i + 1; j < len(target) && j-start < ipsMaxSize; j++ {
+			if changed(j) {
+				last = j + 1
+			} else if j-last >= ipsRecordSize {
+				break
+			}
+		}
+
+		p.addRecords(target, start, last)
+		i = last
+	}
+
+	if len(target) < len(source) {
+		p.Truncate = uint32(len(target))
+	}
+
+	return p, nil
+}
+
+// CreateIpsRom builds a patch between two ROM files.
+func CreateIpsRom(source, target rom.Rom) (*Ips, error) {
+	src, err := RomBytes(source)
+	if err != nil {
+		return nil, err
+	}
+
+	tgt, err := RomBytes(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return CreateIps(src, tgt)
+}
+
+// addRecords adds records for target[start:end], splitting out runs of a
+// single value into RLE records.
+func (p *Ips) addRecords(target []byte, start, end int) {
+	for start < end {
+		if start == ipsEofOffset {
+			// Back up a byte so the offset doesn't read as "EOF".  The
+			// previous byte is already the target value.
+			p.Records = append(p.Records, IpsRecord{
+				Offset: uint32(start - 1),
+				Data:   target[start-1 : start+1],
+			})
+			start++
+			continue
+		}
+
+		run := runLength(target[start:end])
+		if run >= ipsRleMin || (run == end-start && run > ipsRecordSize-2) {
+			p.Records = append(p.Records, IpsRecord{
+				Offset:   uint32(start),
+				RleSize:  uint16(run),
+				RleValue: target[start],
+			})
+			start += run
+			continue
+		}
+
+		next := start + run
+		for next < end && runLength(target[next:end]) < ipsRleMin {
+			next++
+		}
+
+		p.Records = append(p.Records, IpsRecord{
+			Offset: uint32(start),
+			Data:   target[start:next],
+		})
+		start = next
+	}
+}
+
+func runLength(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+
+	i := 1
+	for i < len(data) && data[i] == data[0] {
+		i++
+	}
+	return i
+}
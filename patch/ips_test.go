@@ -0,0 +1,77 @@
+package patch
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func testData(size int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, size)
+	rng.Read(data)
+	return data
+}
+
+func checkIps(t *testing.T, name string, source, target []byte) *Ips {
+	p, err := CreateIps(source, target)
+	if err != nil {
+		t.Fatalf("[%s] %v", name, err)
+	}
+
+	raw, err := p.Bytes()
+	if err != nil {
+		t.Fatalf("[%s] %v", name, err)
+	}
+
+	parsed, err := ParseIps(raw)
+	if err != nil {
+		t.Fatalf("[%s] %v", name, err)
+	}
+
+	out, err := parsed.Apply(source)
+	if err != nil {
+		t.Fatalf("[%s] %v", name, err)
+	}
+
+	if !bytes.Equal(out, target) {
+		t.Errorf("[%s] patched data does not match the target", name)
+	}
+
+	return parsed
+}
+
+func TestIpsRoundTrip(t *testing.T) {
+	source := testData(0x8010)
+
+	target := append([]byte{}, source...)
+	target[0x10] ^= 0xFF
+	target[0x14] ^= 0xFF
+	for i := 0x1000; i < 0x1100; i++ {
+		target[i] = 0xFF
+	}
+	p := checkIps(t, "modified", source, target)
+
+	rle := false
+	for _, rec := range p.Records {
+		if rec.RleSize == 0x100 && rec.RleValue == 0xFF {
+			rle = true
+		}
+	}
+	if !rle {
+		t.Errorf("Expected an RLE record")
+	}
+
+	checkIps(t, "extended", source, append(append([]byte{}, source...), testData(0x4000)...))
+
+	p = checkIps(t, "truncated", source, source[:0x4010])
+	if p.Truncate != 0x4010 {
+		t.Errorf("Expected a truncate size of $4010, found $%X", p.Truncate)
+	}
+
+	// A change at an offset that reads as "EOF"
+	source = make([]byte, ipsEofOffset+0x10)
+	target = append([]byte{}, source...)
+	target[ipsEofOffset] = 0x01
+	checkIps(t, "eof offset", source, target)
+}
@@ -0,0 +1,487 @@
+package patch
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// NINJA 2.0 (.rup) patches.  See ninja_filespec20.txt in the root of the
+// repository.
+
+var ninjaMagic = []byte("NINJA2")
+
+const (
+	// The spec says the header and info block make up 1024 bytes, but the
+	// field sizes add up to 2048.  Patches in the wild use 2048.
+	ninjaInfoSize = 0x800
+
+	NINJA_CMD_END  byte = 0x00
+	NINJA_CMD_OPEN byte = 0x01
+	NINJA_CMD_XOR  byte = 0x02
+
+	// Records are split when unchanged gaps are longer than this.
+	ninjaMaxGap = 8
+)
+
+type NinjaEncoding uint8
+
+const (
+	NE_SYSTEM NinjaEncoding = 0 // System codepage
+	NE_UTF8   NinjaEncoding = 1
+)
+
+func (e NinjaEncoding) String() string {
+	switch e {
+	case NE_SYSTEM:
+		return "System codepage"
+	case NE_UTF8:
+		return "UTF-8"
+	}
+	return fmt.Sprintf("Unknown (%d)", uint8(e))
+}
+
+type NinjaFileType uint8
+
+const (
+	NT_RAW NinjaFileType = iota
+	NT_NES
+	NT_FDS
+	NT_SNES
+	NT_N64
+	NT_GB
+	NT_SMS
+	NT_MEGADRIVE
+	NT_PCE
+	NT_LYNX
+)
+
+var ninjaTypeNames = []string{"Raw", "NES", "FDS", "SNES", "N64", "GB", "SMS", "Mega Drive", "PC Engine", "Lynx"}
+
+func (t NinjaFileType) String() string {
+	if int(t) < len(ninjaTypeNames) {
+		return ninjaTypeNames[t]
+	}
+	return fmt.Sprintf("Unknown (%d)", uint8(t))
+}
+
+// Ninja is a decoded NINJA 2.0 patch.  A patch can hold changes for more than
+// one file.
+type Ninja struct {
+	Encoding    NinjaEncoding
+	Author      string
+	Version     string
+	Title       string
+	Genre       string
+	Language    string
+	Date        string // YYYYMMDD
+	Website     string
+	Description string
+
+	Files []*NinjaFile
+}
+
+// NinjaFile holds the changes for a single file.  Name is empty for single
+// file patches.
+type NinjaFile struct {
+	Name         string
+	Type         NinjaFileType
+	SourceSize   int
+	ModifiedSize int
+	SourceMd5    [16]byte
+	ModifiedMd5  [16]byte
+
+	// Data lost from the end of the source ("M") when the modified file is
+	// smaller, or gained at the end ("A") when it is larger.
+	Overflow []byte
+
+	Records []NinjaRecord
+}
+
+type NinjaRecord struct {
+	Offset int
+	Xor    []byte
+}
+
+// Info block fields, in order.
+var ninjaFields = []struct {
+	size int
+	val  func(n *Ninja) *string
+}{
+	{84, func(n *Ninja) *string { return &n.Author }},
+	{11, func(n *Ninja) *string { return &n.Version }},
+	{256, func(n *Ninja) *string { return &n.Title }},
+	{48, func(n *Ninja) *string { return &n.Genre }},
+	{48, func(n *Ninja) *string { return &n.Language }},
+	{8, func(n *Ninja) *string { return &n.Date }},
+	{512, func(n *Ninja) *string { return &n.Website }},
+	{1074, func(n *Ninja) *string { return &n.Description }},
+}
+
+func ReadNinjaFile(filename string) (*Ninja, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %q: %w", filename, err)
+	}
+	return ParseNinja(raw)
+}
+
+func ReadNinja(r io.Reader) (*Ninja, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading NINJA patch: %w", err)
+	}
+	return ParseNinja(raw)
+}
+
+func ParseNinja(raw []byte) (*Ninja, error) {
+	if !bytes.HasPrefix(raw, ninjaMagic) {
+		return nil, fmt.Errorf("Not a NINJA 2.0 patch")
+	}
+
+	if len(raw) < ninjaInfoSize {
+		return nil, fmt.Errorf("NINJA info block too short: %d bytes", len(raw))
+	}
+
+	n := &Ninja{
+		Encoding: NinjaEncoding(raw[len(ninjaMagic)]),
+		Files:    []*NinjaFile{},
+	}
+
+	offset := len(ninjaMagic) + 1
+	for _, field := range ninjaFields {
+		*field.val(n) = headerString(raw[offset : offset+field.size])
+		offset += field.size
+	}
+	n.Description = strings.ReplaceAll(n.Description, `\n`, "\n")
+
+	rd := &ninjaReader{data: raw[ninjaInfoSize:]}
+	var current *NinjaFile
+
+	for rd.err == nil {
+		cmd := rd.byte()
+		if rd.err != nil {
+			return nil, fmt.Errorf("Missing NINJA terminate command")
+		}
+
+		switch cmd {
+		case NINJA_CMD_END:
+			return n, nil
+
+		case NINJA_CMD_OPEN:
+			current = rd.file()
+			if rd.err == nil {
+				n.Files = append(n.Files, current)
+			}
+
+		case NINJA_CMD_XOR:
+			if current == nil {
+				return nil, fmt.Errorf("XOR record before a file is opened")
+			}
+
+			rec := NinjaRecord{Offset: rd.number()}
+			rec.Xor = rd.bytes(rd.number())
+			current.Records = append(current.Records, rec)
+
+		default:
+			return nil, fmt.Errorf("Unknown NINJA command $%02X", cmd)
+		}
+	}
+
+	return nil, rd.err
+}
+
+// headerString decodes a fixed length, NUL padded string.
+func headerString(raw []byte) string {
+	if idx := bytes.IndexByte(raw, 0x00); idx >= 0 {
+		raw = raw[:idx]
+	}
+	return string(raw)
+}
+
+func (n *Ninja) Bytes() ([]byte, error) {
+	info := make([]byte, ninjaInfoSize)
+	copy(info, ninjaMagic)
+	info[len(ninjaMagic)] = uint8(n.Encoding)
+
+	offset := len(ninjaMagic) + 1
+	for _, field := range ninjaFields {
+		val := *field.val(n)
+		if field.size == 1074 {
+			val = strings.ReplaceAll(val, "\n", `\n`)
+		}
+
+		if len(val) > field.size {
+			return nil, fmt.Errorf("Value too long for a %d byte field: %q", field.size, val)
+		}
+		copy(info[offset:], []byte(val))
+		offset += field.size
+	}
+
+	buf := bytes.NewBuffer(info)
+	for _, f := range n.Files {
+		if len(n.Files) > 1 && f.Name == "" {
+			return nil, fmt.Errorf("Files in a multi-file patch need a name")
+		}
+
+		buf.WriteByte(NINJA_CMD_OPEN)
+		if len(n.Files) == 1 && f.Name == "" {
+			buf.WriteByte(0)
+		} else {
+			writeNinjaNumber(buf, len(f.Name))
+			buf.WriteString(f.Name)
+		}
+
+		buf.WriteByte(uint8(f.Type))
+		writeNinjaNumber(buf, f.SourceSize)
+		writeNinjaNumber(buf, f.ModifiedSize)
+		buf.Write(f.SourceMd5[:])
+		buf.Write(f.ModifiedMd5[:])
+
+		if f.SourceSize > f.ModifiedSize {
+			buf.WriteByte('M')
+		} else if f.ModifiedSize > f.SourceSize {
+			buf.WriteByte('A')
+		}
+
+		if f.SourceSize != f.ModifiedSize {
+			writeNinjaNumber(buf, len(f.Overflow))
+			buf.Write(f.Overflow)
+		}
+
+		for _, rec := range f.Records {
+			buf.WriteByte(NINJA_CMD_XOR)
+			writeNinjaNumber(buf, rec.Offset)
+			writeNinjaNumber(buf, len(rec.Xor))
+			buf.Write(rec.Xor)
+		}
+	}
+	buf.WriteByte(NINJA_CMD_END)
+
+	return buf.Bytes(), nil
+}
+
+// FindFile returns the file in the patch with a source MD5 matching source.
+// If the patch only has one file it is returned without checking the MD5.
+func (n *Ninja) FindFile(source []byte) *NinjaFile {
+	if len(n.Files) == 1 {
+		return n.Files[0]
+	}
+
+	sum := md5.Sum(source)
+	for _, f := range n.Files {
+		if f.SourceMd5 == sum {
+			return f
+		}
+	}
+	return nil
+}
+
+// Apply patches the file in the patch that matches source.  Use the Apply
+// method of the individual files to patch a specific file.
+func (n *Ninja) Apply(source []byte) ([]byte, error) {
+	f := n.FindFile(source)
+	if f == nil {
+		return nil, fmt.Errorf("No file in the patch matches the source MD5")
+	}
+	return f.Apply(source)
+}
+
+// Apply returns a patched copy of source.  The size and MD5 of the source and
+// the modified file are checked against the values in the patch.
+func (f *NinjaFile) Apply(source []byte) ([]byte, error) {
+	if len(source) != f.SourceSize {
+		return nil, fmt.Errorf("Source size mismatch: expected %d, found %d", f.SourceSize, len(source))
+	}
+
+	if sum := md5.Sum(source); sum != f.SourceMd5 {
+		return nil, fmt.Errorf("Source MD5 mismatch: expected %x, found %x", f.SourceMd5, sum)
+	}
+
+	if f.ModifiedSize < 0 {
+		return nil, fmt.Errorf("Invalid modified size: %d", f.ModifiedSize)
+	}
+
+	if f.ModifiedSize > f.SourceSize && len(f.Overflow) != f.ModifiedSize-f.SourceSize {
+		return nil, fmt.Errorf("Overflow length mismatch: expected %d, found %d", f.ModifiedSize-f.SourceSize, len(f.Overflow))
+	}
+
+	out := make([]byte, f.ModifiedSize)
+	copy(out, source)
+	if f.ModifiedSize > f.SourceSize {
+		copy(out[f.SourceSize:], f.Overflow)
+	}
+
+	for _, rec := range f.Records {
+		if rec.Offset < 0 || len(rec.Xor) > len(out)-rec.Offset {
+			return nil, fmt.Errorf("XOR record at $%X runs past the end of the file", rec.Offset)
+		}
+
+		for i, x := range rec.Xor {
+			out[rec.Offset+i] ^= x
+		}
+	}
+
+	if sum := md5.Sum(out); sum != f.ModifiedMd5 {
+		return nil, fmt.Errorf("Modified MD5 mismatch: expected %x, found %x", f.ModifiedMd5, sum)
+	}
+
+	return out, nil
+}
+
+// NewNinjaFile builds the changes that turn source into target.  The file
+// type is guessed from the source.
+func NewNinjaFile(name string, source, target []byte) *NinjaFile {
+	f := &NinjaFile{
+		Name:         name,
+		Type:         ninjaFileType(source),
+		SourceSize:   len(source),
+		ModifiedSize: len(target),
+		SourceMd5:    md5.Sum(source),
+		ModifiedMd5:  md5.Sum(target),
+		Records:      []NinjaRecord{},
+	}
+
+	common := len(source)
+	if len(target) < common {
+		common = len(target)
+		f.Overflow = append([]byte{}, source[common:]...)
+	} else if len(target) > common {
+		f.Overflow = append([]byte{}, target[common:]...)
+	}
+
+	for i := 0; i < common; {
+		if source[i] == target[i] {
+			i++
+			continue
+		}
+
+		start := i
+		last := i + 1
+		for j := i + 1; j < common && j-last <= ninjaMaxGap; j++ {
+			if source[j] != target[j] {
+				last = j + 1
+			}
+		}
+
+		rec := NinjaRecord{Offset: start, Xor: make([]byte, last-start)}
+		for k := range rec.Xor {
+			rec.Xor[k] = source[start+k] ^ target[start+k]
+		}
+		f.Records = append(f.Records, rec)
+		i = last
+	}
+
+	return f
+}
+
+// CreateNinja builds a single file patch that turns source into target.
+func CreateNinja(source, target []byte) (*Ninja, error) {
+	return &Ninja{
+		Encoding: NE_UTF8,
+		Files:    []*NinjaFile{NewNinjaFile("", source, target)},
+	}, nil
+}
+
+func ninjaFileType(data []byte) NinjaFileType {
+	switch {
+	case bytes.HasPrefix(data, []byte{0x4E, 0x45, 0x53, 0x1A}), bytes.HasPrefix(data, []byte("UNIF")):
+		return NT_NES
+	case bytes.HasPrefix(data, []byte{0x46, 0x44, 0x53, 0x1A}), bytes.HasPrefix(data, []byte("\x01*NINTENDO-HVC*")):
+		return NT_FDS
+	}
+	return NT_RAW
+}
+
+// Numbers are stored as a byte count followed by that many bytes, little
+// endian.
+func writeNinjaNumber(buf *bytes.Buffer, val int) {
+	raw := []byte{}
+	for ; val > 0; val >>= 8 {
+		raw = append(raw, byte(val))
+	}
+	buf.WriteByte(byte(len(raw)))
+	buf.Write(raw)
+}
+
+type ninjaReader struct {
+	data []byte
+	err  error
+}
+
+func (r *ninjaReader) bytes(length int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if length < 0 || length > len(r.data) {
+		r.err = fmt.Errorf("Unexpected end of NINJA patch")
+		r.data = nil
+		return nil
+	}
+
+	val := r.data[:length]
+	r.data = r.data[length:]
+	return val
+}
+
+func (r *ninjaReader) byte() byte {
+	val := r.bytes(1)
+	if val == nil {
+		return 0
+	}
+	return val[0]
+}
+
+// number reads a byte count followed by a value of that many bytes.
+func (r *ninjaReader) number() int {
+	return r.value(int(r.byte()))
+}
+
+func (r *ninjaReader) value(size int) int {
+	if size > 8 {
+		r.err = fmt.Errorf("Invalid number length in NINJA patch: %d", size)
+		return 0
+	}
+
+	val := 0
+	for i, b := range r.bytes(size) {
+		val |= int(b) << (uint(i) * 8)
+	}
+
+	// Every number is a size or an offset.  Eight byte values can overflow.
+	if val < 0 && r.err == nil {
+		r.err = fmt.Errorf("Invalid number in NINJA patch: %d", val)
+		return 0
+	}
+	return val
+}
+
+func (r *ninjaReader) file() *NinjaFile {
+	f := &NinjaFile{Records: []NinjaRecord{}}
+
+	// A zero length size means this is a single file patch without a name.
+	if size := int(r.byte()); size > 0 {
+		f.Name = string(r.bytes(r.value(size)))
+	}
+
+	f.Type = NinjaFileType(r.byte())
+	f.SourceSize = r.number()
+	f.ModifiedSize = r.number()
+	copy(f.SourceMd5[:], r.bytes(16))
+	copy(f.ModifiedMd5[:], r.bytes(16))
+
+	if f.SourceSize != f.ModifiedSize {
+		magic := r.byte()
+		if r.err == nil && magic != 'M' && magic != 'A' {
+			r.err = fmt.Errorf("Invalid overflow marker %q", magic)
+			return nil
+		}
+		f.Overflow = r.bytes(r.number())
+	}
+
+	return f
+}
@@ -0,0 +1,90 @@
+package patch
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNinjaRoundTrip(t *testing.T) {
+	source := testData(0x8010)
+
+	grown := append(append([]byte{}, source...), 0x01, 0x02, 0x03)
+	grown[0x100] ^= 0xFF
+	grown[0x105] ^= 0xFF
+
+	shrunk := append([]byte{}, source[:0x4010]...)
+	shrunk[0x20] = 0x00
+
+	n := &Ninja{
+		Encoding:    NE_UTF8,
+		Author:      "Author",
+		Title:       "Test Patch",
+		Date:        "20240101",
+		Description: "Line one\nLine two",
+		Files: []*NinjaFile{
+			NewNinjaFile("grown.nes", source, grown),
+			NewNinjaFile("shrunk.nes", source[1:], shrunk),
+		},
+	}
+
+	raw, err := n.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(raw[:ninjaInfoSize], []byte(`Line one\nLine two`)) {
+		t.Errorf("Description newline not escaped")
+	}
+
+	parsed, err := ParseNinja(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Title != n.Title || parsed.Description != n.Description || len(parsed.Files) != 2 {
+		t.Fatalf("Info mismatch: %q %q %d", parsed.Title, parsed.Description, len(parsed.Files))
+	}
+
+	if parsed.Files[1].Name != "shrunk.nes" || len(parsed.Files[0].Records) != 1 {
+		t.Errorf("File mismatch: %q %d", parsed.Files[1].Name, len(parsed.Files[0].Records))
+	}
+
+	// The file to patch is picked by its MD5.
+	for _, tt := range []struct{ source, target []byte }{{source, grown}, {source[1:], shrunk}} {
+		out, err := parsed.Apply(tt.source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(out, tt.target) {
+			t.Errorf("Patched data does not match the target")
+		}
+	}
+
+	if _, err := parsed.Files[0].Apply(source[1:]); err == nil {
+		t.Errorf("Expected a source check error")
+	}
+
+	second, err := parsed.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(raw, second) {
+		t.Errorf("Second write does not match the first")
+	}
+}
+
+func TestNinjaNegative(t *testing.T) {
+	rd := &ninjaReader{data: []byte{8, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}}
+	if val := rd.number(); rd.err == nil {
+		t.Errorf("Expected an error for a negative number, got %d", val)
+	}
+
+	source := testData(0x100)
+	f := NewNinjaFile("test.nes", source, source)
+	f.Records = []NinjaRecord{{Offset: -1, Xor: []byte{0xFF}}}
+	if _, err := f.Apply(source); err == nil {
+		t.Errorf("Expected an error for a negative offset")
+	}
+}
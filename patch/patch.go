@@ -0,0 +1,89 @@
+// Package patch implements ROM patch formats.
+package patch
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+// maxSize is the largest source or target size a patch may declare.  It's far
+// larger than any ROM and keeps a corrupt patch from allocating everything.
+const maxSize = 256 * 1024 * 1024
+
+// Patch is implemented by all the supported patch formats.
+type Patch interface {
+	// Apply returns a patched copy of source.  source is not modified.
+	Apply(source []byte) ([]byte, error)
+
+	// Bytes encodes the patch.
+	Bytes() ([]byte, error)
+}
+
+// ReadFile reads a patch.  The format is picked from the file extension.
+func ReadFile(filename string) (Patch, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".ips":
+		return ReadIpsFile(filename)
+	case ".bps":
+		return ReadBpsFile(filename)
+	case ".rup":
+		return ReadNinjaFile(filename)
+	case ".ups":
+		return ReadUpsFile(filename)
+	case ".xdelta", ".vcdiff", ".vcd":
+		return ReadVcdiffFile(filename)
+	}
+	return nil, fmt.Errorf("Unknown patch format for %q", filename)
+}
+
+// Create builds a patch that turns source into target.  The format is picked
+// from the extension of filename.
+func Create(filename string, source, target []byte) (Patch, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".ips":
+		return CreateIps(source, target)
+	case ".bps":
+		return CreateBps(source, target)
+	case ".rup":
+		return CreateNinja(source, target)
+	case ".ups":
+		return CreateUps(source, target)
+	case ".xdelta", ".vcdiff", ".vcd":
+		return nil, fmt.Errorf("Creating VCDIFF patches is not supported")
+	}
+	return nil, fmt.Errorf("Unknown patch format for %q", filename)
+}
+
+// ApplyRom applies the patch to the ROM file as a whole, header included, and
+// loads the result.
+func ApplyRom(r rom.Rom, p Patch) (rom.Rom, error) {
+	source, err := RomBytes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := p.Apply(source)
+	if err != nil {
+		return nil, err
+	}
+
+	patched, err := rom.Load(bytes.NewReader(target))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load patched ROM: %w", err)
+	}
+	return patched, nil
+}
+
+// RomBytes returns the ROM as it would be written to a file.
+func RomBytes(r rom.Rom) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	_, err := r.WriteTo(buf)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encode ROM: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,221 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/zorchenhimer/go-nes/rom"
+)
+
+var upsMagic = []byte("UPS1")
+
+// UpsHunk XORs data into the file.  Skip is the number of unchanged bytes
+// since the end of the previous hunk.  The hunk ends with the first zero in
+// the XOR data, which is not stored in Xor.
+type UpsHunk struct {
+	Skip int
+	Xor  []byte
+}
+
+// Ups is a decoded UPS patch.  UPS patches can be applied in either
+// direction.
+type Ups struct {
+	SourceSize int
+	TargetSize int
+	Hunks      []UpsHunk
+
+	SourceCrc rom.Crc32
+	TargetCrc rom.Crc32
+	PatchCrc  rom.Crc32
+}
+
+func ReadUpsFile(filename string) (*Ups, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %q: %w", filename, err)
+	}
+	return ParseUps(raw)
+}
+
+func ReadUps(r io.Reader) (*Ups, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading UPS patch: %w", err)
+	}
+	return ParseUps(raw)
+}
+
+// ParseUps decodes a UPS patch and verifies the patch CRC.
+func ParseUps(raw []byte) (*Ups, error) {
+	if !bytes.HasPrefix(raw, upsMagic) {
+		return nil, fmt.Errorf("Not a UPS patch")
+	}
+
+	if len(raw) < len(upsMagic)+12 {
+		return nil, fmt.Errorf("UPS patch too short")
+	}
+
+	footer := raw[len(raw)-12:]
+	p := &Ups{
+		SourceCrc: rom.Crc32(binary.LittleEndian.Uint32(footer[0:])),
+		TargetCrc: rom.Crc32(binary.LittleEndian.Uint32(footer[4:])),
+		PatchCrc:  rom.Crc32(binary.LittleEndian.Uint32(footer[8:])),
+		Hunks:     []UpsHunk{},
+	}
+
+	crc := rom.Crc32(crc32.ChecksumIEEE(raw[:len(raw)-4]))
+	if crc != p.PatchCrc {
+		return nil, fmt.Errorf("Patch CRC mismatch: expected %s, found %s", p.PatchCrc.HexString(), crc.HexString())
+	}
+
+	// UPS uses the same number encoding as BPS.
+	rd := &bpsReader{data: raw[len(upsMagic) : len(raw)-12]}
+	p.SourceSize = rd.size()
+	p.TargetSize = rd.size()
+
+	for rd.err == nil && len(rd.data) > 0 {
+		hunk := UpsHunk{Skip: int(rd.number())}
+
+		end := bytes.IndexByte(rd.data, 0x00)
+		if end < 0 {
+			return nil, fmt.Errorf("Unterminated UPS hunk")
+		}
+		hunk.Xor = rd.bytes(end)
+		rd.bytes(1)
+
+		p.Hunks = append(p.Hunks, hunk)
+	}
+
+	if rd.err != nil {
+		return nil, rd.err
+	}
+
+	return p, nil
+}
+
+func (p *Ups) Bytes() ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte{}, upsMagic...))
+	writeBpsNumber(buf, uint64(p.SourceSize))
+	writeBpsNumber(buf, uint64(p.TargetSize))
+
+	for _, hunk := range p.Hunks {
+		if bytes.IndexByte(hunk.Xor, 0x00) >= 0 {
+			return nil, fmt.Errorf("UPS hunk data cannot contain zeros")
+		}
+
+		writeBpsNumber(buf, uint64(hunk.Skip))
+		buf.Write(hunk.Xor)
+		buf.WriteByte(0x00)
+	}
+
+	footer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(footer[0:], uint32(p.SourceCrc))
+	binary.LittleEndian.PutUint32(footer[4:], uint32(p.TargetCrc))
+	buf.Write(footer)
+
+	p.PatchCrc = rom.Crc32(crc32.ChecksumIEEE(buf.Bytes()))
+	crc := make([]byte, 4)
+	binary.LittleEndian.PutUint32(crc, uint32(p.PatchCrc))
+	buf.Write(crc)
+
+	return buf.Bytes(), nil
+}
+
+// Apply returns a patched copy of source.  If source matches the target of
+// the patch instead of the source, the patch is reversed.
+func (p *Ups) Apply(source []byte) ([]byte, error) {
+	crc := rom.Crc32(crc32.ChecksumIEEE(source))
+
+	inSize, outSize := p.SourceSize, p.TargetSize
+	inCrc, outCrc := p.SourceCrc, p.TargetCrc
+
+	if len(source) == p.TargetSize && crc == p.TargetCrc && crc != p.SourceCrc {
+		inSize, outSize = outSize, inSize
+		inCrc, outCrc = outCrc, inCrc
+	}
+
+	if len(source) != inSize {
+		return nil, fmt.Errorf("Source size mismatch: expected %d, found %d", inSize, len(source))
+	}
+
+	if crc != inCrc {
+		return nil, fmt.Errorf("Source CRC mismatch: expected %s, found %s", inCrc.HexString(), crc.HexString())
+	}
+
+	out := make([]byte, outSize)
+	copy(out, source)
+
+	offset := 0
+	for _, hunk := range p.Hunks {
+		offset += hunk.Skip
+		for _, x := range hunk.Xor {
+			if offset < len(out) {
+				out[offset] ^= x
+			}
+			offset++
+		}
+
+		// Skip the terminator
+		offset++
+	}
+
+	crc = rom.Crc32(crc32.ChecksumIEEE(out))
+	if crc != outCrc {
+		return nil, fmt.Errorf("Target CRC mismatch: expected %s, found %s", outCrc.HexString(), crc.HexString())
+	}
+
+	return out, nil
+}
+
+// CreateUps builds a patch that turns source into target.
+func CreateUps(source, target []byte) (*Ups, error) {
+	p := &Ups{
+		SourceSize: len(source),
+		TargetSize: len(target),
+		SourceCrc:  rom.Crc32(crc32.ChecksumIEEE(source)),
+		TargetCrc:  rom.Crc32(crc32.ChecksumIEEE(target)),
+		Hunks:      []UpsHunk{},
+	}
+
+	size := len(source)
+	if len(target) > size {
+		size = len(target)
+	}
+
+	// Bytes past the end of either file are treated as zero.
+	xor := func(i int) byte {
+		var s, t byte
+		if i < len(source) {
+			s = source[i]
+		}
+		if i < len(target) {
+			t = target[i]
+		}
+		return s ^ t
+	}
+
+	skip := 0
+	for i := 0; i < size; {
+		if xor(i) == 0 {
+			skip++
+			i++
+			continue
+		}
+
+		hunk := UpsHunk{Skip: skip}
+		for ; i < size && xor(i) != 0; i++ {
+			hunk.Xor = append(hunk.Xor, xor(i))
+		}
+
+		// The terminator covers the next byte, which is unchanged.
+		i++
+		skip = 0
+		p.Hunks = append(p.Hunks, hunk)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,77 @@
+package patch
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUpsRoundTrip(t *testing.T) {
+	source := testData(0x8010)
+
+	tests := []struct {
+		name   string
+		target []byte
+	}{
+		{"modified", append(append([]byte{}, source[:0x100]...), append([]byte{0x00, 0x01, 0x02}, source[0x103:]...)...)},
+		{"extended", append(append([]byte{}, source...), 0x00, 0x00, 0xFF, 0x01)},
+		{"truncated", source[:0x4010]},
+	}
+
+	for _, tt := range tests {
+		p, err := CreateUps(source, tt.target)
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.name, err)
+		}
+
+		raw, err := p.Bytes()
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.name, err)
+		}
+
+		parsed, err := ParseUps(raw)
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.name, err)
+		}
+
+		out, err := parsed.Apply(source)
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.name, err)
+		}
+
+		if !bytes.Equal(out, tt.target) {
+			t.Errorf("[%s] patched data does not match the target", tt.name)
+		}
+
+		// UPS patches work in reverse too
+		out, err = parsed.Apply(tt.target)
+		if err != nil {
+			t.Fatalf("[%s] reverse: %v", tt.name, err)
+		}
+
+		if !bytes.Equal(out, source) {
+			t.Errorf("[%s] reversed data does not match the source", tt.name)
+		}
+	}
+
+	p, err := CreateUps(source, source[:0x100])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.Apply(source[:0x200]); err == nil {
+		t.Errorf("Expected a source size error")
+	}
+}
+
+func TestUpsOversized(t *testing.T) {
+	for _, p := range []*Ups{{TargetSize: 1 << 62}, {SourceSize: 1 << 62}} {
+		raw, err := p.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ParseUps(raw); err == nil {
+			t.Errorf("Expected an error for sizes %d and %d", p.SourceSize, p.TargetSize)
+		}
+	}
+}
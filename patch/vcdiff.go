@@ -0,0 +1,420 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"os"
+)
+
+// VCDIFF (RFC 3284) decoder.  This is the format used by xdelta3.  Secondary
+// compressors and custom code tables are not supported.
+
+var vcdiffMagic = []byte{0xD6, 0xC3, 0xC4}
+
+// Header indicator bits
+const (
+	VCD_DECOMPRESS = 0x01
+	VCD_CODETABLE  = 0x02
+	VCD_APPHEADER  = 0x04 // xdelta3 extension
+)
+
+// Window indicator bits
+const (
+	VCD_SOURCE  = 0x01
+	VCD_TARGET  = 0x02
+	VCD_ADLER32 = 0x04 // xdelta3 extension
+)
+
+// Instruction types
+const (
+	vcdNoop = iota
+	vcdAdd
+	vcdRun
+	vcdCopy
+)
+
+const (
+	vcdNearSize = 4
+	vcdSameSize = 3
+)
+
+type vcdInst struct {
+	kind uint8
+	size uint8
+	mode uint8
+}
+
+// vcdCodeTable is the default code table from section 5.6 of the RFC.
+var vcdCodeTable [256][2]vcdInst
+
+func init() {
+	idx := 0
+	add := func(a, b vcdInst) {
+		vcdCodeTable[idx] = [2]vcdInst{a, b}
+		idx++
+	}
+
+	add(vcdInst{kind: vcdRun}, vcdInst{})
+	for size := 0; size <= 17; size++ {
+		add(vcdInst{kind: vcdAdd, size: uint8(size)}, vcdInst{})
+	}
+
+	for mode := 0; mode < 9; mode++ {
+		add(vcdInst{kind: vcdCopy, mode: uint8(mode)}, vcdInst{})
+		for size := 4; size <= 18; size++ {
+			add(vcdInst{kind: vcdCopy, size: uint8(size), mode: uint8(mode)}, vcdInst{})
+		}
+	}
+
+	for mode := 0; mode < 6; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			for copySize := 4; copySize <= 6; copySize++ {
+				add(vcdInst{kind: vcdAdd, size: uint8(addSize)}, vcdInst{kind: vcdCopy, size: uint8(copySize), mode: uint8(mode)})
+			}
+		}
+	}
+
+	for mode := 6; mode < 9; mode++ {
+		for addSize := 1; addSize <= 4; addSize++ {
+			add(vcdInst{kind: vcdAdd, size: uint8(addSize)}, vcdInst{kind: vcdCopy, size: 4, mode: uint8(mode)})
+		}
+	}
+
+	for mode := 0; mode < 9; mode++ {
+		add(vcdInst{kind: vcdCopy, size: 4, mode: uint8(mode)}, vcdInst{kind: vcdAdd, size: 1})
+	}
+}
+
+// VcdiffWindow is a single window of a VCDIFF patch.  The three sections are
+// kept encoded and are decoded when the patch is applied.
+type VcdiffWindow struct {
+	Indicator      uint8
+	SourceLength   int
+	SourcePosition int
+	TargetLength   int
+
+	HasChecksum bool
+	Checksum    uint32 // Adler-32 of the target window
+
+	Data         []byte
+	Instructions []byte
+	Addresses    []byte
+}
+
+// Vcdiff is a decoded VCDIFF patch.  Only applying patches is supported.
+type Vcdiff struct {
+	AppHeader []byte
+	Windows   []VcdiffWindow
+
+	raw []byte
+}
+
+func ReadVcdiffFile(filename string) (*Vcdiff, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %q: %w", filename, err)
+	}
+	return ParseVcdiff(raw)
+}
+
+func ReadVcdiff(r io.Reader) (*Vcdiff, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading VCDIFF patch: %w", err)
+	}
+	return ParseVcdiff(raw)
+}
+
+func ParseVcdiff(raw []byte) (*Vcdiff, error) {
+	if !bytes.HasPrefix(raw, vcdiffMagic) || len(raw) < 5 {
+		return nil, fmt.Errorf("Not a VCDIFF patch")
+	}
+
+	if raw[3] != 0x00 {
+		return nil, fmt.Errorf("Unsupported VCDIFF version: %d", raw[3])
+	}
+
+	indicator := raw[4]
+	if indicator&VCD_DECOMPRESS != 0 {
+		return nil, fmt.Errorf("VCDIFF secondary compression is not supported")
+	}
+
+	if indicator&VCD_CODETABLE != 0 {
+		return nil, fmt.Errorf("VCDIFF custom code tables are not supported")
+	}
+
+	p := &Vcdiff{raw: raw, Windows: []VcdiffWindow{}}
+	rd := &vcdReader{data: raw[5:]}
+
+	if indicator&VCD_APPHEADER != 0 {
+		p.AppHeader = rd.bytes(rd.integer())
+	}
+
+	for rd.err == nil && len(rd.data) > 0 {
+		win := VcdiffWindow{Indicator: rd.byte()}
+
+		if win.Indicator&(VCD_SOURCE|VCD_TARGET) == VCD_SOURCE|VCD_TARGET {
+			return nil, fmt.Errorf("VCDIFF window %d has both VCD_SOURCE and VCD_TARGET set", len(p.Windows))
+		}
+
+		if win.Indicator&(VCD_SOURCE|VCD_TARGET) != 0 {
+			win.SourceLength = rd.integer()
+			win.SourcePosition = rd.integer()
+		}
+
+		deltaLength := rd.integer()
+		start := len(rd.data)
+
+		win.TargetLength = rd.integer()
+		if win.TargetLength < 0 || win.TargetLength > maxSize {
+			return nil, fmt.Errorf("Invalid VCDIFF window %d target length: %d", len(p.Windows), win.TargetLength)
+		}
+
+		if delta := rd.byte(); delta != 0 {
+			return nil, fmt.Errorf("VCDIFF secondary compression is not supported")
+		}
+
+		dataLength := rd.integer()
+		instLength := rd.integer()
+		addrLength := rd.integer()
+
+		if win.Indicator&VCD_ADLER32 != 0 {
+			win.HasChecksum = true
+			if sum := rd.bytes(4); sum != nil {
+				win.Checksum = binary.BigEndian.Uint32(sum)
+			}
+		}
+
+		win.Data = rd.bytes(dataLength)
+		win.Instructions = rd.bytes(instLength)
+		win.Addresses = rd.bytes(addrLength)
+
+		if rd.err == nil && start-len(rd.data) != deltaLength {
+			return nil, fmt.Errorf("VCDIFF window %d delta length mismatch", len(p.Windows))
+		}
+
+		p.Windows = append(p.Windows, win)
+	}
+
+	if rd.err != nil {
+		return nil, rd.err
+	}
+
+	return p, nil
+}
+
+// Bytes returns the patch as it was read.
+func (p *Vcdiff) Bytes() ([]byte, error) {
+	if p.raw == nil {
+		return nil, fmt.Errorf("Encoding VCDIFF patches is not supported")
+	}
+	return p.raw, nil
+}
+
+// TargetSize returns the size of the patched file.
+func (p *Vcdiff) TargetSize() int {
+	size := 0
+	for _, win := range p.Windows {
+		size += win.TargetLength
+	}
+	return size
+}
+
+// Apply returns a patched copy of source.  Window checksums are verified if
+// the patch has them.
+func (p *Vcdiff) Apply(source []byte) ([]byte, error) {
+	target := []byte{}
+
+	for i, win := range p.Windows {
+		var segment []byte
+
+		switch {
+		case win.Indicator&VCD_SOURCE != 0:
+			if !win.segmentFits(len(source)) {
+				return nil, fmt.Errorf("Source size mismatch: window %d needs $%X bytes at $%X, source is $%X bytes",
+					i, win.SourceLength, win.SourcePosition, len(source))
+			}
+			segment = source[win.SourcePosition : win.SourcePosition+win.SourceLength]
+
+		case win.Indicator&VCD_TARGET != 0:
+			if !win.segmentFits(len(target)) {
+				return nil, fmt.Errorf("Window %d reads past the end of the decoded target", i)
+			}
+			segment = target[win.SourcePosition : win.SourcePosition+win.SourceLength]
+		}
+
+		out, err := win.decode(segment)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding window %d: %w", i, err)
+		}
+
+		if win.HasChecksum {
+			if sum := adler32.Checksum(out); sum != win.Checksum {
+				return nil, fmt.Errorf("Target checksum mismatch in window %d: expected %08X, found %08X", i, win.Checksum, sum)
+			}
+		}
+
+		target = append(target, out...)
+	}
+
+	return target, nil
+}
+
+// segmentFits returns true if the window's source segment is inside data of
+// the given size.
+func (win VcdiffWindow) segmentFits(size int) bool {
+	return win.SourcePosition >= 0 && win.SourceLength >= 0 &&
+		win.SourceLength <= size && win.SourcePosition <= size-win.SourceLength
+}
+
+func (win VcdiffWindow) decode(segment []byte) ([]byte, error) {
+	out := []byte{}
+
+	data := &vcdReader{data: win.Data}
+	inst := &vcdReader{data: win.Instructions}
+	addrs := &vcdAddressCache{rd: &vcdReader{data: win.Addresses}}
+
+	for inst.err == nil && len(inst.data) > 0 {
+		code := inst.byte()
+
+		for _, in := range vcdCodeTable[code] {
+			if in.kind == vcdNoop {
+				continue
+			}
+
+			size := int(in.size)
+			if size == 0 {
+				size = inst.integer()
+			}
+
+			if len(out)+size > win.TargetLength {
+				return nil, fmt.Errorf("Instruction writes past the end of the window")
+			}
+
+			switch in.kind {
+			case vcdAdd:
+				out = append(out, data.bytes(size)...)
+
+			case vcdRun:
+				b := data.byte()
+				for i := 0; i < size; i++ {
+					out = append(out, b)
+				}
+
+			case vcdCopy:
+				here := len(segment) + len(out)
+				addr := addrs.decode(here, in.mode)
+				if addr < 0 || addr >= here {
+					return nil, fmt.Errorf("Invalid COPY address: %d", addr)
+				}
+
+				// Copies from the target can overlap the output, so copy one
+				// byte at a time.
+				for i := 0; i < size; i++ {
+					if addr+i < len(segment) {
+						out = append(out, segment[addr+i])
+					} else {
+						out = append(out, out[addr+i-len(segment)])
+					}
+				}
+			}
+		}
+
+		for _, rd := range []*vcdReader{data, addrs.rd} {
+			if rd.err != nil {
+				return nil, rd.err
+			}
+		}
+	}
+
+	if inst.err != nil {
+		return nil, inst.err
+	}
+
+	if len(out) != win.TargetLength {
+		return nil, fmt.Errorf("Target window size mismatch: expected %d, found %d", win.TargetLength, len(out))
+	}
+
+	return out, nil
+}
+
+type vcdAddressCache struct {
+	rd   *vcdReader
+	near [vcdNearSize]int
+	same [vcdSameSize * 256]int
+	next int
+}
+
+func (c *vcdAddressCache) decode(here int, mode uint8) int {
+	var addr int
+
+	switch {
+	case mode == 0: // VCD_SELF
+		addr = c.rd.integer()
+	case mode == 1: // VCD_HERE
+		addr = here - c.rd.integer()
+	case int(mode)-2 < vcdNearSize:
+		addr = c.near[mode-2] + c.rd.integer()
+	default:
+		m := int(mode) - 2 - vcdNearSize
+		addr = c.same[m*256+int(c.rd.byte())]
+	}
+
+	c.near[c.next] = addr
+	c.next = (c.next + 1) % vcdNearSize
+	if addr >= 0 {
+		c.same[addr%len(c.same)] = addr
+	}
+
+	return addr
+}
+
+type vcdReader struct {
+	data []byte
+	err  error
+}
+
+func (r *vcdReader) bytes(length int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if length < 0 || length > len(r.data) {
+		r.err = fmt.Errorf("Unexpected end of VCDIFF data")
+		r.data = nil
+		return nil
+	}
+
+	val := r.data[:length]
+	r.data = r.data[length:]
+	return val
+}
+
+func (r *vcdReader) byte() byte {
+	val := r.bytes(1)
+	if val == nil {
+		return 0
+	}
+	return val[0]
+}
+
+// integer reads a big endian base 128 number.
+func (r *vcdReader) integer() int {
+	val := 0
+	for i := 0; r.err == nil; i++ {
+		if i >= 9 {
+			r.err = fmt.Errorf("Invalid integer in VCDIFF data")
+			return 0
+		}
+
+		b := r.byte()
+		val = val<<7 | int(b&0x7F)
+		if b&0x80 == 0 {
+			return val
+		}
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/adler32"
+	"testing"
+)
+
+func vcdInt(val int) []byte {
+	raw := []byte{byte(val & 0x7F)}
+	for val >>= 7; val > 0; val >>= 7 {
+		raw = append([]byte{byte(val&0x7F) | 0x80}, raw...)
+	}
+	return raw
+}
+
+// A hand assembled patch using the default code table.
+func TestVcdiffApply(t *testing.T) {
+	source := []byte("abcdefghijklmnop")
+	target := []byte("abcdefghijXYZZZZZabcdabcdeabcd")
+
+	data := []byte("XYZZ")
+	inst := []byte{
+		26,   // COPY 10, VCD_SELF
+		4,    // ADD 3
+		0, 4, // RUN 4
+		36,  // COPY 4, VCD_HERE
+		53,  // COPY 5, near[0]
+		116, // COPY 4, same[0]
+	}
+	addrs := append(append([]byte{0x00}, vcdInt(33)...), 0x10, 0x10)
+
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, adler32.Checksum(target))
+
+	delta := vcdInt(len(target))
+	delta = append(delta, 0x00)
+	delta = append(delta, vcdInt(len(data))...)
+	delta = append(delta, vcdInt(len(inst))...)
+	delta = append(delta, vcdInt(len(addrs))...)
+	delta = append(delta, checksum...)
+	delta = append(delta, data...)
+	delta = append(delta, inst...)
+	delta = append(delta, addrs...)
+
+	raw := []byte{0xD6, 0xC3, 0xC4, 0x00, VCD_APPHEADER}
+	raw = append(raw, vcdInt(3)...)
+	raw = append(raw, []byte("app")...)
+	raw = append(raw, VCD_SOURCE|VCD_ADLER32)
+	raw = append(raw, vcdInt(len(source))...)
+	raw = append(raw, 0x00)
+	raw = append(raw, vcdInt(len(delta))...)
+	raw = append(raw, delta...)
+
+	p, err := ParseVcdiff(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := p.Apply(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out, target) {
+		t.Errorf("Expected %q, found %q", target, out)
+	}
+
+	// Truncated windows are errors, not panics.
+	for i := len(raw) - len(delta); i < len(raw); i++ {
+		if _, err := ParseVcdiff(raw[:i]); err == nil {
+			t.Errorf("Expected an error for a patch cut off at %d bytes", i)
+		}
+	}
+
+	if _, err := p.Apply(source[:8]); err == nil {
+		t.Errorf("Expected a source size error")
+	}
+
+	bad := append([]byte{}, source...)
+	bad[0] = 'z'
+	if _, err := p.Apply(bad); err == nil {
+		t.Errorf("Expected a checksum error")
+	}
+}
+
+// Sizes from the patch are checked before they're used.
+func TestVcdiffBadSizes(t *testing.T) {
+	p := &Vcdiff{Windows: []VcdiffWindow{{
+		Indicator:      VCD_SOURCE,
+		SourceLength:   1,
+		SourcePosition: int(^uint(0) >> 1),
+	}}}
+
+	if _, err := p.Apply([]byte("abcd")); err == nil {
+		t.Errorf("Expected an error for an overflowing source position")
+	}
+
+	delta := append(vcdInt(1<<62), 0x00, 0x00, 0x00, 0x00)
+	raw := []byte{0xD6, 0xC3, 0xC4, 0x00, 0x00, 0x00}
+	raw = append(raw, vcdInt(len(delta))...)
+	raw = append(raw, delta...)
+
+	if _, err := ParseVcdiff(raw); err == nil {
+		t.Errorf("Expected an error for an oversized target window")
+	}
+}
@@ -0,0 +1,211 @@
+package rom
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+type CdlFormat string
+
+const (
+	CDL_FCEUX  CdlFormat = "FCEUX"
+	CDL_MESEN  CdlFormat = "Mesen"
+	CDL_MESEN2 CdlFormat = "Mesen2"
+)
+
+// Cdl holds the usage flags from a code/data log.  There is one byte of flags
+// per ROM byte, using the UP_* values for PRG and UC_* values for CHR.
+type Cdl struct {
+	Format CdlFormat
+	Prg    []byte
+	Chr    []byte
+}
+
+// FCEUX stores the $8000 window a PRG byte was last accessed through in these
+// bits.  They are dropped.
+const fceuxBankBits byte = 0x0C
+
+// Mesen2 flags that don't line up with FCEUX.
+const (
+	mesen2JumpTarget    byte = 0x04
+	mesen2SubEntryPoint byte = 0x08
+)
+
+// LoadCdl reads a CDL file.  The ROM sizes are needed because FCEUX files
+// don't have a header.
+func LoadCdl(filename string, prgSize, chrSize uint) (*Cdl, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadCdl(file, prgSize, chrSize)
+}
+
+// ReadCdl reads an FCEUX, Mesen, or Mesen2 CDL file.  FCEUX and Mesen files
+// have PRG flags followed by CHR flags.  Missing CHR flags are left as
+// UC_UNKOWN.
+func ReadCdl(r io.Reader, prgSize, chrSize uint) (*Cdl, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read CDL: %w", err)
+	}
+
+	cdl := &Cdl{Format: CDL_FCEUX}
+	switch {
+	case bytes.HasPrefix(raw, []byte("CDLv2")):
+		// Followed by the CRC32 of the ROM
+		if len(raw) < 9 {
+			return nil, fmt.Errorf("Mesen2 CDL header too short")
+		}
+		cdl.Format = CDL_MESEN2
+		raw = raw[9:]
+
+	case bytes.HasPrefix(raw, []byte("CDL\x01")):
+		cdl.Format = CDL_MESEN
+		raw = raw[4:]
+	}
+
+	if uint(len(raw)) < prgSize {
+		return nil, fmt.Errorf("CDL too short for %d bytes of PRG: %d bytes", prgSize, len(raw))
+	}
+
+	cdl.Prg = make([]byte, prgSize)
+	cdl.Chr = make([]byte, chrSize)
+	for i, b := range raw[:prgSize] {
+		cdl.Prg[i] = cdl.prgFlags(b)
+	}
+
+	copy(cdl.Chr, raw[prgSize:])
+	for i, b := range cdl.Chr {
+		cdl.Chr[i] = b & (UC_DRAWN | UC_READ)
+	}
+
+	return cdl, nil
+}
+
+// prgFlags converts a PRG byte to UP_* flags.
+func (c *Cdl) prgFlags(b byte) byte {
+	switch c.Format {
+	case CDL_FCEUX:
+		return b &^ (fceuxBankBits | UP_ENTRY)
+
+	case CDL_MESEN2:
+		flags := b &^ (mesen2JumpTarget | mesen2SubEntryPoint)
+		if b&mesen2SubEntryPoint != 0 {
+			flags |= UP_ENTRY
+		}
+		return flags
+	}
+
+	// Mesen uses the FCEUX values, with the last bit for entry points.
+	return b
+}
+
+// UsageStats counts the bytes in a range of CDL flags.  Each byte is only
+// counted once.  PRG code takes precedence over audio, which takes precedence
+// over data.  CHR bytes that are drawn are not counted as read.
+type UsageStats struct {
+	Total  int
+	Code   int
+	Data   int
+	Audio  int
+	Drawn  int
+	Read   int
+	Unused int
+}
+
+// Used returns the number of bytes with any flags set.
+func (s UsageStats) Used() int {
+	return s.Total - s.Unused
+}
+
+// Percent returns part as a percentage of the total.
+func (s UsageStats) Percent(part int) float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(s.Total)
+}
+
+// PrgUsage returns the category of a PRG flag byte: UP_CODE, UP_AUDIO,
+// UP_DATA, or UP_UNKNOWN.
+func PrgUsage(flags byte) byte {
+	switch {
+	case flags&(UP_CODE|UP_JMPCODE) != 0:
+		return UP_CODE
+	case flags&UP_AUDIO != 0:
+		return UP_AUDIO
+	case flags&(UP_DATA|UP_LDADATA) != 0:
+		return UP_DATA
+	}
+	return UP_UNKNOWN
+}
+
+// ChrUsage returns the category of a CHR flag byte: UC_DRAWN, UC_READ, or
+// UC_UNKOWN.
+func ChrUsage(flags byte) byte {
+	switch {
+	case flags&UC_DRAWN != 0:
+		return UC_DRAWN
+	case flags&UC_READ != 0:
+		return UC_READ
+	}
+	return UC_UNKOWN
+}
+
+func PrgStats(flags []byte) UsageStats {
+	stats := UsageStats{Total: len(flags)}
+	for _, f := range flags {
+		switch PrgUsage(f) {
+		case UP_CODE:
+			stats.Code++
+		case UP_AUDIO:
+			stats.Audio++
+		case UP_DATA:
+			stats.Data++
+		default:
+			stats.Unused++
+		}
+	}
+	return stats
+}
+
+func ChrStats(flags []byte) UsageStats {
+	stats := UsageStats{Total: len(flags)}
+	for _, f := range flags {
+		switch ChrUsage(f) {
+		case UC_DRAWN:
+			stats.Drawn++
+		case UC_READ:
+			stats.Read++
+		default:
+			stats.Unused++
+		}
+	}
+	return stats
+}
+
+// LargestUnused returns the offset and length of the longest run of unused
+// bytes.
+func LargestUnused(flags []byte) (int, int) {
+	bestStart, bestLen := 0, 0
+	start := -1
+	for i := 0; i <= len(flags); i++ {
+		if i < len(flags) && flags[i] == 0 {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 && i-start > bestLen {
+			bestStart, bestLen = start, i-start
+		}
+		start = -1
+	}
+	return bestStart, bestLen
+}
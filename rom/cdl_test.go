@@ -0,0 +1,61 @@
+package rom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadCdl(t *testing.T) {
+	prg := []byte{0x0D, 0x02, 0x42, 0x00, 0x11, 0x22, 0x00, 0x00}
+	chr := []byte{0x01, 0x02, 0x03, 0x00}
+
+	tests := []struct {
+		name   string
+		raw    []byte
+		format CdlFormat
+		prg    []byte
+	}{
+		{"fceux", append(append([]byte{}, prg...), chr...), CDL_FCEUX,
+			[]byte{UP_CODE, UP_DATA, UP_DATA | UP_AUDIO, 0, UP_CODE | UP_JMPCODE, UP_DATA | UP_LDADATA, 0, 0}},
+		{"mesen2", append([]byte("CDLv2\x01\x02\x03\x04\x09\x02"), make([]byte, 10)...), CDL_MESEN2,
+			[]byte{UP_CODE | UP_ENTRY, UP_DATA, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		cdl, err := ReadCdl(bytes.NewReader(tt.raw), uint(len(prg)), uint(len(chr)))
+		if err != nil {
+			t.Fatalf("[%s] %v", tt.name, err)
+		}
+
+		if cdl.Format != tt.format {
+			t.Errorf("[%s] format detected as %s", tt.name, cdl.Format)
+		}
+
+		if !bytes.Equal(cdl.Prg, tt.prg) {
+			t.Errorf("[%s] PRG flags mismatch:\n% X\n% X", tt.name, cdl.Prg, tt.prg)
+		}
+	}
+
+	cdl, err := ReadCdl(bytes.NewReader(append(append([]byte{}, prg...), chr...)), uint(len(prg)), uint(len(chr)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := PrgStats(cdl.Prg)
+	if stats.Code != 2 || stats.Data != 2 || stats.Audio != 1 || stats.Unused != 3 {
+		t.Errorf("PRG stats mismatch: %+v", stats)
+	}
+
+	stats = ChrStats(cdl.Chr)
+	if stats.Drawn != 2 || stats.Read != 1 || stats.Unused != 1 {
+		t.Errorf("CHR stats mismatch: %+v", stats)
+	}
+
+	if start, length := LargestUnused(cdl.Prg); start != 6 || length != 2 {
+		t.Errorf("Largest unused run mismatch: %d, %d", start, length)
+	}
+
+	if _, err := ReadCdl(bytes.NewReader(prg[:4]), uint(len(prg)), 0); err == nil {
+		t.Errorf("Expected an error for a short CDL")
+	}
+}
@@ -0,0 +1,153 @@
+package rom
+
+import (
+	"fmt"
+	"hash/crc32"
+)
+
+// Conversion between UNIF and NES 2.0.  The board name in a UNIF file is
+// looked up in UnifRemap to find the mapper number.
+
+// NesRom converts the UNIF ROM to a NES 2.0 ROM.  Mirroring, battery, TV
+// system and CHR-RAM information from the UNIF chunks are carried over to the
+// header.
+func (r *UnifRom) NesRom() (*NesRom, error) {
+	remap, err := FindRemap(r.Mapper)
+	if err != nil {
+		return nil, err
+	}
+
+	prg := joinChunks(r.PrgData)
+	chr := joinChunks(r.ChrData)
+
+	if len(prg) == 0 {
+		return nil, fmt.Errorf("No PRG data")
+	}
+
+	header := &Header{
+		PrgSize:          uint(len(prg)),
+		ChrSize:          uint(len(chr)),
+		PersistentMemory: r.Battery || remap.PrgNvram > 0,
+		Mirroring:        r.MirrorType(),
+		Nes2:             true,
+		Mapper:           remap.Mapper,
+		Nes2Mapper:       uint16(remap.Mapper),
+		SubMapper:        remap.Submapper,
+		Console:          CT_STANDARD,
+	}
+
+	if r.hasChunk("TVCI") {
+		switch r.TvStandard {
+		case UT_NTSC:
+			header.Timing = TM_NTSC
+		case UT_PAL:
+			header.Timing = TM_PAL
+		case UT_BOTH:
+			header.Timing = TM_MULTI
+		}
+	}
+
+	if remap.PrgRam != 0 {
+		header.PrgRamSize = unshift(remap.PrgRam)
+	}
+
+	if remap.PrgNvram != 0 {
+		header.PrgNvramSize = unshift(remap.PrgNvram)
+	} else if r.Battery {
+		// The board doesn't normally have a battery.  Move the RAM to NVRAM,
+		// or assume the usual 8k if there isn't any.
+		if header.PrgRamSize != 0 {
+			header.PrgNvramSize = header.PrgRamSize
+			header.PrgRamSize = 0
+		} else {
+			header.PrgNvramSize = unshift(8 * 1024)
+		}
+	}
+
+	if remap.ChrRam != 0 {
+		header.ChrRamSize = unshift(remap.ChrRam)
+	} else if len(chr) == 0 || r.ChrRam {
+		header.ChrRamSize = unshift(8 * 1024)
+	}
+
+	return newNesRom(header, prg, chr), nil
+}
+
+// newNesRom builds a NesRom from its pieces and calculates all the CRCs.
+func newNesRom(header *Header, prg, chr []byte) *NesRom {
+	rom := &NesRom{
+		Header: header,
+		Prgrom: prg,
+		Chrrom: chr,
+		PrgCrc: Crc32(crc32.ChecksumIEEE(prg)),
+	}
+
+	if len(chr) > 0 {
+		rom.ChrCrc = Crc32(crc32.ChecksumIEEE(chr))
+	}
+
+	rom.RomCrc = Crc32(crc32.ChecksumIEEE(append(append([]byte{}, prg...), chr...)))
+	return rom
+}
+
+// Unif converts the ROM to the UNIF format.  UNIF has no place for a trainer,
+// misc ROM data, Dendy timing, or console types other than the NES, so an
+// error is returned if any of them are present.
+func (r *NesRom) Unif() (*UnifRom, error) {
+	if r.Header.TrainerPresent || len(r.Trainer) > 0 {
+		return nil, fmt.Errorf("Trainer data cannot be stored in a UNIF file")
+	}
+
+	if len(r.MiscRom) > 0 {
+		return nil, fmt.Errorf("Misc ROM data cannot be stored in a UNIF file")
+	}
+
+	if r.Header.Console != CT_STANDARD {
+		return nil, fmt.Errorf("Console type %s cannot be stored in a UNIF file", r.Header.Console)
+	}
+
+	if r.Header.Timing == TM_DENDY {
+		return nil, fmt.Errorf("Dendy timing cannot be stored in a UNIF file")
+	}
+
+	board, err := UnifBoardName(r.Header.Mapper, r.Header.SubMapper)
+	if err != nil {
+		return nil, err
+	}
+
+	unif := &UnifRom{
+		Version: 7,
+		Mapper:  board,
+		Battery: r.Header.PersistentMemory,
+		ChrRam:  r.Header.ChrSize > 0 && (r.Header.ChrRamSize > 0 || r.Header.ChrNvramSize > 0),
+		Chunks:  []string{"MAPR", "TVCI", "MIRR"},
+	}
+
+	switch r.Header.Timing {
+	case TM_PAL:
+		unif.TvStandard = UT_PAL
+	case TM_MULTI:
+		unif.TvStandard = UT_BOTH
+	default:
+		unif.TvStandard = UT_NTSC
+	}
+
+	switch r.Header.Mirroring {
+	case M_VERTICAL:
+		unif.Mirroring = UM_VERTICAL
+	case M_IGNORE:
+		unif.Mirroring = UM_FOURSCREEN
+	default:
+		unif.Mirroring = UM_HORIZONTAL
+	}
+
+	if len(r.Prgrom) > 0 {
+		unif.PrgData = []*ChunkData{&ChunkData{Id: 0, Data: r.Prgrom}}
+	}
+
+	if len(r.Chrrom) > 0 {
+		unif.ChrData = []*ChunkData{&ChunkData{Id: 0, Data: r.Chrrom}}
+	}
+
+	return unif, nil
+}
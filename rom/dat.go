@@ -0,0 +1,233 @@
+package rom
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Support for Logiqx XML DAT files, as used by No-Intro and others.  Both
+// headered and headerless DATs are supported.
+
+type Dat struct {
+	Name        string `xml:"header>name"`
+	Description string `xml:"header>description"`
+	Version     string `xml:"header>version"`
+
+	Games []*DatGame `xml:"game"`
+
+	// Some DATs use machine instead of game.  These are moved to Games.
+	Machines []*DatGame `xml:"machine"`
+
+	bySha1 map[string]*DatMatch
+	byMd5  map[string]*DatMatch
+	byCrc  map[string]*DatMatch // CRC32 and size
+}
+
+type DatGame struct {
+	Name        string `xml:"name,attr"`
+	Description string `xml:"description"`
+	Releases    []struct {
+		Name   string `xml:"name,attr"`
+		Region string `xml:"region,attr"`
+	} `xml:"release"`
+
+	Roms []*DatRom `xml:"rom"`
+}
+
+type DatRom struct {
+	Name   string `xml:"name,attr"`
+	Size   uint   `xml:"size,attr"`
+	Crc    string `xml:"crc,attr"`
+	Md5    string `xml:"md5,attr"`
+	Sha1   string `xml:"sha1,attr"`
+	Sha256 string `xml:"sha256,attr"`
+	Status string `xml:"status,attr"` // verified, baddump, nodump, or empty
+}
+
+// DatMatch is a ROM entry that matched some data.  Headerless is true if only
+// the data after the iNES header matched.
+type DatMatch struct {
+	Game       *DatGame
+	Rom        *DatRom
+	Headerless bool
+}
+
+var datRegions = []string{
+	"World", "USA", "Europe", "Japan", "Asia", "Australia", "Brazil", "Canada",
+	"China", "France", "Germany", "Hong Kong", "Italy", "Korea", "Netherlands",
+	"Russia", "Spain", "Sweden", "Taiwan", "UK", "Unknown",
+}
+
+// GoodNES style country codes
+var goodRegions = map[string]string{
+	"(U)": "USA", "(E)": "Europe", "(J)": "Japan", "(W)": "World",
+	"(JU)": "Japan, USA", "(UE)": "USA, Europe", "(A)": "Australia",
+	"(F)": "France", "(G)": "Germany", "(S)": "Spain", "(Sw)": "Sweden",
+	"(K)": "Korea", "(Ch)": "China", "(I)": "Italy",
+}
+
+// Region returns the region of the game.  This is taken from a release
+// element if there is one, otherwise it's parsed from the name.  An empty
+// string is returned if no region is found.
+func (g *DatGame) Region() string {
+	for _, r := range g.Releases {
+		if r.Region != "" {
+			return r.Region
+		}
+	}
+
+	// No-Intro style: "Name (USA, Europe) (Rev 1)"
+	for _, group := range parenGroups(g.Name) {
+		parts := strings.Split(group, ", ")
+		isRegion := true
+		for _, p := range parts {
+			if !inList(datRegions, p) {
+				isRegion = false
+				break
+			}
+		}
+
+		if isRegion {
+			return group
+		}
+	}
+
+	for code, region := range goodRegions {
+		if strings.Contains(g.Name, code) {
+			return region
+		}
+	}
+
+	return ""
+}
+
+// Verified returns true if the DAT marks the dump as verified.  GoodNES "[!]"
+// names count as verified.
+func (g *DatGame) Verified() bool {
+	for _, r := range g.Roms {
+		if r.Status == "verified" {
+			return true
+		}
+	}
+	return strings.Contains(g.Name, "[!]")
+}
+
+func parenGroups(name string) []string {
+	groups := []string{}
+	for {
+		start := strings.Index(name, "(")
+		if start < 0 {
+			return groups
+		}
+
+		end := strings.Index(name[start:], ")")
+		if end < 0 {
+			return groups
+		}
+
+		groups = append(groups, name[start+1:start+end])
+		name = name[start+end+1:]
+	}
+}
+
+func inList(list []string, val string) bool {
+	for _, l := range list {
+		if l == val {
+			return true
+		}
+	}
+	return false
+}
+
+func LoadDat(filename string) (*Dat, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %q: %w", filename, err)
+	}
+	defer file.Close()
+
+	return ParseDat(file)
+}
+
+func ParseDat(r io.Reader) (*Dat, error) {
+	dat := &Dat{}
+
+	decoder := xml.NewDecoder(r)
+
+	// DATs are sometimes not UTF-8.  Names are mostly ASCII so pass the
+	// bytes through as-is.
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	err := decoder.Decode(dat)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading DAT: %w", err)
+	}
+
+	dat.Games = append(dat.Games, dat.Machines...)
+	dat.Machines = nil
+
+	dat.bySha1 = make(map[string]*DatMatch)
+	dat.byMd5 = make(map[string]*DatMatch)
+	dat.byCrc = make(map[string]*DatMatch)
+
+	for _, g := range dat.Games {
+		for _, rom := range g.Roms {
+			m := &DatMatch{Game: g, Rom: rom}
+			if rom.Sha1 != "" {
+				dat.bySha1[strings.ToLower(rom.Sha1)] = m
+			}
+			if rom.Md5 != "" {
+				dat.byMd5[strings.ToLower(rom.Md5)] = m
+			}
+			if rom.Crc != "" {
+				dat.byCrc[crcKey(rom.Crc, rom.Size)] = m
+			}
+		}
+	}
+
+	return dat, nil
+}
+
+func crcKey(crc string, size uint) string {
+	return fmt.Sprintf("%s:%d", strings.ToLower(crc), size)
+}
+
+// Find looks up data by its hashes.  Nil is returned if nothing matches.
+func (d *Dat) Find(h *Hashes) *DatMatch {
+	if m, ok := d.bySha1[h.Sha1]; ok {
+		return m
+	}
+
+	if m, ok := d.byMd5[h.Md5]; ok {
+		return m
+	}
+
+	if m, ok := d.byCrc[crcKey(h.Crc32.HexString(), h.Size)]; ok {
+		return m
+	}
+
+	return nil
+}
+
+// Match looks up a complete file.  If the whole file doesn't match, the
+// headerless data is tried.  Nil is returned if nothing matches.
+func (d *Dat) Match(h *RomHashes) *DatMatch {
+	if m := d.Find(h.File); m != nil {
+		return m
+	}
+
+	if h.Headerless == nil {
+		return nil
+	}
+
+	if m := d.Find(h.Headerless); m != nil {
+		return &DatMatch{Game: m.Game, Rom: m.Rom, Headerless: true}
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package rom
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDatMatch(t *testing.T) {
+	h := &Header{PrgSize: 16 * 1024, ChrSize: 8 * 1024, Mirroring: M_VERTICAL}
+	raw, err := h.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rom, err := ReadInes(bytes.NewReader(append(raw, make([]byte, 24*1024)...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes, err := HashRom(rom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hashes.File.Size != 24*1024+16 || hashes.Headerless.Size != 24*1024 || hashes.Trainer != nil {
+		t.Fatalf("Bad hash sizes: %d %d", hashes.File.Size, hashes.Headerless.Size)
+	}
+
+	// Headerless DAT with only a CRC and size
+	dat, err := ParseDat(strings.NewReader(fmt.Sprintf(`<?xml version="1.0"?>
+<datafile>
+	<header><name>Nintendo - Nintendo Entertainment System (Headerless)</name></header>
+	<game name="Test Game (USA, Europe) (Rev 1)">
+		<description>Test Game (USA, Europe) (Rev 1)</description>
+		<rom name="Test Game (USA, Europe) (Rev 1).nes" size="%d" crc="%s" status="verified"/>
+	</game>
+	<machine name="Other Game (J) [!]">
+		<rom name="Other Game (J) [!].nes" size="16" crc="00000000"/>
+	</machine>
+</datafile>`, hashes.Headerless.Size, hashes.Headerless.Crc32.HexString())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := dat.Match(hashes)
+	if m == nil {
+		t.Fatal("No match found")
+	}
+
+	if !m.Headerless || m.Game.Region() != "USA, Europe" || !m.Game.Verified() {
+		t.Errorf("Bad match: headerless:%t region:%q verified:%t", m.Headerless, m.Game.Region(), m.Game.Verified())
+	}
+
+	if len(dat.Games) != 2 || dat.Games[1].Region() != "Japan" || !dat.Games[1].Verified() {
+		t.Errorf("Bad GoodNES entry")
+	}
+}
@@ -0,0 +1,180 @@
+package rom
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HeaderFields are the field names accepted by Header.Set().
+var HeaderFields = []string{
+	"mapper", "submapper", "mirroring", "battery", "nes2", "timing", "console",
+	"prg-ram", "prg-nvram", "chr-ram", "chr-nvram",
+}
+
+// Set changes a header field by name.  Sizes can be given in bytes or with a
+// "k" suffix.  Mirroring is "horizontal", "vertical", or "four-screen".
+//
+// Setting nes2 to false clears the fields that only exist in NES 2.0.  Use
+// Encodable() to check that the result can be written.
+func (h *Header) Set(field, value string) error {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	switch field {
+	case "mapper":
+		val, err := strconv.ParseUint(value, 0, 12)
+		if err != nil {
+			return fmt.Errorf("Invalid mapper %q", value)
+		}
+		h.Mapper = uint(val)
+		h.Nes2Mapper = uint16(val)
+
+	case "submapper":
+		val, err := strconv.ParseUint(value, 0, 4)
+		if err != nil {
+			return fmt.Errorf("Invalid submapper %q", value)
+		}
+		h.SubMapper = uint8(val)
+
+	case "mirroring":
+		switch value {
+		case "horizontal", "h":
+			h.Mirroring = M_HORIZONTAL
+			h.AltNametables = false
+		case "vertical", "v":
+			h.Mirroring = M_VERTICAL
+			h.AltNametables = false
+		case "four-screen", "4":
+			h.Mirroring = M_IGNORE
+			h.AltNametables = true
+		default:
+			return fmt.Errorf("Invalid mirroring %q.  Use horizontal, vertical, or four-screen.", value)
+		}
+
+	case "battery":
+		val, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid battery value %q", value)
+		}
+		h.PersistentMemory = val
+
+	case "nes2":
+		val, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Invalid nes2 value %q", value)
+		}
+		if val && !h.Nes2 {
+			h.Ines1Extra = nil
+			h.Archaic = false
+			h.ArchaicByte7 = 0
+		} else if !val {
+			h.clearNes2()
+		}
+		h.Nes2 = val
+
+	case "timing":
+		timings := map[string]Timing{"ntsc": TM_NTSC, "pal": TM_PAL, "multi": TM_MULTI, "dendy": TM_DENDY}
+		t, ok := timings[value]
+		if !ok {
+			return fmt.Errorf("Invalid timing %q.  Use ntsc, pal, multi, or dendy.", value)
+		}
+		h.Timing = t
+
+	case "console":
+		consoles := map[string]ConsoleType{"standard": CT_STANDARD, "vs": CT_VSSYSTEM, "playchoice": CT_PLAYCHOICE, "extended": CT_EXTENDED}
+		c, ok := consoles[value]
+		if !ok {
+			return fmt.Errorf("Invalid console %q.  Use standard, vs, playchoice, or extended.", value)
+		}
+		h.Console = c
+
+	case "prg-ram", "prg-nvram", "chr-ram", "chr-nvram":
+		shift, err := ramShift(value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s size: %w", field, err)
+		}
+
+		switch field {
+		case "prg-ram":
+			h.PrgRamSize = shift
+		case "prg-nvram":
+			h.PrgNvramSize = shift
+		case "chr-ram":
+			h.ChrRamSize = shift
+		case "chr-nvram":
+			h.ChrNvramSize = shift
+		}
+
+	default:
+		return fmt.Errorf("Unknown header field %q.  Valid fields: %s", field, strings.Join(HeaderFields, ", "))
+	}
+
+	return nil
+}
+
+func (h *Header) clearNes2() {
+	h.SubMapper = 0
+	h.PrgRamSize = 0
+	h.PrgNvramSize = 0
+	h.ChrRamSize = 0
+	h.ChrNvramSize = 0
+	h.Timing = TM_NTSC
+	h.VsPpu = 0
+	h.VsHardware = 0
+	h.ExtendedConsole = 0
+	h.MiscRomCount = 0
+	h.ExpansionDevice = 0
+}
+
+// ramShift converts a RAM size to the shift count stored in NES 2.0 headers
+// (64 << shift).  Zero is no RAM.
+func ramShift(value string) (uint, error) {
+	mult := uint64(1)
+	if strings.HasSuffix(value, "k") {
+		mult = 1024
+		value = strings.TrimSuffix(value, "k")
+	}
+
+	size, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+	size *= mult
+
+	if size == 0 {
+		return 0, nil
+	}
+
+	for shift := uint(1); shift < 16; shift++ {
+		if 64<<shift == size {
+			return shift, nil
+		}
+	}
+	return 0, fmt.Errorf("%d is not a power of two between 128 and 2M", size)
+}
+
+// Encodable returns an error if Bytes() can't store every field of the header.
+// This happens with mappers above 255 or NES 2.0 fields in an iNES 1.0 header,
+// and with ROM sizes that the header can't represent.
+func (h *Header) Encodable() error {
+	raw, err := h.Bytes()
+	if err != nil {
+		return err
+	}
+
+	parsed, err := ParseHeader(raw)
+	if err != nil {
+		return err
+	}
+
+	changes := h.Diff(parsed)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	fields := []string{}
+	for _, c := range changes {
+		fields = append(fields, fmt.Sprintf("%s (%s is written as %s)", c.Field, c.Old, c.New))
+	}
+	return fmt.Errorf("Header can't be written without changing %s", strings.Join(fields, ", "))
+}
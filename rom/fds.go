@@ -0,0 +1,494 @@
+package rom
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Famicom Disk System images.  Three variations are supported:
+//   - .fds files with the 16 byte fwNES header
+//   - .fds files without a header
+//   - QD files.  These are raw disk dumps that include the block CRCs.
+
+type FdsFormat string
+
+const (
+	FDS_FWNES FdsFormat = "fwNES"
+	FDS_RAW   FdsFormat = "raw"
+	FDS_QD    FdsFormat = "QD"
+)
+
+const (
+	FdsSideSize = 65500 // .fds
+	QdSideSize  = 65536 // .qd
+
+	fdsDiskInfoSize   = 56
+	fdsFileAmountSize = 2
+	fdsFileHeaderSize = 16
+)
+
+var fdsVerification = []byte("*NINTENDO-HVC*")
+
+type FdsFileKind uint8
+
+const (
+	FK_PRG  FdsFileKind = 0x00
+	FK_CHR  FdsFileKind = 0x01
+	FK_VRAM FdsFileKind = 0x02 // nametable
+)
+
+func (k FdsFileKind) String() string {
+	switch k {
+	case FK_PRG:
+		return "PRG"
+	case FK_CHR:
+		return "CHR"
+	case FK_VRAM:
+		return "VRAM"
+	}
+	return fmt.Sprintf("Unknown (%d)", k)
+}
+
+type FdsRom struct {
+	Format FdsFormat
+	Sides  []*FdsSide
+}
+
+type FdsSide struct {
+	Info *FdsDiskInfo
+
+	// Number of files the BIOS will load.  Files after this count are
+	// "hidden" and are loaded by the game itself.
+	FileAmount int
+	Files      []*FdsFile
+
+	// Non-zero data after the last file.  This is kept so the side can be
+	// written back unchanged.
+	Trailing []byte `json:",omitempty"`
+}
+
+// FdsDiskInfo is block 1 of a disk side.  Only the commonly used fields are
+// decoded.  Everything else is kept in Raw.
+type FdsDiskInfo struct {
+	Manufacturer    uint8
+	GameName        string // three characters
+	GameType        uint8
+	Revision        uint8
+	SideNumber      uint8
+	DiskNumber      uint8
+	DiskType        uint8
+	BootFile        uint8   // Files with an ID up to this value are loaded at boot
+	ManufactureDate [3]byte // BCD, year/month/day
+
+	Raw []byte // The whole block, including the block code
+}
+
+type FdsFile struct {
+	Number      uint8
+	Id          uint8
+	Name        string // eight characters
+	LoadAddress uint16
+	Kind        FdsFileKind
+
+	Data []byte `json:"-"`
+}
+
+func (f *FdsFile) String() string {
+	return fmt.Sprintf("%02X %02X %-8s $%04X %5d %s",
+		f.Number, f.Id, strings.TrimRight(f.Name, "\x00 "), f.LoadAddress, len(f.Data), f.Kind)
+}
+
+// FdsCrc calculates the CRC that follows each block on the disk.
+func FdsCrc(data []byte) uint16 {
+	sum := uint16(0x8000)
+
+	// Two zero bytes are added to the end of the data
+	for i := 0; i < len(data)+2; i++ {
+		b := byte(0x00)
+		if i < len(data) {
+			b = data[i]
+		}
+
+		for bit := uint(0); bit < 8; bit++ {
+			carry := sum & 0x01
+			sum = (sum >> 1) | (uint16((b>>bit)&0x01) << 15)
+			if carry != 0 {
+				sum ^= 0x8408
+			}
+		}
+	}
+
+	return sum
+}
+
+func isFds(magic []byte) bool {
+	return bytes.Equal(magic, []byte{0x46, 0x44, 0x53, 0x1A}) || // FDS<EOF>
+		bytes.Equal(magic, []byte{0x01, 0x2A, 0x4E, 0x49}) // block code + "*NI"
+}
+
+// ReadFds reads an FDS image.  The format is detected automatically.
+func ReadFds(r io.Reader) (*FdsRom, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading FDS image: %w", err)
+	}
+
+	if len(raw) < fdsDiskInfoSize+2 {
+		return nil, fmt.Errorf("FDS image too short: %d bytes", len(raw))
+	}
+
+	rom := &FdsRom{}
+	sideCount := 0
+
+	if bytes.Equal(raw[:4], []byte{0x46, 0x44, 0x53, 0x1A}) {
+		rom.Format = FDS_FWNES
+		sideCount = int(raw[4])
+		raw = raw[16:]
+	}
+
+	sideSize := FdsSideSize
+	if rom.Format != FDS_FWNES {
+		if !bytes.Equal(raw[1:15], fdsVerification) {
+			return nil, fmt.Errorf("Not an FDS image")
+		}
+
+		// QD images have a CRC between the disk info and file amount
+		// blocks.
+		switch {
+		case raw[fdsDiskInfoSize] == 0x02:
+			rom.Format = FDS_RAW
+		case len(raw) > fdsDiskInfoSize+2 && raw[fdsDiskInfoSize+2] == 0x02:
+			rom.Format = FDS_QD
+			sideSize = QdSideSize
+		default:
+			return nil, fmt.Errorf("Unable to find the file amount block")
+		}
+
+		sideCount = (len(raw) + sideSize - 1) / sideSize
+	}
+
+	for i := 0; i < sideCount; i++ {
+		start := i * sideSize
+		if start >= len(raw) {
+			return nil, fmt.Errorf("Missing data for side %d", i)
+		}
+
+		end := start + sideSize
+		if end > len(raw) {
+			end = len(raw)
+		}
+
+		side, err := parseFdsSide(raw[start:end], rom.Format == FDS_QD)
+		if err != nil {
+			return nil, fmt.Errorf("Side %d: %w", i, err)
+		}
+		rom.Sides = append(rom.Sides, side)
+	}
+
+	return rom, nil
+}
+
+func parseFdsSide(data []byte, hasCrc bool) (*FdsSide, error) {
+	pos := 0
+	readBlock := func(code byte, length int) ([]byte, error) {
+		if pos+length > len(data) {
+			return nil, fmt.Errorf("Block %d at $%04X runs past the end of the side", code, pos)
+		}
+
+		if data[pos] != code {
+			return nil, fmt.Errorf("Expected block %d at $%04X, found $%02X", code, pos, data[pos])
+		}
+
+		block := data[pos : pos+length]
+		pos += length
+
+		if hasCrc {
+			if pos+2 > len(data) {
+				return nil, fmt.Errorf("Missing CRC for block %d at $%04X", code, pos)
+			}
+
+			crc := uint16(data[pos]) | uint16(data[pos+1])<<8
+			if calc := FdsCrc(block); crc != calc {
+				return nil, fmt.Errorf("CRC mismatch for block %d at $%04X: $%04X vs $%04X", code, pos, crc, calc)
+			}
+			pos += 2
+		}
+
+		return block, nil
+	}
+
+	side := &FdsSide{}
+
+	block, err := readBlock(0x01, fdsDiskInfoSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(block[1:15], fdsVerification) {
+		return nil, fmt.Errorf("Invalid disk verification string: %q", block[1:15])
+	}
+
+	side.Info = &FdsDiskInfo{
+		Manufacturer: block[0x0F],
+		GameName:     string(block[0x10:0x13]),
+		GameType:     block[0x13],
+		Revision:     block[0x14],
+		SideNumber:   block[0x15],
+		DiskNumber:   block[0x16],
+		DiskType:     block[0x17],
+		BootFile:     block[0x19],
+		Raw:          append([]byte{}, block...),
+	}
+	copy(side.Info.ManufactureDate[:], block[0x1F:0x22])
+
+	block, err = readBlock(0x02, fdsFileAmountSize)
+	if err != nil {
+		return nil, err
+	}
+	side.FileAmount = int(block[1])
+
+	// Read files until something that isn't a file header is found.  This
+	// picks up hidden files past the file amount.
+	for pos < len(data) && data[pos] == 0x03 {
+		block, err = readBlock(0x03, fdsFileHeaderSize)
+		if err != nil {
+			return nil, err
+		}
+
+		file := &FdsFile{
+			Number:      block[1],
+			Id:          block[2],
+			Name:        string(block[3:11]),
+			LoadAddress: uint16(block[11]) | uint16(block[12])<<8,
+			Kind:        FdsFileKind(block[15]),
+		}
+		size := int(block[13]) | int(block[14])<<8
+
+		block, err = readBlock(0x04, size+1)
+		if err != nil {
+			return nil, fmt.Errorf("File %d: %w", file.Number, err)
+		}
+		file.Data = append([]byte{}, block[1:]...)
+
+		side.Files = append(side.Files, file)
+	}
+
+	for _, b := range data[pos:] {
+		if b != 0x00 {
+			side.Trailing = append([]byte{}, data[pos:]...)
+			break
+		}
+	}
+
+	return side, nil
+}
+
+// Bytes returns the side's data padded out to the size of a side for the
+// given format.
+func (s *FdsSide) Bytes(format FdsFormat) ([]byte, error) {
+	data := []byte{}
+	addBlock := func(block []byte) {
+		data = append(data, block...)
+		if format == FDS_QD {
+			crc := FdsCrc(block)
+			data = append(data, uint8(crc), uint8(crc>>8))
+		}
+	}
+
+	if s.Info == nil {
+		return nil, fmt.Errorf("Missing disk info")
+	}
+	addBlock(s.Info.Bytes())
+
+	if s.FileAmount > 0xFF {
+		return nil, fmt.Errorf("Too many files: %d", s.FileAmount)
+	}
+	addBlock([]byte{0x02, uint8(s.FileAmount)})
+
+	for _, file := range s.Files {
+		if len(file.Data) > 0xFFFF {
+			return nil, fmt.Errorf("File %d is too large: %d bytes", file.Number, len(file.Data))
+		}
+
+		header := []byte{0x03, file.Number, file.Id}
+		name := make([]byte, 8)
+		copy(name, []byte(file.Name))
+		header = append(header, name...)
+		header = append(header,
+			uint8(file.LoadAddress), uint8(file.LoadAddress>>8),
+			uint8(len(file.Data)), uint8(len(file.Data)>>8),
+			uint8(file.Kind),
+		)
+		addBlock(header)
+		addBlock(append([]byte{0x04}, file.Data...))
+	}
+
+	data = append(data, s.Trailing...)
+
+	size := FdsSideSize
+	if format == FDS_QD {
+		size = QdSideSize
+	}
+
+	if len(data) > size {
+		return nil, fmt.Errorf("Side data too large: %d bytes, max %d", len(data), size)
+	}
+
+	return append(data, make([]byte, size-len(data))...), nil
+}
+
+// Bytes returns the disk info block.  The decoded fields are written over
+// the Raw data.
+func (i *FdsDiskInfo) Bytes() []byte {
+	block := make([]byte, fdsDiskInfoSize)
+	copy(block, i.Raw)
+
+	block[0] = 0x01
+	copy(block[1:15], fdsVerification)
+	block[0x0F] = i.Manufacturer
+	copy(block[0x10:0x13], []byte(i.GameName))
+	block[0x13] = i.GameType
+	block[0x14] = i.Revision
+	block[0x15] = i.SideNumber
+	block[0x16] = i.DiskNumber
+	block[0x17] = i.DiskType
+	block[0x19] = i.BootFile
+	copy(block[0x1F:0x22], i.ManufactureDate[:])
+
+	return block
+}
+
+// ReplaceFile replaces the data of the file at the given index.  The new data
+// must fit on the side.
+func (s *FdsSide) ReplaceFile(idx int, data []byte) error {
+	if idx < 0 || idx >= len(s.Files) {
+		return fmt.Errorf("Invalid file index %d", idx)
+	}
+
+	old := s.Files[idx].Data
+	s.Files[idx].Data = data
+
+	if _, err := s.Bytes(FDS_RAW); err != nil {
+		s.Files[idx].Data = old
+		return err
+	}
+
+	return nil
+}
+
+// FindFile returns the file with the given name, ignoring padding.  Nil is
+// returned if the file isn't found.
+func (s *FdsSide) FindFile(name string) *FdsFile {
+	for _, f := range s.Files {
+		if strings.TrimRight(f.Name, "\x00 ") == strings.TrimRight(name, "\x00 ") {
+			return f
+		}
+	}
+	return nil
+}
+
+func (r *FdsRom) Bytes() ([]byte, error) {
+	data := []byte{}
+	if r.Format == FDS_FWNES {
+		header := make([]byte, 16)
+		copy(header, []byte{0x46, 0x44, 0x53, 0x1A})
+		header[4] = uint8(len(r.Sides))
+		data = append(data, header...)
+	}
+
+	for i, side := range r.Sides {
+		raw, err := side.Bytes(r.Format)
+		if err != nil {
+			return nil, fmt.Errorf("Side %d: %w", i, err)
+		}
+		data = append(data, raw...)
+	}
+
+	return data, nil
+}
+
+func (r *FdsRom) WriteTo(w io.Writer) (int64, error) {
+	data, err := r.Bytes()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+func (r *FdsRom) RomType() RomType {
+	return FDS
+}
+
+func (r *FdsRom) filesOfKind(kind FdsFileKind) []byte {
+	data := []byte{}
+	for _, side := range r.Sides {
+		for _, file := range side.Files {
+			if file.Kind == kind {
+				data = append(data, file.Data...)
+			}
+		}
+	}
+	return data
+}
+
+// PrgRom returns the data of all the PRG files, in disk order.
+func (r *FdsRom) PrgRom() []byte {
+	return r.filesOfKind(FK_PRG)
+}
+
+// ChrRom returns the data of all the CHR files, in disk order.
+func (r *FdsRom) ChrRom() []byte {
+	return r.filesOfKind(FK_CHR)
+}
+
+func (r *FdsRom) TrainerRom() []byte {
+	return nil
+}
+
+func (r *FdsRom) NesHeader() (*Header, error) {
+	return nil, fmt.Errorf("FDS images cannot be represented with a NES 2.0 header")
+}
+
+// MapperNumber returns 20, the iNES mapper number reserved for the FDS.
+func (r *FdsRom) MapperNumber() uint {
+	return 20
+}
+
+func (r *FdsRom) SubMapperNumber() uint8 {
+	return 0
+}
+
+// MirrorType always returns M_HORIZONTAL.  Mirroring is controlled by the
+// RAM adapter.
+func (r *FdsRom) MirrorType() MirrorType {
+	return M_HORIZONTAL
+}
+
+func (r *FdsRom) HasBattery() bool {
+	return false
+}
+
+func (r *FdsRom) PrgSize() uint {
+	return uint(len(r.PrgRom()))
+}
+
+func (r *FdsRom) ChrSize() uint {
+	return uint(len(r.ChrRom()))
+}
+
+func (r *FdsRom) PrgCrc32() Crc32 {
+	return Crc32(crc32.ChecksumIEEE(r.PrgRom()))
+}
+
+func (r *FdsRom) ChrCrc32() Crc32 {
+	chr := r.ChrRom()
+	if len(chr) == 0 {
+		return 0
+	}
+	return Crc32(crc32.ChecksumIEEE(chr))
+}
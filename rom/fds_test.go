@@ -0,0 +1,93 @@
+package rom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testFdsRom(format FdsFormat) *FdsRom {
+	return &FdsRom{
+		Format: format,
+		Sides: []*FdsSide{
+			&FdsSide{
+				Info: &FdsDiskInfo{
+					Manufacturer: 0x01,
+					GameName:     "TST",
+					BootFile:     0x01,
+				},
+				FileAmount: 2,
+				Files: []*FdsFile{
+					&FdsFile{Number: 0, Id: 0, Name: "KYODAKU-", LoadAddress: 0x2800, Kind: FK_VRAM, Data: bytes.Repeat([]byte{0x24}, 0xE0)},
+					&FdsFile{Number: 1, Id: 1, Name: "MAIN", LoadAddress: 0x6000, Kind: FK_PRG, Data: bytes.Repeat([]byte{0xEA}, 0x1000)},
+					&FdsFile{Number: 2, Id: 5, Name: "HIDDEN", LoadAddress: 0x0000, Kind: FK_CHR, Data: bytes.Repeat([]byte{0x55}, 0x100)},
+				},
+			},
+		},
+	}
+}
+
+// Write each format, read it back, and write it again.
+func TestFdsRecode(t *testing.T) {
+	for _, format := range []FdsFormat{FDS_FWNES, FDS_RAW, FDS_QD} {
+		first, err := testFdsRom(format).Bytes()
+		if err != nil {
+			t.Fatalf("[%s] %v", format, err)
+		}
+
+		rom, err := ReadFds(bytes.NewReader(first))
+		if err != nil {
+			t.Fatalf("[%s] %v", format, err)
+		}
+
+		if rom.Format != format {
+			t.Errorf("[%s] format detected as %s", format, rom.Format)
+		}
+
+		if len(rom.Sides) != 1 || len(rom.Sides[0].Files) != 3 || rom.Sides[0].FileAmount != 2 {
+			t.Fatalf("[%s] unexpected side/file count", format)
+		}
+
+		if f := rom.Sides[0].FindFile("MAIN"); f == nil || f.LoadAddress != 0x6000 || len(f.Data) != 0x1000 {
+			t.Errorf("[%s] MAIN file mismatch: %v", format, f)
+		}
+
+		second, err := rom.Bytes()
+		if err != nil {
+			t.Fatalf("[%s] %v", format, err)
+		}
+
+		if !bytes.Equal(first, second) {
+			t.Errorf("[%s] second write does not match the first", format)
+		}
+	}
+}
+
+// A headerless image that ends right after the disk info block.
+func TestFdsShort(t *testing.T) {
+	raw, err := testFdsRom(FDS_RAW).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw = raw[:fdsDiskInfoSize+2]
+	raw[fdsDiskInfoSize] = 0x00
+	if _, err := ReadFds(bytes.NewReader(raw)); err == nil {
+		t.Errorf("Expected an error for a short image")
+	}
+}
+
+func TestFdsReplaceFile(t *testing.T) {
+	side := testFdsRom(FDS_RAW).Sides[0]
+
+	if err := side.ReplaceFile(1, make([]byte, 0x2000)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := side.ReplaceFile(1, make([]byte, 0xFFFF)); err == nil {
+		t.Errorf("Expected an error for a file that doesn't fit")
+	}
+
+	if len(side.Files[1].Data) != 0x2000 {
+		t.Errorf("Failed replace changed the file data")
+	}
+}
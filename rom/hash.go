@@ -0,0 +1,78 @@
+package rom
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+)
+
+// Hashes holds all the supported hashes for a block of data.  The string
+// values are lowercase hex.
+type Hashes struct {
+	Size   uint
+	Crc32  Crc32
+	Md5    string
+	Sha1   string
+	Sha256 string
+}
+
+func HashData(data []byte) *Hashes {
+	md := md5.Sum(data)
+	sha := sha1.Sum(data)
+	sha2 := sha256.Sum256(data)
+
+	return &Hashes{
+		Size:   uint(len(data)),
+		Crc32:  Crc32(crc32.ChecksumIEEE(data)),
+		Md5:    hex.EncodeToString(md[:]),
+		Sha1:   hex.EncodeToString(sha[:]),
+		Sha256: hex.EncodeToString(sha2[:]),
+	}
+}
+
+// RomHashes holds the hashes for the different parts of a ROM.  Chr and
+// Trainer are nil if the ROM doesn't have them.
+type RomHashes struct {
+	File       *Hashes // The complete file
+	Headerless *Hashes // Everything after the header
+	Prg        *Hashes
+	Chr        *Hashes `json:",omitempty"`
+	Trainer    *Hashes `json:",omitempty"`
+}
+
+// HashRom calculates the hashes for the ROM as it would be written to a file.
+// For formats other than iNES the headerless data is the trainer, PRG, and
+// CHR data.
+func HashRom(r Rom) (*RomHashes, error) {
+	buf := &bytes.Buffer{}
+	_, err := r.WriteTo(buf)
+	if err != nil {
+		return nil, err
+	}
+	file := buf.Bytes()
+
+	h := &RomHashes{
+		File: HashData(file),
+		Prg:  HashData(r.PrgRom()),
+	}
+
+	if _, ok := r.(*NesRom); ok {
+		h.Headerless = HashData(file[16:])
+	} else {
+		data := append(append([]byte{}, r.TrainerRom()...), r.PrgRom()...)
+		h.Headerless = HashData(append(data, r.ChrRom()...))
+	}
+
+	if len(r.ChrRom()) > 0 {
+		h.Chr = HashData(r.ChrRom())
+	}
+
+	if len(r.TrainerRom()) > 0 {
+		h.Trainer = HashData(r.TrainerRom())
+	}
+
+	return h, nil
+}
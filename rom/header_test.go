@@ -1,6 +1,7 @@
 package rom
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 	"testing"
@@ -21,10 +22,18 @@ func TestHeaderRecode(t *testing.T) {
 		Nes2Mapper: 16,
 		SubMapper:  4,
 
-		Console: CT_EXTENDED,
+		Console:         CT_EXTENDED,
+		Timing:          TM_DENDY,
+		ExtendedConsole: ECT_VT03,
+		MiscRomCount:    1,
+		ExpansionDevice: ED_STANDARD,
+	}
+
+	raw, err := h.Bytes()
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	raw := h.Bytes()
 	if len(raw) != 16 {
 		t.Errorf("Invalid header length of %d bytes", len(raw))
 	}
@@ -92,6 +101,132 @@ func equTest(t *testing.T, a, b *Header) {
 		t.Errorf("Mapper mismatch: %d vs %d", a.Mapper, b.Mapper)
 	}
 
+	if a.Timing != b.Timing {
+		t.Errorf("Timing mismatch: %s vs %s", a.Timing, b.Timing)
+	}
+
+	if a.ExtendedConsole != b.ExtendedConsole {
+		t.Errorf("ExtendedConsole mismatch: %s vs %s", a.ExtendedConsole, b.ExtendedConsole)
+	}
+
+	if a.MiscRomCount != b.MiscRomCount {
+		t.Errorf("MiscRomCount mismatch: %d vs %d", a.MiscRomCount, b.MiscRomCount)
+	}
+
+	if a.ExpansionDevice != b.ExpansionDevice {
+		t.Errorf("ExpansionDevice mismatch: %s vs %s", a.ExpansionDevice, b.ExpansionDevice)
+	}
+
 	t.Log(a.Debug())
 	t.Log(b.Debug())
 }
+
+// Decode raw headers and encode them again.  The bytes should not change.
+func TestHeaderBytesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     []byte
+		prgSize uint
+		chrSize uint
+	}{
+		{"ines1", []byte{0x4E, 0x45, 0x53, 0x1A, 0x02, 0x01, 0x01, 0x00, 0, 0, 0, 0, 0, 0, 0, 0}, 32 * 1024, 8 * 1024},
+		{"ines1 junk", []byte("NES\x1A\x08\x10\x40\x00\x01junk!\x00\x00"), 128 * 1024, 128 * 1024},
+		{"diskdude", []byte("NES\x1A\x08\x10\x41DiskDude!"), 128 * 1024, 128 * 1024},
+		{"nes2 msb", []byte{0x4E, 0x45, 0x53, 0x1A, 0x00, 0x00, 0x52, 0x08, 0x01, 0x21, 0x70, 0x07, 0x01, 0x00, 0x00, 0x01}, 0x100 * 16 * 1024, 0x200 * 8 * 1024},
+		{"nes2 exponent", []byte{0x4E, 0x45, 0x53, 0x1A, 0x6B, 0x00, 0x00, 0x08, 0x00, 0x0F, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00}, (1 << 26) * 7, 0},
+		{"nes2 vs", []byte{0x4E, 0x45, 0x53, 0x1A, 0x02, 0x02, 0x09, 0x09, 0x00, 0x00, 0x00, 0x00, 0x00, 0x34, 0x00, 0x04}, 32 * 1024, 16 * 1024},
+		{"nes2 four-screen vertical", []byte{0x4E, 0x45, 0x53, 0x1A, 0x02, 0x00, 0x09, 0x08, 0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x00}, 32 * 1024, 0},
+		{"nes2 extended", []byte{0x4E, 0x45, 0x53, 0x1A, 0x02, 0x00, 0x00, 0x0B, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0A, 0x02, 0x00}, 32 * 1024, 0},
+	}
+
+	for _, tt := range tests {
+		h, err := ParseHeader(tt.raw)
+		if err != nil {
+			t.Errorf("[%s] %v", tt.name, err)
+			continue
+		}
+
+		if h.PrgSize != tt.prgSize {
+			t.Errorf("[%s] PrgSize mismatch: %d vs %d", tt.name, h.PrgSize, tt.prgSize)
+		}
+
+		if h.ChrSize != tt.chrSize {
+			t.Errorf("[%s] ChrSize mismatch: %d vs %d", tt.name, h.ChrSize, tt.chrSize)
+		}
+
+		if tt.name == "diskdude" && (!h.Archaic || h.Mapper != 4 || h.Console != CT_STANDARD) {
+			t.Errorf("[%s] junk in byte 7 not ignored: archaic:%t mapper:%d", tt.name, h.Archaic, h.Mapper)
+		}
+
+		out, err := h.Bytes()
+		if err != nil {
+			t.Errorf("[%s] %v", tt.name, err)
+		} else if !bytes.Equal(tt.raw, out) {
+			t.Errorf("[%s] round trip mismatch:\n% X\n% X", tt.name, tt.raw, out)
+		}
+	}
+}
+
+// Sizes and mappers that don't fit are errors, not truncated.
+func TestHeaderBytesLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		header Header
+	}{
+		{"nes2 prg", Header{Nes2: true, PrgSize: 0x1003 * 16 * 1024}},
+		{"nes2 chr", Header{Nes2: true, PrgSize: 16 * 1024, ChrSize: 9 * 1024 * 1024 * 1024}},
+		{"nes2 mapper", Header{Nes2: true, PrgSize: 16 * 1024, Mapper: 0x1000}},
+		{"ines1 prg", Header{PrgSize: 0x100 * 16 * 1024}},
+		{"ines1 odd prg", Header{PrgSize: 24 * 1024}},
+		{"ines1 chr", Header{PrgSize: 16 * 1024, ChrSize: 4 * 1024}},
+		{"ines1 mapper", Header{PrgSize: 16 * 1024, Mapper: 300}},
+	}
+
+	for _, tt := range tests {
+		if raw, err := tt.header.Bytes(); err == nil {
+			t.Errorf("[%s] expected an error, got % X", tt.name, raw)
+		}
+	}
+}
+
+func TestHeaderSet(t *testing.T) {
+	h := &Header{PrgSize: 32 * 1024, ChrSize: 8 * 1024}
+	fields := [][2]string{
+		{"mapper", "4"},
+		{"submapper", "1"},
+		{"mirroring", "four-screen"},
+		{"battery", "true"},
+		{"prg-nvram", "8k"},
+		{"chr-ram", "8192"},
+		{"nes2", "true"},
+	}
+	for _, f := range fields {
+		if err := h.Set(f[0], f[1]); err != nil {
+			t.Fatalf("%s=%s: %v", f[0], f[1], err)
+		}
+	}
+
+	if h.Mapper != 4 || h.SubMapper != 1 || h.Mirroring != M_IGNORE || !h.AltNametables || h.PrgNvramSize != 7 || h.ChrRamSize != 7 {
+		t.Errorf("Fields not set: %s", h.Debug())
+	}
+
+	if err := h.Encodable(); err != nil {
+		t.Error(err)
+	}
+
+	for _, f := range [][2]string{{"mapper", "4096"}, {"prg-ram", "3k"}, {"mirroring", "diagonal"}, {"bogus", "1"}} {
+		if err := h.Set(f[0], f[1]); err == nil {
+			t.Errorf("%s=%s: expected an error", f[0], f[1])
+		}
+	}
+
+	// Clearing nes2 drops the NES 2.0 fields, but not the mapper.
+	h.Set("mapper", "300")
+	h.Set("nes2", "false")
+	if h.SubMapper != 0 || h.PrgNvramSize != 0 {
+		t.Errorf("NES 2.0 fields not cleared: %s", h.Debug())
+	}
+	if err := h.Encodable(); err == nil {
+		t.Errorf("Expected an error for mapper 300 in an iNES 1.0 header")
+	}
+}
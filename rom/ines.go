@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
 )
 
 type ConsoleType uint8
@@ -56,6 +57,26 @@ type Header struct {
 	PrgNvramSize uint
 	ChrRamSize   uint
 	ChrNvramSize uint
+
+	// NES 2.0 only
+	Timing          Timing
+	VsPpu           VsPpuType           // Only used with CT_VSSYSTEM
+	VsHardware      VsHardwareType      // Only used with CT_VSSYSTEM
+	ExtendedConsole ExtendedConsoleType // Only used with CT_EXTENDED
+	MiscRomCount    uint8
+	ExpansionDevice ExpansionDevice
+
+	// Bytes 8-15 of an iNES 1.0 header.  Nothing reads these, but they are
+	// kept so the header is written back exactly as it was found.  This is
+	// nil if all the bytes are zero.
+	Ines1Extra []byte `json:",omitempty"`
+
+	// Set for archaic iNES headers with junk in bytes 7-15, like the
+	// "DiskDude!" signature.  Byte 7 is ignored, so only the lower nibble of
+	// the mapper number is used.  The original byte 7 is kept in
+	// ArchaicByte7 so the header can be written back unchanged.
+	Archaic      bool  `json:",omitempty"`
+	ArchaicByte7 uint8 `json:",omitempty"`
 }
 
 func (h Header) Debug() string {
@@ -68,7 +89,14 @@ func (h Header) Debug() string {
 	Mirroring: %s
 	Nes2: %t
 	Mapper: %d
-	Console: %s`,
+	SubMapper: %d
+	Console: %s
+	Timing: %s
+	VsPpu: %s
+	VsHardware: %s
+	ExtendedConsole: %s
+	MiscRomCount: %d
+	ExpansionDevice: %s`,
 		h.PrgSize/1024,
 		h.ChrSize/1024,
 		h.MiscSize,
@@ -77,7 +105,14 @@ func (h Header) Debug() string {
 		h.Mirroring,
 		h.Nes2,
 		h.Mapper,
+		h.SubMapper,
 		h.Console,
+		h.Timing,
+		h.VsPpu,
+		h.VsHardware,
+		h.ExtendedConsole,
+		h.MiscRomCount,
+		h.ExpansionDevice,
 	)
 }
 
@@ -110,6 +145,34 @@ func (h Header) WriteMeta(name string) error {
 	return nil
 }
 
+// HeaderChange is a single field that differs between two headers.
+type HeaderChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Diff returns the fields that differ between h and other.
+func (h *Header) Diff(other *Header) []HeaderChange {
+	changes := []HeaderChange{}
+
+	a := reflect.ValueOf(*h)
+	b := reflect.ValueOf(*other)
+	for i := 0; i < a.NumField(); i++ {
+		if reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			continue
+		}
+
+		changes = append(changes, HeaderChange{
+			Field: a.Type().Field(i).Name,
+			Old:   fmt.Sprint(a.Field(i).Interface()),
+			New:   fmt.Sprint(b.Field(i).Interface()),
+		})
+	}
+
+	return changes
+}
+
 func LoadHeader(data []byte) (*Header, error) {
 	h := &Header{}
 	err := json.Unmarshal(data, h)
@@ -146,6 +209,10 @@ func (h *Header) HasChr() bool {
 }
 
 func ParseHeader(raw []byte) (*Header, error) {
+	if len(raw) < 16 {
+		return nil, fmt.Errorf("Header too short: %d bytes", len(raw))
+	}
+
 	if !bytes.Equal(raw[:4], []byte{0x4E, 0x45, 0x53, 0x1A}) {
 		return nil, fmt.Errorf("iNES header constant missing, found 0x%X instead", raw[:3])
 	}
@@ -172,8 +239,9 @@ func ParseHeader(raw []byte) (*Header, error) {
 	header.TrainerPresent = (flagSix & 0x04) == 0x04
 	header.AltNametables = (flagSix & 0x08) == 0x08
 
-	// Hard-wired four-screen mode
-	if (flagSix & 0x08) == 0x08 {
+	// Hard-wired four-screen mode.  If the vertical bit is also set, keep
+	// the vertical mirroring so the header can be written back unchanged.
+	if header.AltNametables && header.Mirroring != M_VERTICAL {
 		header.Mirroring = M_IGNORE
 	}
 
@@ -182,24 +250,46 @@ func ParseHeader(raw []byte) (*Header, error) {
 
 	header.Nes2 = (flagSeven & 0x0C) == 0x08
 
-	// Read the MSB size bytes
-	if header.Nes2 {
-		header.PrgSize = uint(uint16(raw[4])|((uint16(raw[9])&0x0F)<<8)) * 16 * 1024
-		header.ChrSize = uint(uint16(raw[5])|((uint16(raw[9])&0xF0)<<8)) * 8 * 1024
-	}
-
 	uppermap := flagSeven & 0xF0
 
 	lowermap := flagSix & 0xF0
 	lowermap = lowermap >> 4
 
-	header.AltNametables = flagSix&0x08 != 0
 	header.Mapper = uint(lowermap | uppermap)
+	header.Nes2Mapper = uint16(header.Mapper)
+
+	if !header.Nes2 {
+		if !allZero(raw[8:16]) {
+			header.Ines1Extra = make([]byte, 8)
+			copy(header.Ines1Extra, raw[8:16])
+		}
+
+		// Byte 7 can only be trusted if bits 2-3 are clear and the last
+		// four bytes are empty.
+		if flagSeven&0x0C != 0x00 || !allZero(raw[12:16]) {
+			header.Archaic = true
+			header.ArchaicByte7 = flagSeven
+			header.Console = CT_STANDARD
+			header.Mapper = uint(lowermap)
+			header.Nes2Mapper = uint16(header.Mapper)
+		}
+		return header, nil
+	}
+
+	var err error
+	header.PrgSize, err = decodeRomSize(raw[4], raw[9]&0x0F, 16*1024)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid PRG size: %w", err)
+	}
+
+	header.ChrSize, err = decodeRomSize(raw[5], raw[9]>>4, 8*1024)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CHR size: %w", err)
+	}
 
 	flags8 := raw[8]
+	header.Mapper = header.Mapper | uint(flags8&0x0F)<<8
 	header.Nes2Mapper = uint16(header.Mapper)
-	n2map := uint16(flags8&0xF) << 12
-	header.Nes2Mapper = header.Nes2Mapper | n2map
 
 	header.SubMapper = uint8(flags8 >> 4)
 
@@ -229,16 +319,110 @@ func ParseHeader(raw []byte) (*Header, error) {
 		header.ChrNvramSize = shift
 	}
 
+	header.Timing = Timing(raw[12] & 0x03)
+
+	switch header.Console {
+	case CT_VSSYSTEM:
+		header.VsPpu = VsPpuType(raw[13] & 0x0F)
+		header.VsHardware = VsHardwareType(raw[13] >> 4)
+	case CT_EXTENDED:
+		header.ExtendedConsole = ExtendedConsoleType(raw[13] & 0x0F)
+	}
+
+	header.MiscRomCount = raw[14] & 0x03
+	header.ExpansionDevice = ExpansionDevice(raw[15] & 0x3F)
+
 	return header, nil
 }
 
-func (h Header) Bytes() []byte {
+func allZero(raw []byte) bool {
+	for _, b := range raw {
+		if b != 0x00 {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeRomSize returns the size in bytes of PRG or CHR ROM from the LSB
+// byte and the MSB nibble.  An MSB nibble of $F means the LSB is in
+// exponent-multiplier notation.
+func decodeRomSize(lsb, msb uint8, unit uint) (uint, error) {
+	if msb != 0x0F {
+		return (uint(msb)<<8 | uint(lsb)) * unit, nil
+	}
+
+	exponent := uint(lsb >> 2)
+	multiplier := uint(lsb&0x03)*2 + 1
+
+	// 2^60 * 7 is the largest value that fits in 64 bits
+	if exponent > 60 {
+		return 0, fmt.Errorf("exponent %d too large", exponent)
+	}
+
+	return (1 << exponent) * multiplier, nil
+}
+
+// encodeRomSize is the reverse of decodeRomSize.  The simple notation is used
+// if the size can be represented with it, otherwise the exponent-multiplier
+// notation is used.  An error is returned if neither can hold the size.
+func encodeRomSize(size, unit uint) (lsb, msb uint8, err error) {
+	if size%unit == 0 && size/unit <= 0xEFF {
+		count := size / unit
+		return uint8(count & 0xFF), uint8(count >> 8), nil
+	}
+
+	odd := size
+	exponent := uint(0)
+	for odd > 1 && odd&0x01 == 0 {
+		odd >>= 1
+		exponent++
+	}
+
+	if odd > 7 || exponent > 0x3F {
+		return 0, 0, fmt.Errorf("Size %d can't be stored in an NES 2.0 header", size)
+	}
+
+	return uint8(exponent<<2) | uint8((odd-1)/2), 0x0F, nil
+}
+
+// Bytes encodes the header.  An error is returned if the ROM sizes or the
+// mapper number don't fit in the header format.
+func (h Header) Bytes() ([]byte, error) {
 	data := []byte{0x4E, 0x45, 0x53, 0x1A}
-	prg := h.PrgSize / 1024 / 16
-	chr := h.ChrSize / 1024 / 8
 
-	data = append(data, uint8(prg))
-	data = append(data, uint8(chr))
+	var prg, chr, prgMsb, chrMsb uint8
+	if h.Nes2 {
+		if h.Mapper > 0xFFF {
+			return nil, fmt.Errorf("Mapper %d can't be stored in an NES 2.0 header", h.Mapper)
+		}
+
+		var err error
+		prg, prgMsb, err = encodeRomSize(h.PrgSize, 16*1024)
+		if err != nil {
+			return nil, fmt.Errorf("PRG %w", err)
+		}
+		chr, chrMsb, err = encodeRomSize(h.ChrSize, 8*1024)
+		if err != nil {
+			return nil, fmt.Errorf("CHR %w", err)
+		}
+	} else {
+		if h.Mapper > 0xFF {
+			return nil, fmt.Errorf("Mapper %d can't be stored in an iNES header", h.Mapper)
+		}
+		if h.PrgSize%(16*1024) != 0 || h.PrgSize/(16*1024) > 0xFF {
+			return nil, fmt.Errorf("PRG size %d can't be stored in an iNES header", h.PrgSize)
+		}
+		if h.ChrSize%(8*1024) != 0 || h.ChrSize/(8*1024) > 0xFF {
+			return nil, fmt.Errorf("CHR size %d can't be stored in an iNES header", h.ChrSize)
+		}
+
+		prg = uint8(h.PrgSize / 1024 / 16)
+		chr = uint8(h.ChrSize / 1024 / 8)
+	}
+
+	data = append(data, prg)
+	data = append(data, chr)
 
 	flagSix := uint8(0)
 	if h.Mirroring == M_VERTICAL {
@@ -253,7 +437,7 @@ func (h Header) Bytes() []byte {
 		flagSix |= 0x04
 	}
 
-	if h.Mirroring == M_IGNORE {
+	if h.Mirroring == M_IGNORE || h.AltNametables {
 		flagSix |= 0x08
 	}
 
@@ -271,13 +455,22 @@ func (h Header) Bytes() []byte {
 	}
 
 	flagSeven |= uint8(uppermap)
+	if !h.Nes2 && h.Archaic {
+		flagSeven = h.ArchaicByte7
+	}
 	data = append(data, flagSeven)
 
+	if !h.Nes2 {
+		extra := make([]byte, 8)
+		copy(extra, h.Ines1Extra)
+		return append(data, extra...), nil
+	}
+
 	flagEight := uint8(h.SubMapper<<4 | uint8(highmap))
 	data = append(data, flagEight)
 
-	// TODO: flagNine (PRG/CHR ROM MSB)
-	data = append(data, 0x00)
+	flagNine := chrMsb<<4 | prgMsb&0x0F
+	data = append(data, flagNine)
 
 	flagTen := uint8(h.PrgRamSize<<4 | h.PrgNvramSize&0x0F)
 	data = append(data, flagTen)
@@ -285,11 +478,21 @@ func (h Header) Bytes() []byte {
 	flagEleven := uint8(h.ChrRamSize<<4 | h.ChrNvramSize&0x0F)
 	data = append(data, flagEleven)
 
-	for len(data) < 16 {
-		data = append(data, 0x00)
+	data = append(data, uint8(h.Timing)&0x03)
+
+	flagThirteen := uint8(0)
+	switch h.Console {
+	case CT_VSSYSTEM:
+		flagThirteen = uint8(h.VsHardware)<<4 | uint8(h.VsPpu)&0x0F
+	case CT_EXTENDED:
+		flagThirteen = uint8(h.ExtendedConsole) & 0x0F
 	}
+	data = append(data, flagThirteen)
+
+	data = append(data, h.MiscRomCount&0x03)
+	data = append(data, uint8(h.ExpansionDevice)&0x3F)
 
-	return data
+	return data, nil
 }
 
 type MirrorType uint
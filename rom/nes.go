@@ -17,14 +17,16 @@ func (crc Crc32) HexString() string {
 type NesRom struct {
 	Header *Header
 
+	Trainer []byte // 512 bytes, if present
 	Prgrom  []byte
 	Chrrom  []byte
 	MiscRom []byte // data after the CHR rom
 
-	PrgCrc  Crc32
-	ChrCrc  Crc32
-	MiscCrc Crc32
-	RomCrc  Crc32
+	TrainerCrc Crc32
+	PrgCrc     Crc32
+	ChrCrc     Crc32
+	MiscCrc    Crc32
+	RomCrc     Crc32
 }
 
 func (r *NesRom) ChrRom() []byte {
@@ -35,6 +37,46 @@ func (r *NesRom) PrgRom() []byte {
 	return r.Prgrom
 }
 
+func (r *NesRom) TrainerRom() []byte {
+	return r.Trainer
+}
+
+func (r *NesRom) NesHeader() (*Header, error) {
+	return r.Header, nil
+}
+
+func (r *NesRom) MapperNumber() uint {
+	return r.Header.Mapper
+}
+
+func (r *NesRom) SubMapperNumber() uint8 {
+	return r.Header.SubMapper
+}
+
+func (r *NesRom) MirrorType() MirrorType {
+	return r.Header.Mirroring
+}
+
+func (r *NesRom) HasBattery() bool {
+	return r.Header.PersistentMemory
+}
+
+func (r *NesRom) PrgSize() uint {
+	return r.Header.PrgSize
+}
+
+func (r *NesRom) ChrSize() uint {
+	return r.Header.ChrSize
+}
+
+func (r *NesRom) PrgCrc32() Crc32 {
+	return r.PrgCrc
+}
+
+func (r *NesRom) ChrCrc32() Crc32 {
+	return r.ChrCrc
+}
+
 func (r *NesRom) RomType() RomType {
 	if r.Header.Nes2 {
 		return NES2
@@ -44,21 +86,57 @@ func (r *NesRom) RomType() RomType {
 
 func (r *NesRom) Debug() string {
 	return r.Header.Debug() +
-		fmt.Sprintf("\nRomCrc: %s\nPrgCrc: %s\nChrCrc: %s\nMiscCrc: %s", r.RomCrc.HexString(), r.PrgCrc.HexString(), r.ChrCrc.HexString(), r.MiscCrc.HexString())
+		fmt.Sprintf("\nRomCrc: %s\nTrainerCrc: %s\nPrgCrc: %s\nChrCrc: %s\nMiscCrc: %s", r.RomCrc.HexString(), r.TrainerCrc.HexString(), r.PrgCrc.HexString(), r.ChrCrc.HexString(), r.MiscCrc.HexString())
 }
 
-func (r *NesRom) WriteFile(filename string) error {
+// Bytes returns the complete ROM file, including the header.
+func (r *NesRom) Bytes() ([]byte, error) {
 	if r.Header.ChrSize != uint(len(r.Chrrom)) {
-		return fmt.Errorf("CHR Size missmatch expected $%04X, found $%04X", r.Header.ChrSize, len(r.Chrrom))
+		return nil, fmt.Errorf("CHR Size missmatch expected $%04X, found $%04X", r.Header.ChrSize, len(r.Chrrom))
 	}
 
 	if r.Header.PrgSize != uint(len(r.Prgrom)) {
-		return fmt.Errorf("PRG Size missmatch expected $%04X, found $%04X", r.Header.PrgSize, len(r.Prgrom))
+		return nil, fmt.Errorf("PRG Size missmatch expected $%04X, found $%04X", r.Header.PrgSize, len(r.Prgrom))
+	}
+
+	if r.Header.TrainerPresent && len(r.Trainer) != 512 {
+		return nil, fmt.Errorf("Trainer Size missmatch expected $0200, found $%04X", len(r.Trainer))
+	}
+
+	if r.Header.MiscSize != uint(len(r.MiscRom)) {
+		return nil, fmt.Errorf("Misc Size missmatch expected $%04X, found $%04X", r.Header.MiscSize, len(r.MiscRom))
+	}
+
+	data, err := r.Header.Bytes()
+	if err != nil {
+		return nil, err
 	}
 
-	data := r.Header.Bytes()
+	if r.Header.TrainerPresent {
+		data = append(data, r.Trainer...)
+	}
 	data = append(data, r.Prgrom...)
 	data = append(data, r.Chrrom...)
+	data = append(data, r.MiscRom...)
+
+	return data, nil
+}
+
+func (r *NesRom) WriteTo(w io.Writer) (int64, error) {
+	data, err := r.Bytes()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+func (r *NesRom) WriteFile(filename string) error {
+	data, err := r.Bytes()
+	if err != nil {
+		return err
+	}
 
 	return os.WriteFile(filename, data, 0777)
 }
@@ -67,7 +145,7 @@ func (r *NesRom) WriteFile(filename string) error {
 func ReadRom(filename string) (*NesRom, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to open %q: %w", err)
+		return nil, fmt.Errorf("Unable to open %q: %w", filename, err)
 	}
 	defer file.Close()
 
@@ -82,6 +160,10 @@ func ReadInes(reader io.Reader) (*NesRom, error) {
 
 	rom := &NesRom{}
 
+	if len(rawrom) < 16 {
+		return nil, fmt.Errorf("File too short for an iNES header: %d bytes", len(rawrom))
+	}
+
 	h, err := ParseHeader(rawrom[:16])
 	if err != nil {
 		return nil, fmt.Errorf("Error parsing header: %v", err)
@@ -94,10 +176,19 @@ func ReadInes(reader io.Reader) (*NesRom, error) {
 	prgEnd := rom.Header.PrgStart() + rom.Header.PrgSize
 	chrEnd := rom.Header.ChrStart() + rom.Header.ChrSize
 
+	if prgEnd > uint(len(rawrom)) {
+		return nil, fmt.Errorf("Sizes too large: prgEnd:%d len(nesraw):%d", prgEnd, len(rawrom))
+	}
+
 	if chrEnd > uint(len(rawrom)) {
 		return nil, fmt.Errorf("Sizes too large: chrEnd:%d len(nesraw):%d", chrEnd, len(rawrom))
 	}
 
+	if rom.Header.TrainerPresent {
+		rom.Trainer = rawrom[16:rom.Header.PrgStart()]
+		rom.TrainerCrc = Crc32(crc32.ChecksumIEEE(rom.Trainer))
+	}
+
 	rom.Prgrom = rawrom[rom.Header.PrgStart():prgEnd]
 	if rom.Header.HasChr() {
 		rom.Chrrom = rawrom[rom.Header.ChrStart():chrEnd]
@@ -108,6 +199,19 @@ func ReadInes(reader io.Reader) (*NesRom, error) {
 		rom.ChrCrc = Crc32(crc32.ChecksumIEEE(rom.Chrrom))
 	}
 
+	// Anything after the CHR (or PRG if there's no CHR) is misc ROM data.
+	// PlayChoice-10 INST-ROM and PROM data ends up here.
+	miscStart := prgEnd
+	if rom.Header.HasChr() {
+		miscStart = chrEnd
+	}
+
+	if miscStart < uint(len(rawrom)) {
+		rom.MiscRom = rawrom[miscStart:]
+		rom.MiscCrc = Crc32(crc32.ChecksumIEEE(rom.MiscRom))
+	}
+	rom.Header.MiscSize = uint(len(rom.MiscRom))
+
 	return rom, nil
 }
 
@@ -118,3 +222,11 @@ func (r *NesRom) PrgCrcString() string {
 func (r *NesRom) ChrCrcString() string {
 	return fmt.Sprintf("%08X", r.ChrCrc)
 }
+
+func (r *NesRom) TrainerCrcString() string {
+	return fmt.Sprintf("%08X", r.TrainerCrc)
+}
+
+func (r *NesRom) MiscCrcString() string {
+	return fmt.Sprintf("%08X", r.MiscCrc)
+}
@@ -0,0 +1,222 @@
+package rom
+
+import (
+	"fmt"
+)
+
+// Timing is the CPU/PPU timing mode from byte 12 of a NES 2.0 header.
+type Timing uint8
+
+const (
+	TM_NTSC  Timing = 0x00 // RP2C02
+	TM_PAL   Timing = 0x01 // RP2C07
+	TM_MULTI Timing = 0x02 // Multiple-region
+	TM_DENDY Timing = 0x03 // UA6538
+)
+
+func (t Timing) String() string {
+	switch t {
+	case TM_NTSC:
+		return "NTSC"
+	case TM_PAL:
+		return "PAL"
+	case TM_MULTI:
+		return "Multi-region"
+	case TM_DENDY:
+		return "Dendy"
+	}
+	return fmt.Sprintf("Unknown (%d)", t)
+}
+
+// VsPpuType is the low nibble of byte 13 for Vs. System ROMs.
+type VsPpuType uint8
+
+const (
+	VP_RP2C03B     VsPpuType = 0x00
+	VP_RP2C03G     VsPpuType = 0x01
+	VP_RP2C04_0001 VsPpuType = 0x02
+	VP_RP2C04_0002 VsPpuType = 0x03
+	VP_RP2C04_0003 VsPpuType = 0x04
+	VP_RP2C04_0004 VsPpuType = 0x05
+	VP_RC2C03B     VsPpuType = 0x06
+	VP_RC2C03C     VsPpuType = 0x07
+	VP_RC2C05_01   VsPpuType = 0x08
+	VP_RC2C05_02   VsPpuType = 0x09
+	VP_RC2C05_03   VsPpuType = 0x0A
+	VP_RC2C05_04   VsPpuType = 0x0B
+	VP_RC2C05_05   VsPpuType = 0x0C
+)
+
+var vsPpuNames = map[VsPpuType]string{
+	VP_RP2C03B:     "RP2C03B",
+	VP_RP2C03G:     "RP2C03G",
+	VP_RP2C04_0001: "RP2C04-0001",
+	VP_RP2C04_0002: "RP2C04-0002",
+	VP_RP2C04_0003: "RP2C04-0003",
+	VP_RP2C04_0004: "RP2C04-0004",
+	VP_RC2C03B:     "RC2C03B",
+	VP_RC2C03C:     "RC2C03C",
+	VP_RC2C05_01:   "RC2C05-01",
+	VP_RC2C05_02:   "RC2C05-02",
+	VP_RC2C05_03:   "RC2C05-03",
+	VP_RC2C05_04:   "RC2C05-04",
+	VP_RC2C05_05:   "RC2C05-05",
+}
+
+func (v VsPpuType) String() string {
+	if name, ok := vsPpuNames[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("Reserved (%d)", v)
+}
+
+// VsHardwareType is the high nibble of byte 13 for Vs. System ROMs.
+type VsHardwareType uint8
+
+const (
+	VH_UNISYSTEM              VsHardwareType = 0x00
+	VH_UNISYSTEM_RBIBASEBALL  VsHardwareType = 0x01
+	VH_UNISYSTEM_TKOBOXING    VsHardwareType = 0x02
+	VH_UNISYSTEM_SUPERXEVIOUS VsHardwareType = 0x03
+	VH_UNISYSTEM_ICECLIMBER   VsHardwareType = 0x04
+	VH_DUALSYSTEM             VsHardwareType = 0x05
+	VH_DUALSYSTEM_BUNGELING   VsHardwareType = 0x06
+)
+
+var vsHardwareNames = map[VsHardwareType]string{
+	VH_UNISYSTEM:              "Vs. Unisystem",
+	VH_UNISYSTEM_RBIBASEBALL:  "Vs. Unisystem (RBI Baseball protection)",
+	VH_UNISYSTEM_TKOBOXING:    "Vs. Unisystem (TKO Boxing protection)",
+	VH_UNISYSTEM_SUPERXEVIOUS: "Vs. Unisystem (Super Xevious protection)",
+	VH_UNISYSTEM_ICECLIMBER:   "Vs. Unisystem (Vs. Ice Climber Japan protection)",
+	VH_DUALSYSTEM:             "Vs. Dual System",
+	VH_DUALSYSTEM_BUNGELING:   "Vs. Dual System (Raid on Bungeling Bay protection)",
+}
+
+func (v VsHardwareType) String() string {
+	if name, ok := vsHardwareNames[v]; ok {
+		return name
+	}
+	return fmt.Sprintf("Reserved (%d)", v)
+}
+
+// ExtendedConsoleType is the low nibble of byte 13 when the console type is
+// CT_EXTENDED.
+type ExtendedConsoleType uint8
+
+const (
+	ECT_REGULAR       ExtendedConsoleType = 0x00
+	ECT_VSSYSTEM      ExtendedConsoleType = 0x01
+	ECT_PLAYCHOICE    ExtendedConsoleType = 0x02
+	ECT_DECIMALMODE   ExtendedConsoleType = 0x03
+	ECT_EPSM          ExtendedConsoleType = 0x04
+	ECT_VT01          ExtendedConsoleType = 0x05
+	ECT_VT02          ExtendedConsoleType = 0x06
+	ECT_VT03          ExtendedConsoleType = 0x07
+	ECT_VT09          ExtendedConsoleType = 0x08
+	ECT_VT32          ExtendedConsoleType = 0x09
+	ECT_VT369         ExtendedConsoleType = 0x0A
+	ECT_UM6578        ExtendedConsoleType = 0x0B
+	ECT_NETWORKSYSTEM ExtendedConsoleType = 0x0C
+)
+
+var extendedConsoleNames = map[ExtendedConsoleType]string{
+	ECT_REGULAR:       "Regular NES/Famicom/Dendy",
+	ECT_VSSYSTEM:      "Nintendo Vs. System",
+	ECT_PLAYCHOICE:    "Playchoice 10",
+	ECT_DECIMALMODE:   "Famiclone with Decimal Mode CPU",
+	ECT_EPSM:          "NES/Famicom with EPSM module",
+	ECT_VT01:          "V.R. Technology VT01",
+	ECT_VT02:          "V.R. Technology VT02",
+	ECT_VT03:          "V.R. Technology VT03",
+	ECT_VT09:          "V.R. Technology VT09",
+	ECT_VT32:          "V.R. Technology VT32",
+	ECT_VT369:         "V.R. Technology VT369",
+	ECT_UM6578:        "UMC UM6578",
+	ECT_NETWORKSYSTEM: "Famicom Network System",
+}
+
+func (e ExtendedConsoleType) String() string {
+	if name, ok := extendedConsoleNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("Reserved (%d)", e)
+}
+
+// ExpansionDevice is the default expansion device from byte 15 of a NES 2.0
+// header.
+type ExpansionDevice uint8
+
+const (
+	ED_UNSPECIFIED ExpansionDevice = 0x00
+	ED_STANDARD    ExpansionDevice = 0x01
+)
+
+var expansionDeviceNames = []string{
+	"Unspecified",
+	"Standard controllers",
+	"NES Four Score/Satellite",
+	"Famicom Four Players Adapter",
+	"Vs. System (1P via $4016)",
+	"Vs. System (1P via $4017)",
+	"Reserved",
+	"Vs. Zapper",
+	"Zapper ($4017)",
+	"Two Zappers",
+	"Bandai Hyper Shot Lightgun",
+	"Power Pad Side A",
+	"Power Pad Side B",
+	"Family Trainer Side A",
+	"Family Trainer Side B",
+	"Arkanoid Vaus Controller (NES)",
+	"Arkanoid Vaus Controller (Famicom)",
+	"Two Vaus Controllers plus Famicom Data Recorder",
+	"Konami Hyper Shot Controller",
+	"Coconuts Pachinko Controller",
+	"Exciting Boxing Punching Bag",
+	"Jissen Mahjong Controller",
+	"Party Tap",
+	"Oeka Kids Tablet",
+	"Sunsoft Barcode Battler",
+	"Miracle Piano Keyboard",
+	"Pokkun Moguraa",
+	"Top Rider",
+	"Double-Fisted",
+	"Famicom 3D System",
+	"Doremikko Keyboard",
+	"R.O.B. Gyro Set",
+	"Famicom Data Recorder",
+	"ASCII Turbo File",
+	"IGS Storage Battle Box",
+	"Family BASIC Keyboard plus Famicom Data Recorder",
+	"Dongda PEC-586 Keyboard",
+	"Bit Corp. Bit-79 Keyboard",
+	"Subor Keyboard",
+	"Subor Keyboard plus mouse (3x8-bit protocol)",
+	"Subor Keyboard plus mouse (24-bit protocol)",
+	"SNES Mouse",
+	"Multicart",
+	"Two SNES controllers",
+	"RacerMate Bicycle",
+	"U-Force",
+	"R.O.B. Stack-Up",
+	"City Patrolman Lightgun",
+	"Sharp C1 Cassette Interface",
+	"Standard Controller with swapped Left-Right/Up-Down/B-A",
+	"Excalibor Sudoku Pad",
+	"ABL Pinball",
+	"Golden Nugget Casino extra buttons",
+	"Golden Key Famicom keyboard",
+	"Subor Keyboard plus mouse (24-bit protocol via $4016)",
+	"Port test controller",
+	"Bandai Multi Game Player Gamepad",
+	"Venom TV Dance Mat",
+	"LG TV Remote Control",
+}
+
+func (e ExpansionDevice) String() string {
+	if int(e) < len(expansionDeviceNames) {
+		return expansionDeviceNames[e]
+	}
+	return fmt.Sprintf("Unknown (%d)", e)
+}
@@ -0,0 +1,251 @@
+package rom
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Support for the NES 2.0 XML database from the NesDev community
+// (nes20db.xml).  The database isn't included here; load a local copy with
+// LoadNes20Db().
+
+// Nes20Db holds all the games in the database, indexed by CRC32 and SHA1.
+type Nes20Db struct {
+	Date  string
+	Games []*Nes20DbGame
+
+	byRomCrc  map[Crc32][]*Nes20DbGame
+	byRomSha1 map[string]*Nes20DbGame
+	byParts   map[[2]Crc32]*Nes20DbGame
+}
+
+// Nes20DbRom is a ROM chip entry.  Size is in bytes.
+type Nes20DbRom struct {
+	Size  uint   `xml:"size,attr"`
+	Crc32 string `xml:"crc32,attr"`
+	Sha1  string `xml:"sha1,attr"`
+}
+
+// Crc returns the parsed CRC32 value.  Zero is returned if it's missing or
+// invalid.
+func (r Nes20DbRom) Crc() Crc32 {
+	val, err := strconv.ParseUint(r.Crc32, 16, 32)
+	if err != nil {
+		return 0
+	}
+	return Crc32(val)
+}
+
+type nes20DbSize struct {
+	Size uint `xml:"size,attr"`
+}
+
+// Nes20DbGame is a single game entry.  Name is taken from the comment at the
+// start of each <game> element in the database.
+type Nes20DbGame struct {
+	Name string `xml:",comment"`
+
+	Prg     Nes20DbRom `xml:"prgrom"`
+	Chr     Nes20DbRom `xml:"chrrom"`
+	Rom     Nes20DbRom `xml:"rom"` // All the ROM data, without the header
+	Trainer Nes20DbRom `xml:"trainer"`
+	Misc    struct {
+		Nes20DbRom
+		Number uint8 `xml:"number,attr"`
+	} `xml:"miscrom"`
+
+	PrgRam   nes20DbSize `xml:"prgram"`
+	PrgNvram nes20DbSize `xml:"prgnvram"`
+	ChrRam   nes20DbSize `xml:"chrram"`
+	ChrNvram nes20DbSize `xml:"chrnvram"`
+
+	Pcb struct {
+		Mapper    uint   `xml:"mapper,attr"`
+		SubMapper uint8  `xml:"submapper,attr"`
+		Mirroring string `xml:"mirroring,attr"` // H, V, or 4
+		Battery   uint8  `xml:"battery,attr"`
+	} `xml:"pcb"`
+
+	Console struct {
+		Type   uint8 `xml:"type,attr"`
+		Region uint8 `xml:"region,attr"`
+	} `xml:"console"`
+
+	Vs struct {
+		Hardware uint8 `xml:"hardware,attr"`
+		Ppu      uint8 `xml:"ppu,attr"`
+	} `xml:"vs"`
+
+	Expansion struct {
+		Type uint8 `xml:"type,attr"`
+	} `xml:"expansion"`
+}
+
+func LoadNes20Db(filename string) (*Nes20Db, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %q: %w", filename, err)
+	}
+	defer file.Close()
+
+	return ParseNes20Db(file)
+}
+
+func ParseNes20Db(r io.Reader) (*Nes20Db, error) {
+	raw := struct {
+		Date  string         `xml:"date,attr"`
+		Games []*Nes20DbGame `xml:"game"`
+	}{}
+
+	err := xml.NewDecoder(r).Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading NES 2.0 database: %w", err)
+	}
+
+	db := &Nes20Db{
+		Date:      raw.Date,
+		Games:     raw.Games,
+		byRomCrc:  make(map[Crc32][]*Nes20DbGame),
+		byRomSha1: make(map[string]*Nes20DbGame),
+		byParts:   make(map[[2]Crc32]*Nes20DbGame),
+	}
+
+	for _, g := range db.Games {
+		g.Name = strings.TrimSpace(g.Name)
+
+		if crc := g.Rom.Crc(); crc != 0 {
+			db.byRomCrc[crc] = append(db.byRomCrc[crc], g)
+		}
+
+		if g.Rom.Sha1 != "" {
+			db.byRomSha1[strings.ToUpper(g.Rom.Sha1)] = g
+		}
+
+		if g.Prg.Size > 0 {
+			db.byParts[[2]Crc32{g.Prg.Crc(), g.Chr.Crc()}] = g
+		}
+	}
+
+	return db, nil
+}
+
+// Find looks up a game by its ROM data.  This is everything in the file after
+// the 16 byte header.  A trainer at the start of the data is skipped if the
+// full data doesn't match.  Nil is returned if nothing matches.
+func (db *Nes20Db) Find(data []byte) *Nes20DbGame {
+	candidates := [][]byte{data}
+	if len(data) > 512 {
+		candidates = append(candidates, data[512:])
+	}
+
+	for _, c := range candidates {
+		sum := sha1.Sum(c)
+		if g, ok := db.byRomSha1[strings.ToUpper(hex.EncodeToString(sum[:]))]; ok {
+			return g
+		}
+
+		crc := Crc32(crc32.ChecksumIEEE(c))
+		for _, g := range db.byRomCrc[crc] {
+			if g.Rom.Size == uint(len(c)) {
+				return g
+			}
+		}
+	}
+
+	return nil
+}
+
+// FindRom looks up a game by its PRG and CHR CRC32s, falling back to the
+// combined PRG and CHR data.
+func (db *Nes20Db) FindRom(r *NesRom) *Nes20DbGame {
+	if g, ok := db.byParts[[2]Crc32{r.PrgCrc32(), r.ChrCrc32()}]; ok {
+		return g
+	}
+
+	return db.Find(append(append([]byte{}, r.Prgrom...), r.Chrrom...))
+}
+
+// FindHeader looks up the correct header for a complete ROM file, header
+// included.  Only the ROM data is used for the lookup, so the existing header
+// can be completely wrong.
+func (db *Nes20Db) FindHeader(raw []byte) (*Header, *Nes20DbGame, error) {
+	if len(raw) < 16 {
+		return nil, nil, fmt.Errorf("File too short: %d bytes", len(raw))
+	}
+
+	g := db.Find(raw[16:])
+	if g == nil {
+		return nil, nil, fmt.Errorf("ROM not found in the database")
+	}
+
+	return g.Header(), g, nil
+}
+
+// Header builds a NES 2.0 header from the database entry.
+func (g *Nes20DbGame) Header() *Header {
+	h := &Header{
+		PrgSize:          g.Prg.Size,
+		ChrSize:          g.Chr.Size,
+		MiscSize:         g.Misc.Size,
+		TrainerPresent:   g.Trainer.Size > 0,
+		PersistentMemory: g.Pcb.Battery != 0,
+		Mirroring:        M_HORIZONTAL,
+		Nes2:             true,
+		Mapper:           g.Pcb.Mapper,
+		Nes2Mapper:       uint16(g.Pcb.Mapper),
+		SubMapper:        g.Pcb.SubMapper,
+		Timing:           Timing(g.Console.Region & 0x03),
+		MiscRomCount:     g.Misc.Number,
+		ExpansionDevice:  ExpansionDevice(g.Expansion.Type),
+	}
+
+	switch g.Pcb.Mirroring {
+	case "V":
+		h.Mirroring = M_VERTICAL
+	case "4":
+		h.Mirroring = M_IGNORE
+		h.AltNametables = true
+	}
+
+	// Console types above 2 are extended console types.
+	switch {
+	case g.Console.Type == uint8(CT_VSSYSTEM):
+		h.Console = CT_VSSYSTEM
+		h.VsHardware = VsHardwareType(g.Vs.Hardware)
+		h.VsPpu = VsPpuType(g.Vs.Ppu)
+	case g.Console.Type < uint8(CT_EXTENDED):
+		h.Console = ConsoleType(g.Console.Type)
+	default:
+		h.Console = CT_EXTENDED
+		h.ExtendedConsole = ExtendedConsoleType(g.Console.Type)
+	}
+
+	if g.Misc.Size > 0 && h.MiscRomCount == 0 {
+		h.MiscRomCount = 1
+	}
+
+	sizes := []struct {
+		bytes uint
+		val   *uint
+	}{
+		{g.PrgRam.Size, &h.PrgRamSize},
+		{g.PrgNvram.Size, &h.PrgNvramSize},
+		{g.ChrRam.Size, &h.ChrRamSize},
+		{g.ChrNvram.Size, &h.ChrNvramSize},
+	}
+
+	for _, s := range sizes {
+		if s.bytes > 0 {
+			*s.val = unshift(s.bytes)
+		}
+	}
+
+	return h
+}
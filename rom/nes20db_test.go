@@ -0,0 +1,88 @@
+package rom
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestNes20Db(t *testing.T) {
+	prg := bytes.Repeat([]byte{0xEA}, 32*1024)
+	chr := bytes.Repeat([]byte{0x55}, 8*1024)
+	data := append(append([]byte{}, prg...), chr...)
+	sum := sha1.Sum(data)
+
+	xml := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<nes20db date="2024-01-01">
+	<game>
+		<!-- Test Game (USA).nes -->
+		<prgrom size="32768" crc32="%08X"/>
+		<chrrom size="8192" crc32="%08X"/>
+		<rom size="40960" crc32="%08X" sha1="%s"/>
+		<prgnvram size="8192"/>
+		<pcb mapper="1" submapper="5" mirroring="V" battery="1"/>
+		<console type="0" region="1"/>
+		<expansion type="1"/>
+	</game>
+</nes20db>`,
+		crc32.ChecksumIEEE(prg),
+		crc32.ChecksumIEEE(chr),
+		crc32.ChecksumIEEE(data),
+		strings.ToUpper(hex.EncodeToString(sum[:])),
+	)
+
+	db, err := ParseNes20Db(strings.NewReader(xml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A DiskDude! header with the wrong mapper and mirroring
+	raw := append([]byte("NES\x1A\x02\x01\x30DiskDude!"), data...)
+
+	h, g, err := db.FindHeader(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Name != "Test Game (USA).nes" {
+		t.Errorf("Unexpected name: %q", g.Name)
+	}
+
+	if h.Mapper != 1 || h.SubMapper != 5 || h.Mirroring != M_VERTICAL || !h.PersistentMemory || h.Timing != TM_PAL || h.PrgNvramSize != 7 {
+		t.Errorf("Bad header:\n%s", h.Debug())
+	}
+
+	old, err := ParseHeader(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]bool{}
+	for _, c := range old.Diff(h) {
+		fields[c.Field] = true
+	}
+
+	for _, f := range []string{"Mapper", "Mirroring", "Nes2", "Archaic"} {
+		if !fields[f] {
+			t.Errorf("Missing %s in the header diff", f)
+		}
+	}
+
+	fixed, err := h.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rom, err := ReadInes(bytes.NewReader(append(fixed, data...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.FindRom(rom) != g {
+		t.Errorf("Lookup by PRG and CHR CRC failed")
+	}
+}
@@ -0,0 +1,56 @@
+package rom
+
+import (
+	"bytes"
+	"hash/crc32"
+	"testing"
+)
+
+// Read a ROM with a trainer and misc data, then write it back out.
+func TestInesTrainerMisc(t *testing.T) {
+	h := &Header{
+		PrgSize:        16 * 1024,
+		ChrSize:        8 * 1024,
+		TrainerPresent: true,
+		Nes2:           true,
+		MiscRomCount:   1,
+	}
+
+	raw, err := h.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trainer := bytes.Repeat([]byte{0x11}, 512)
+	misc := []byte{0xDE, 0xAD, 0xBE, 0xEF, 0x01}
+	raw = append(raw, trainer...)
+	raw = append(raw, bytes.Repeat([]byte{0x22}, int(h.PrgSize))...)
+	raw = append(raw, bytes.Repeat([]byte{0x33}, int(h.ChrSize))...)
+	raw = append(raw, misc...)
+
+	rom, err := ReadInes(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(rom.Trainer, trainer) {
+		t.Errorf("Trainer mismatch")
+	}
+
+	if rom.Header.MiscSize != uint(len(misc)) || !bytes.Equal(rom.MiscRom, misc) {
+		t.Errorf("Misc mismatch: size %d, data %X", rom.Header.MiscSize, rom.MiscRom)
+	}
+
+	if rom.MiscCrc != Crc32(crc32.ChecksumIEEE(misc)) {
+		t.Errorf("Misc CRC mismatch: %s", rom.MiscCrcString())
+	}
+
+	buf := &bytes.Buffer{}
+	if _, err = rom.WriteTo(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), raw) {
+		t.Errorf("Written ROM does not match the original")
+	}
+}
@@ -9,18 +9,51 @@ import (
 	//"github.com/zorchenhimer/go-nes/rom/unif"
 )
 
+// Rom is implemented by all the supported ROM formats.  Values that only
+// exist in the NES 2.0 header are converted for other formats.
 type Rom interface {
 	RomType() RomType
+
 	PrgRom() []byte
 	ChrRom() []byte
+	TrainerRom() []byte // nil if there is no trainer
+
+	// NesHeader returns the ROM's NES 2.0 header.  An error is returned if
+	// the format can't be represented with one.
+	NesHeader() (*Header, error)
+
+	// Mapper numbers are zero if they cannot be determined.  Use NesHeader()
+	// to find out why.
+	MapperNumber() uint
+	SubMapperNumber() uint8
+
+	MirrorType() MirrorType
+	HasBattery() bool
+
+	// Sizes are in bytes
+	PrgSize() uint
+	ChrSize() uint
+
+	PrgCrc32() Crc32
+	ChrCrc32() Crc32
+
+	// WriteTo writes the ROM in its original format.
+	WriteTo(w io.Writer) (int64, error)
 }
 
+var (
+	_ Rom = &NesRom{}
+	_ Rom = &UnifRom{}
+	_ Rom = &FdsRom{}
+)
+
 type RomType string
 
 const (
 	UNIF RomType = "UNIF"
 	INES RomType = "iNES"
 	NES2 RomType = "NES 2.0"
+	FDS  RomType = "FDS"
 )
 
 func LoadRom(filename string) (Rom, error) {
@@ -50,7 +83,23 @@ func Load(r io.ReadSeeker) (Rom, error) {
 
 	} else if bytes.Equal(magic, []byte{0x4E, 0x45, 0x53, 0x1A}) { // NES<EOF>
 		return ReadInes(r)
+
+	} else if isFds(magic) {
+		return ReadFds(r)
 	}
 
 	return nil, fmt.Errorf("Unknown magic bytes: %q 0x%08X", magic, magic)
 }
+
+// countWriter keeps track of the number of bytes written for WriteTo()
+// implementations.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -5,47 +5,26 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 )
 
 type Remap struct {
-	Pattern   string
+	Pattern   string // board name
 	Mapper    uint
 	Submapper uint8
 	Mirroring MirrorType
-	PrgRam    uint
-	ChrRam    uint
+	PrgRam    uint // in bytes
+	PrgNvram  uint // in bytes
+	ChrRam    uint // in bytes
 }
 
-// Board name -> mapper, submapper, etc
-var UnifRemap = []Remap{
-	Remap{Pattern: "*-8237", Mapper: 215},
-	Remap{Pattern: "*-8237a", Mapper: 215, Submapper: 1},
-	Remap{Pattern: "*-cnrom", Mapper: 3, Submapper: 2},
-	Remap{Pattern: "*-h2288", Mapper: 123},
-	Remap{Pattern: "*-kof97", Mapper: 263},
-	Remap{Pattern: "*-sa-0036", Mapper: 149},
-	Remap{Pattern: "*-sa-0037", Mapper: 148},
-	Remap{Pattern: "*-sa-016-1m", Mapper: 79},
-	Remap{Pattern: "*-sa-72007", Mapper: 145},
-	Remap{Pattern: "*-sa-72008", Mapper: 133},
-	Remap{Pattern: "*-sa-nrom", Mapper: 143},
-	Remap{Pattern: "*-sachen-74ls374n", Mapper: 150},
-	Remap{Pattern: "*-sachen-8259a", Mapper: 141},
-	Remap{Pattern: "*-sachen-8259b", Mapper: 138},
-	Remap{Pattern: "*-sachen-8259c", Mapper: 139},
-	Remap{Pattern: "*-sachen-8259d", Mapper: 137},
-	Remap{Pattern: "*-shero", Mapper: 262, Mirroring: M_IGNORE},
-	Remap{Pattern: "*-tc-u01-1.5m", Mapper: 147},
-	Remap{Pattern: "*-tlrom", Mapper: 4},
-	Remap{Pattern: "dreamtech01", Mapper: 521, ChrRam: 8},
-	Remap{Pattern: "nes-nrom-128", Mapper: 0},
-	Remap{Pattern: "nes-nrom-256", Mapper: 0},
-}
+// Board name -> mapper, submapper, etc.  This is loaded from unif_boards.txt
+// and can be replaced with LoadUnifBoards().
+var UnifRemap []Remap
 
 type UnifRom struct {
 	Version int
@@ -64,70 +43,48 @@ type UnifRom struct {
 	Battery     bool
 	ChrRam      bool // ignored by emulators
 	Mirroring   byte
-}
-
-func (r *UnifRom) Ines() []byte {
-	prg := []byte{}
-	prgSlice := ChunkSlice(r.PrgData)
-	sort.Sort(prgSlice)
-
-	for _, chunk := range prgSlice {
-		prg = append(prg, chunk.Data...)
-	}
-
-	chr := []byte{}
-	chrSlice := ChunkSlice(r.ChrData)
-	sort.Sort(chrSlice)
-
-	for _, chunk := range chrSlice {
-		chr = append(chr, chunk.Data...)
-	}
-
-	header := Header{
-		PrgSize:          uint(len(prg)),
-		ChrSize:          uint(len(chr)),
-		PersistentMemory: r.Battery,
-	}
 
-	found := false
-	fmt.Printf("Looking for %q\n", strings.ToLower(r.Mapper))
-	for _, remap := range UnifRemap {
-		match, err := filepath.Match(remap.Pattern, strings.ToLower(r.Mapper))
-		if err != nil {
-			panic(err)
-		}
-
-		if !match {
-			continue
-		}
-
-		found = true
-		header.Mapper = remap.Mapper
-		header.Nes2Mapper = uint16(remap.Mapper)
-		header.Mirroring = remap.Mirroring
+	// Chunks that aren't recognized.  These are written back out as-is.
+	UnknownChunks map[string][]byte
+}
 
-		if remap.PrgRam != 0 {
-			header.PrgRamSize = unshift(remap.PrgRam)
-		}
+// Values for the MIRR chunk
+const (
+	UM_HORIZONTAL byte = 0x00
+	UM_VERTICAL   byte = 0x01
+	UM_SINGLE_A   byte = 0x02 // all pages from $2000
+	UM_SINGLE_B   byte = 0x03 // all pages from $2400
+	UM_FOURSCREEN byte = 0x04
+	UM_MAPPER     byte = 0x05 // mapper controlled
+)
 
-		if remap.ChrRam != 0 {
-			header.ChrRamSize = unshift(remap.ChrRam)
-		}
+// Values for the TVCI chunk
+const (
+	UT_NTSC byte = 0x00
+	UT_PAL  byte = 0x01
+	UT_BOTH byte = 0x02
+)
 
-		break
+// Ines returns the ROM converted to a NES 2.0 file.
+func (r *UnifRom) Ines() ([]byte, error) {
+	nes, err := r.NesRom()
+	if err != nil {
+		return nil, err
 	}
 
-	if !found {
-		panic(r.Mapper + " not implemented")
+	raw, err := nes.Header.Bytes()
+	if err != nil {
+		return nil, err
 	}
 
-	raw := header.Bytes()
-	raw = append(raw, prg...)
-	raw = append(raw, chr...)
+	raw = append(raw, nes.Prgrom...)
+	raw = append(raw, nes.Chrrom...)
 
-	return raw
+	return raw, nil
 }
 
+// unshift converts a size in bytes to the shift count used in the NES 2.0
+// header (64 << count).
 func unshift(val uint) uint {
 	count := uint(0)
 	for val > 64 {
@@ -137,6 +94,19 @@ func unshift(val uint) uint {
 	return count
 }
 
+// joinChunks concatenates chunk data in ID order.
+func joinChunks(chunks []*ChunkData) []byte {
+	sorted := make(ChunkSlice, len(chunks))
+	copy(sorted, chunks)
+	sort.Sort(sorted)
+
+	data := []byte{}
+	for _, chunk := range sorted {
+		data = append(data, chunk.Data...)
+	}
+	return data
+}
+
 func (r *UnifRom) Debug(w io.Writer) error {
 	_, err := fmt.Fprintf(w, `%s
 	Mapper: %s
@@ -172,11 +142,92 @@ func (r *UnifRom) RomType() RomType {
 }
 
 func (r *UnifRom) PrgRom() []byte {
-	return []byte{}
+	return joinChunks(r.PrgData)
 }
 
 func (r *UnifRom) ChrRom() []byte {
-	return []byte{}
+	return joinChunks(r.ChrData)
+}
+
+// TrainerRom always returns nil.  UNIF has no trainer chunk.
+func (r *UnifRom) TrainerRom() []byte {
+	return nil
+}
+
+func (r *UnifRom) NesHeader() (*Header, error) {
+	nes, err := r.NesRom()
+	if err != nil {
+		return nil, err
+	}
+	return nes.Header, nil
+}
+
+func (r *UnifRom) MapperNumber() uint {
+	remap, err := FindRemap(r.Mapper)
+	if err != nil {
+		return 0
+	}
+	return remap.Mapper
+}
+
+func (r *UnifRom) SubMapperNumber() uint8 {
+	remap, err := FindRemap(r.Mapper)
+	if err != nil {
+		return 0
+	}
+	return remap.Submapper
+}
+
+// MirrorType returns the mirroring from the MIRR chunk, falling back to the
+// board's default mirroring.
+func (r *UnifRom) MirrorType() MirrorType {
+	if r.hasChunk("MIRR") {
+		switch r.Mirroring {
+		case UM_VERTICAL:
+			return M_VERTICAL
+		case UM_FOURSCREEN:
+			return M_IGNORE
+		case UM_HORIZONTAL:
+			return M_HORIZONTAL
+		}
+	}
+
+	remap, err := FindRemap(r.Mapper)
+	if err != nil {
+		return M_HORIZONTAL
+	}
+	return remap.Mirroring
+}
+
+func (r *UnifRom) HasBattery() bool {
+	return r.Battery
+}
+
+func (r *UnifRom) PrgSize() uint {
+	return uint(len(r.PrgRom()))
+}
+
+func (r *UnifRom) ChrSize() uint {
+	return uint(len(r.ChrRom()))
+}
+
+func (r *UnifRom) PrgCrc32() Crc32 {
+	return Crc32(crc32.ChecksumIEEE(r.PrgRom()))
+}
+
+func (r *UnifRom) ChrCrc32() Crc32 {
+	chr := r.ChrRom()
+	if len(chr) == 0 {
+		return 0
+	}
+	return Crc32(crc32.ChecksumIEEE(chr))
+}
+
+// WriteTo writes the ROM in the UNIF format.  See Write().
+func (r *UnifRom) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	err := r.Write(cw)
+	return cw.n, err
 }
 
 // PRG or CHR
@@ -426,6 +477,12 @@ func ReadUnif(r io.Reader) (*UnifRom, error) {
 
 		case "MIRR":
 			rom.Mirroring = rawVal[0]
+
+		default:
+			if rom.UnknownChunks == nil {
+				rom.UnknownChunks = make(map[string][]byte)
+			}
+			rom.UnknownChunks[chunkType] = rawVal
 		}
 	}
 
@@ -435,3 +492,232 @@ func ReadUnif(r io.Reader) (*UnifRom, error) {
 func trimnul(str string) string {
 	return strings.Trim(str, "\x00")
 }
+
+func (r *UnifRom) hasChunk(name string) bool {
+	for _, c := range r.Chunks {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// chunkOrder returns the order chunks should be written in.  Chunks that were
+// read from a file keep their original order.  Anything that wasn't in the
+// original file is added after.
+func (r *UnifRom) chunkOrder() []string {
+	order := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		order = append(order, name)
+	}
+
+	for _, c := range r.Chunks {
+		add(c)
+	}
+
+	add("MAPR")
+	if r.Name != "" {
+		add("NAME")
+	}
+	if r.Writer != "" {
+		add("WRTR")
+	}
+	if r.Read != "" {
+		add("READ")
+	}
+	if len(r.DumpInfo) > 0 {
+		add("DINF")
+	}
+	if r.Battery {
+		add("BATR")
+	}
+	if r.ChrRam {
+		add("VROR")
+	}
+
+	prg := ChunkSlice(r.PrgData)
+	sort.Sort(prg)
+	for _, c := range prg {
+		if c.Data != nil {
+			add(fmt.Sprintf("PRG%X", c.Id))
+		}
+		add(fmt.Sprintf("PCK%X", c.Id))
+	}
+
+	chr := ChunkSlice(r.ChrData)
+	sort.Sort(chr)
+	for _, c := range chr {
+		if c.Data != nil {
+			add(fmt.Sprintf("CHR%X", c.Id))
+		}
+		add(fmt.Sprintf("CCK%X", c.Id))
+	}
+
+	unknown := []string{}
+	for name := range r.UnknownChunks {
+		unknown = append(unknown, name)
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		add(name)
+	}
+
+	return order
+}
+
+func findChunk(chunks []*ChunkData, id int) *ChunkData {
+	for _, c := range chunks {
+		if c.Id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// chunkValue returns the raw data for the given chunk type.  Nil is returned
+// if there is nothing to write for the chunk.
+func (r *UnifRom) chunkValue(name string) ([]byte, error) {
+	switch name {
+	case "MAPR":
+		return nulString(r.Mapper), nil
+	case "NAME":
+		return nulString(r.Name), nil
+	case "WRTR":
+		return nulString(r.Writer), nil
+	case "READ":
+		return nulString(r.Read), nil
+
+	case "DINF":
+		if len(r.DumpInfo) > chunkLengths["DINF"] {
+			return nil, fmt.Errorf("DINF data too long: %d bytes", len(r.DumpInfo))
+		}
+		val := make([]byte, chunkLengths["DINF"])
+		copy(val, r.DumpInfo)
+		return val, nil
+
+	case "TVCI":
+		return []byte{r.TvStandard}, nil
+	case "CTRL":
+		return []byte{r.Controllers}, nil
+	case "BATR":
+		return []byte{boolByte(r.Battery)}, nil
+	case "VROR":
+		return []byte{boolByte(r.ChrRam)}, nil
+	case "MIRR":
+		return []byte{r.Mirroring}, nil
+	}
+
+	if val, ok := r.UnknownChunks[name]; ok {
+		return val, nil
+	}
+
+	if len(name) != 4 {
+		return nil, fmt.Errorf("Invalid chunk type %q", name)
+	}
+
+	id64, err := strconv.ParseInt(name[3:], 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid chunk type %q", name)
+	}
+	id := int(id64)
+
+	var chunk *ChunkData
+	switch name[:3] {
+	case "PRG", "PCK":
+		chunk = findChunk(r.PrgData, id)
+	case "CHR", "CCK":
+		chunk = findChunk(r.ChrData, id)
+	default:
+		return nil, fmt.Errorf("Unknown chunk type %q", name)
+	}
+
+	if chunk == nil {
+		return nil, nil
+	}
+
+	switch name[:3] {
+	case "PRG", "CHR":
+		return chunk.Data, nil
+	}
+
+	// CRC chunks
+	if chunk.Crc != nil {
+		return chunk.Crc, nil
+	}
+
+	if chunk.Data == nil {
+		return nil, nil
+	}
+
+	crc := make([]byte, 4)
+	binary.LittleEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk.Data))
+	return crc, nil
+}
+
+// Write writes the ROM in the UNIF format.  A CRC chunk is written for every
+// PRG and CHR chunk.  Stored CRCs are written as-is, missing ones are
+// calculated.
+func (r *UnifRom) Write(w io.Writer) error {
+	if r.Mapper == "" {
+		return fmt.Errorf("Missing board name")
+	}
+
+	header := make([]byte, 32)
+	copy(header, []byte("UNIF"))
+	binary.LittleEndian.PutUint32(header[4:], uint32(r.Version))
+
+	_, err := w.Write(header)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range r.chunkOrder() {
+		val, err := r.chunkValue(name)
+		if err != nil {
+			return err
+		}
+
+		if len(val) == 0 {
+			continue
+		}
+
+		if l, ok := chunkLengths[name]; ok && l != len(val) {
+			return fmt.Errorf("Chunk %s has invalid length %d", name, len(val))
+		}
+
+		chunkHeader := make([]byte, 8)
+		copy(chunkHeader, []byte(name))
+		binary.LittleEndian.PutUint32(chunkHeader[4:], uint32(len(val)))
+
+		_, err = w.Write(chunkHeader)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(val)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func nulString(str string) []byte {
+	if str == "" {
+		return nil
+	}
+	return append([]byte(str), 0x00)
+}
+
+func boolByte(val bool) byte {
+	if val {
+		return 0x01
+	}
+	return 0x00
+}
@@ -0,0 +1,178 @@
+package rom
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//go:embed unif_boards.txt
+var unifBoardData string
+
+func init() {
+	err := LoadUnifBoards(strings.NewReader(unifBoardData))
+	if err != nil {
+		panic(fmt.Sprintf("Invalid embedded UNIF board data: %v", err))
+	}
+}
+
+// Prefixes that are ignored when a board name isn't found as-is.
+var unifBoardPrefixes = []string{"NES-", "HVC-", "UNL-", "BMC-", "BTL-"}
+
+// LoadUnifBoards replaces UnifRemap with the board data read from r.  See
+// unif_boards.txt for the format.
+func LoadUnifBoards(r io.Reader) error {
+	boards, err := ParseUnifBoards(r)
+	if err != nil {
+		return err
+	}
+
+	UnifRemap = boards
+	return nil
+}
+
+// ParseUnifBoards reads board data in the format of unif_boards.txt.
+func ParseUnifBoards(r io.Reader) ([]Remap, error) {
+	boards := []Remap{}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 fields, found %d", lineNum, len(fields))
+		}
+
+		mapper, err := strconv.ParseUint(fields[1], 10, 12)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid mapper %q", lineNum, fields[1])
+		}
+
+		submapper, err := strconv.ParseUint(fields[2], 10, 4)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid submapper %q", lineNum, fields[2])
+		}
+
+		remap := Remap{
+			Pattern:   fields[0],
+			Mapper:    uint(mapper),
+			Submapper: uint8(submapper),
+		}
+
+		switch strings.ToUpper(fields[3]) {
+		case "H", "-":
+			remap.Mirroring = M_HORIZONTAL
+		case "V":
+			remap.Mirroring = M_VERTICAL
+		case "4":
+			remap.Mirroring = M_IGNORE
+		default:
+			return nil, fmt.Errorf("line %d: invalid mirroring %q", lineNum, fields[3])
+		}
+
+		sizes := []*uint{&remap.PrgRam, &remap.PrgNvram, &remap.ChrRam}
+		for i, size := range sizes {
+			*size, err = parseBoardSize(fields[4+i])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+		}
+
+		boards = append(boards, remap)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return boards, nil
+}
+
+func parseBoardSize(val string) (uint, error) {
+	mult := uint64(1)
+	if strings.HasSuffix(strings.ToLower(val), "k") {
+		mult = 1024
+		val = val[:len(val)-1]
+	}
+
+	size, err := strconv.ParseUint(val, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", val)
+	}
+
+	return uint(size * mult), nil
+}
+
+func stripBoardPrefix(board string) string {
+	for _, prefix := range unifBoardPrefixes {
+		if strings.HasPrefix(strings.ToUpper(board), prefix) {
+			return board[len(prefix):]
+		}
+	}
+	return board
+}
+
+// FindRemap returns the entry in UnifRemap for the given board name.  The
+// lookup is case-insensitive.  If there's no exact match, the lookup is done
+// again ignoring the NES-, HVC-, UNL-, BMC- and BTL- prefixes.
+func FindRemap(board string) (*Remap, error) {
+	board = trimnul(board)
+	lower := strings.ToLower(board)
+
+	for _, remap := range UnifRemap {
+		if strings.EqualFold(remap.Pattern, board) {
+			r := remap
+			return &r, nil
+		}
+
+		if !strings.Contains(remap.Pattern, "*") {
+			continue
+		}
+
+		match, err := filepath.Match(strings.ToLower(remap.Pattern), lower)
+		if err != nil {
+			return nil, fmt.Errorf("Bad pattern %q: %w", remap.Pattern, err)
+		}
+
+		if match {
+			r := remap
+			return &r, nil
+		}
+	}
+
+	stripped := stripBoardPrefix(board)
+	for _, remap := range UnifRemap {
+		if strings.EqualFold(stripBoardPrefix(remap.Pattern), stripped) {
+			r := remap
+			return &r, nil
+		}
+	}
+
+	return nil, fmt.Errorf("UNIF board %q not implemented", board)
+}
+
+// UnifBoardName returns the canonical UNIF board name for the given mapper
+// and submapper.  This is the first matching entry in UnifRemap.
+func UnifBoardName(mapper uint, submapper uint8) (string, error) {
+	for _, remap := range UnifRemap {
+		if strings.Contains(remap.Pattern, "*") {
+			continue
+		}
+
+		if remap.Mapper == mapper && remap.Submapper == submapper {
+			return remap.Pattern, nil
+		}
+	}
+
+	return "", fmt.Errorf("No UNIF board for mapper %d submapper %d", mapper, submapper)
+}
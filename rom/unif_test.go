@@ -0,0 +1,123 @@
+package rom
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Write a UNIF rom, read it back, then write it again.  Both writes should
+// produce identical data.
+func TestUnifRecode(t *testing.T) {
+	u := &UnifRom{
+		Version:    7,
+		Name:       "Test ROM",
+		Mapper:     "NES-TLROM",
+		TvStandard: UT_PAL,
+		Battery:    true,
+		Mirroring:  UM_VERTICAL,
+		Chunks:     []string{"MAPR", "NAME", "TVCI", "MIRR"},
+		PrgData: []*ChunkData{
+			&ChunkData{Id: 1, Data: bytes.Repeat([]byte{0xEA}, 16*1024)},
+			&ChunkData{Id: 0, Data: bytes.Repeat([]byte{0x60}, 16*1024)},
+		},
+		ChrData: []*ChunkData{
+			&ChunkData{Id: 0, Data: bytes.Repeat([]byte{0xFF}, 8*1024)},
+		},
+	}
+
+	first := &bytes.Buffer{}
+	if err := u.Write(first); err != nil {
+		t.Fatal(err)
+	}
+
+	u2, err := ReadUnif(bytes.NewReader(first.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u2.Name != u.Name || u2.Mapper != u.Mapper || !u2.Battery {
+		t.Errorf("Field mismatch: %q %q %t", u2.Name, u2.Mapper, u2.Battery)
+	}
+
+	for _, c := range u2.PrgData {
+		if len(c.Crc) != 4 {
+			t.Errorf("Missing CRC for PRG%X", c.Id)
+		}
+	}
+
+	second := &bytes.Buffer{}
+	if err := u2.Write(second); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("Second write does not match the first")
+	}
+
+	nes, err := u2.NesRom()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if nes.Header.Mapper != 4 || nes.Header.Mirroring != M_VERTICAL || nes.Header.Timing != TM_PAL || !nes.Header.PersistentMemory {
+		t.Errorf("Bad converted header:\n%s", nes.Header.Debug())
+	}
+
+	if nes.Prgrom[0] != 0x60 {
+		t.Errorf("PRG chunks out of order")
+	}
+
+	if _, err := nes.Unif(); err != nil {
+		t.Errorf("Converting back to UNIF failed: %v", err)
+	}
+
+	nes.Header.Timing = TM_DENDY
+	if _, err := nes.Unif(); err == nil {
+		t.Errorf("Expected an error for Dendy timing")
+	}
+
+	nes.Header.Timing = TM_PAL
+	nes.Header.Console = CT_VSSYSTEM
+	if _, err := nes.Unif(); err == nil {
+		t.Errorf("Expected an error for a Vs. System ROM")
+	}
+}
+
+func TestUnifBoardLookup(t *testing.T) {
+	tests := []struct {
+		board     string
+		mapper    uint
+		submapper uint8
+	}{
+		{"NES-TLROM", 4, 0},
+		{"hvc-tlrom", 4, 0},
+		{"NES-SEROM", 1, 5},
+		{"BMC-8237a", 215, 1},
+		{"UNL-SACHEN-8259A", 141, 0},
+	}
+
+	for _, tt := range tests {
+		remap, err := FindRemap(tt.board)
+		if err != nil {
+			t.Errorf("[%s] %v", tt.board, err)
+			continue
+		}
+
+		if remap.Mapper != tt.mapper || remap.Submapper != tt.submapper {
+			t.Errorf("[%s] expected %d.%d, found %d.%d", tt.board, tt.mapper, tt.submapper, remap.Mapper, remap.Submapper)
+		}
+	}
+
+	if _, err := FindRemap("NES-NOTABOARD"); err == nil {
+		t.Errorf("Expected an error for an unknown board")
+	}
+
+	name, err := UnifBoardName(1, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "NES-SEROM" {
+		t.Errorf("Expected NES-SEROM for 1.5, found %s", name)
+	}
+}
@@ -0,0 +1,188 @@
+package rom
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type Severity uint8
+
+const (
+	SV_WARNING Severity = iota
+	SV_ERROR
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SV_WARNING:
+		return "warning"
+	case SV_ERROR:
+		return "error"
+	}
+	return fmt.Sprintf("Unknown (%d)", uint8(s))
+}
+
+// HeaderCheck identifies the check that produced a Finding.
+type HeaderCheck string
+
+const (
+	HC_HEADER      HeaderCheck = "header"      // Header can't be parsed
+	HC_JUNK        HeaderCheck = "junk"        // Garbage in unused bytes
+	HC_RESERVED    HeaderCheck = "reserved"    // Reserved NES 2.0 bits are set
+	HC_TRAINER     HeaderCheck = "trainer"     // Trainer on a mapper that never used one
+	HC_PRG_SIZE    HeaderCheck = "prg-size"    // Odd PRG size
+	HC_CHR_SIZE    HeaderCheck = "chr-size"    // Odd CHR size
+	HC_FILE_SIZE   HeaderCheck = "file-size"   // File length doesn't match the header
+	HC_RAM         HeaderCheck = "ram"         // RAM sizes that don't fit the mapper
+	HC_FOUR_SCREEN HeaderCheck = "four-screen" // Four-screen and vertical mirroring both set
+)
+
+// Finding is a single problem found by Validate().
+type Finding struct {
+	Severity Severity
+	Check    HeaderCheck
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Check, f.Message)
+}
+
+// Mappers known to have used trainers.  These are mostly copier hacks.
+var trainerMappers = map[uint]bool{0: true, 1: true, 2: true, 3: true, 4: true, 6: true, 8: true, 17: true}
+
+// Discrete logic mappers that have no PRG RAM.
+var noPrgRamMappers = map[uint]bool{2: true, 3: true, 7: true, 11: true, 66: true}
+
+// Mappers that use the four-screen bit together with the mirroring bit for
+// something else.
+var fourScreenMappers = map[uint]bool{30: true, 218: true}
+
+// Largest PRG and CHR sizes for the simplest mappers.
+var mapperMaxSizes = map[uint][2]uint{
+	0: {32 * 1024, 8 * 1024},    // NROM
+	3: {32 * 1024, 2048 * 1024}, // CNROM
+}
+
+// Validate checks the header for values that are legal but probably wrong.
+func (h *Header) Validate() []Finding {
+	findings := []Finding{}
+	add := func(sev Severity, check HeaderCheck, format string, a ...interface{}) {
+		findings = append(findings, Finding{Severity: sev, Check: check, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if h.Archaic {
+		add(SV_WARNING, HC_JUNK, "Junk in bytes 7-15, byte 7 is ignored")
+	} else if !h.Nes2 && len(h.Ines1Extra) > 0 && !allZero(h.Ines1Extra[3:]) {
+		add(SV_WARNING, HC_JUNK, "Junk in unused iNES bytes 11-15: % X", h.Ines1Extra[3:])
+	}
+
+	if h.PrgSize == 0 {
+		add(SV_ERROR, HC_PRG_SIZE, "No PRG ROM")
+	} else if !isPowerOfTwo(h.PrgSize) {
+		add(SV_WARNING, HC_PRG_SIZE, "PRG size is not a power of two: %d", h.PrgSize)
+	}
+
+	if h.ChrSize > 0 && !isPowerOfTwo(h.ChrSize) {
+		add(SV_WARNING, HC_CHR_SIZE, "CHR size is not a power of two: %d", h.ChrSize)
+	}
+
+	if max, ok := mapperMaxSizes[h.Mapper]; ok {
+		if h.PrgSize > max[0] {
+			add(SV_ERROR, HC_PRG_SIZE, "PRG size too large for mapper %d: %d", h.Mapper, h.PrgSize)
+		}
+
+		if h.ChrSize > max[1] {
+			add(SV_ERROR, HC_CHR_SIZE, "CHR size too large for mapper %d: %d", h.Mapper, h.ChrSize)
+		}
+	}
+
+	if h.TrainerPresent && !trainerMappers[h.Mapper] {
+		add(SV_WARNING, HC_TRAINER, "Trainer on mapper %d, which never used one", h.Mapper)
+	}
+
+	if h.AltNametables && h.Mirroring == M_VERTICAL && !fourScreenMappers[h.Mapper] {
+		add(SV_WARNING, HC_FOUR_SCREEN, "Four-screen and vertical mirroring are both set")
+	}
+
+	if h.Nes2 {
+		if noPrgRamMappers[h.Mapper] && (h.PrgRamSize > 0 || h.PrgNvramSize > 0) {
+			add(SV_WARNING, HC_RAM, "PRG RAM on mapper %d, which has none", h.Mapper)
+		}
+
+		if h.PersistentMemory && h.PrgNvramSize == 0 && h.ChrNvramSize == 0 {
+			add(SV_WARNING, HC_RAM, "Battery set without any NVRAM")
+		}
+
+		if !h.PersistentMemory && (h.PrgNvramSize > 0 || h.ChrNvramSize > 0) {
+			add(SV_WARNING, HC_RAM, "NVRAM without the battery bit")
+		}
+
+		if h.ChrSize == 0 && h.ChrRamSize == 0 && h.ChrNvramSize == 0 {
+			add(SV_WARNING, HC_RAM, "No CHR ROM or CHR RAM")
+		}
+
+		if h.Console != CT_VSSYSTEM && h.Console != CT_EXTENDED && (h.VsPpu != 0 || h.VsHardware != 0 || h.ExtendedConsole != 0) {
+			add(SV_WARNING, HC_RESERVED, "Byte 13 set for console type %s", h.Console)
+		}
+	}
+
+	return findings
+}
+
+// ValidateInes validates a complete iNES file.  Along with the header checks
+// this checks reserved bits that ParseHeader() drops and that the file length
+// matches the header.
+func ValidateInes(raw []byte) []Finding {
+	if len(raw) < 16 || !bytes.Equal(raw[:4], []byte{0x4E, 0x45, 0x53, 0x1A}) {
+		return []Finding{{SV_ERROR, HC_HEADER, "Not an iNES file"}}
+	}
+
+	h, err := ParseHeader(raw)
+	if err != nil {
+		return []Finding{{SV_ERROR, HC_HEADER, err.Error()}}
+	}
+
+	findings := h.Validate()
+	add := func(sev Severity, check HeaderCheck, format string, a ...interface{}) {
+		findings = append(findings, Finding{Severity: sev, Check: check, Message: fmt.Sprintf(format, a...)})
+	}
+
+	if h.Nes2 {
+		if raw[12]&0xFC != 0 || raw[14]&0xFC != 0 || raw[15]&0xC0 != 0 {
+			add(SV_WARNING, HC_RESERVED, "Reserved bits set in bytes 12-15: % X", raw[12:16])
+		}
+
+		if h.Console != CT_VSSYSTEM && h.Console != CT_EXTENDED && raw[13] != 0 {
+			add(SV_WARNING, HC_RESERVED, "Byte 13 is $%02X for console type %s", raw[13], h.Console)
+		}
+	}
+
+	size := uint(16) + h.PrgSize + h.ChrSize + h.MiscSize
+	if h.TrainerPresent {
+		size += 512
+	}
+
+	switch {
+	case uint(len(raw)) < size:
+		add(SV_ERROR, HC_FILE_SIZE, "File is %d bytes, header expects %d", len(raw), size)
+	case uint(len(raw)) > size && !(h.Nes2 && h.MiscRomCount > 0):
+		add(SV_WARNING, HC_FILE_SIZE, "File is %d bytes, header expects %d", len(raw), size)
+	}
+
+	return findings
+}
+
+// HasErrors returns true if any of the findings are errors.
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Severity == SV_ERROR {
+			return true
+		}
+	}
+	return false
+}
+
+func isPowerOfTwo(val uint) bool {
+	return val != 0 && val&(val-1) == 0
+}
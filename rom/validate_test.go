@@ -0,0 +1,56 @@
+package rom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestValidateInes(t *testing.T) {
+	rom := func(header string, size int) []byte {
+		return append([]byte(header), make([]byte, size)...)
+	}
+
+	tests := []struct {
+		name   string
+		raw    []byte
+		checks []HeaderCheck
+		errors bool
+	}{
+		{"clean", rom("NES\x1A\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00", 40*1024), nil, false},
+		{"short file", rom("NES\x1A\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00", 32*1024), []HeaderCheck{HC_FILE_SIZE}, true},
+		{"diskdude", rom("NES\x1A\x02\x01\x01DiskDude!", 40*1024), []HeaderCheck{HC_JUNK}, false},
+		{"trainer", rom("NES\x1A\x02\x01\x74\x00\x00\x00\x00\x00\x00\x00\x00\x00", 40*1024+512), []HeaderCheck{HC_TRAINER}, false},
+		{"prg size", rom("NES\x1A\x03\x01\x10\x00\x00\x00\x00\x00\x00\x00\x00\x00", 56*1024), []HeaderCheck{HC_PRG_SIZE}, false},
+		{"nrom too large", rom("NES\x1A\x04\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00", 72*1024), []HeaderCheck{HC_PRG_SIZE}, true},
+		{"four-screen vertical", rom("NES\x1A\x02\x01\x09\x00\x00\x00\x00\x00\x00\x00\x00\x00", 40*1024), []HeaderCheck{HC_FOUR_SCREEN}, false},
+		{"ram", rom("NES\x1A\x02\x00\x20\x08\x00\x00\x70\x07\x00\x00\x00\x00", 32*1024), []HeaderCheck{HC_RAM}, false},
+		{"reserved", rom("NES\x1A\x02\x01\x00\x08\x00\x00\x00\x00\x04\x00\x00\x00", 40*1024), []HeaderCheck{HC_RESERVED}, false},
+	}
+
+	for _, tt := range tests {
+		findings := ValidateInes(tt.raw)
+
+		found := map[HeaderCheck]bool{}
+		for _, f := range findings {
+			found[f.Check] = true
+		}
+
+		if len(tt.checks) == 0 && len(findings) > 0 {
+			t.Errorf("[%s] unexpected findings: %v", tt.name, findings)
+		}
+
+		for _, c := range tt.checks {
+			if !found[c] {
+				t.Errorf("[%s] missing %s finding: %v", tt.name, c, findings)
+			}
+		}
+
+		if HasErrors(findings) != tt.errors {
+			t.Errorf("[%s] expected errors: %t, found: %v", tt.name, tt.errors, findings)
+		}
+	}
+
+	if _, err := ReadInes(bytes.NewReader([]byte("NES\x1A"))); err == nil {
+		t.Errorf("Expected an error for a short file")
+	}
+}
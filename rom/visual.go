@@ -20,6 +20,7 @@ const (
 	UP_JMPCODE byte = 0x10 // Indirect code; via JMP instruction
 	UP_LDADATA byte = 0x20 // Indirect data; via LDA instruction
 	UP_AUDIO   byte = 0x40
+	UP_ENTRY   byte = 0x80 // Subroutine entry point (Mesen only)
 	UP_UNKNOWN byte = 0x00
 
 	// CHR ROM
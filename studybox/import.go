@@ -73,7 +73,7 @@ func importPackets(jdata []jsonData) ([]Packet, error) {
 			}
 
 			if data.File == "" {
-				fmt.Println("[WARN] No script file given in data element %d\n", idx)
+				fmt.Printf("[WARN] No script file given in data element %d\n", idx)
 			}
 
 			packets = append(packets, newPacketWorkRamLoad(uint8(data.Values[0]), uint8(data.Values[1])))
@@ -92,7 +92,7 @@ func importPackets(jdata []jsonData) ([]Packet, error) {
 			}
 
 			if data.File == "" {
-				fmt.Println("[WARN] No script file given in data element %d\n", idx)
+				fmt.Printf("[WARN] No script file given in data element %d\n", idx)
 			}
 
 			packets = append(packets, newPacketMarkDataStart(packet_Nametable, uint8(data.Values[0]), uint8(data.Values[1])))